@@ -10,6 +10,9 @@ import (
 // Only Marathon apps with this label will be registered in Consul
 const MarathonConsulLabel = "consul"
 
+// Label naming the alias of the Consul ACL token services of the app are registered with
+const ConsulTokenAliasLabel = "consul-token-alias"
+
 type HealthCheck struct {
 	Path                   string `json:"path"`
 	PortIndex              int    `json:"portIndex"`
@@ -24,11 +27,22 @@ type HealthCheck struct {
 	}
 }
 
+type ReadinessCheck struct {
+	Name string `json:"name"`
+}
+
+type ReadinessCheckResult struct {
+	Name   string `json:"name"`
+	TaskID TaskID `json:"taskId"`
+	Ready  bool   `json:"ready"`
+}
+
 type PortDefinition struct {
+	Name   string            `json:"name"`
 	Labels map[string]string `json:"labels"`
 }
 
-type appWrapper struct {
+type AppResponse struct {
 	App App `json:"app"`
 }
 
@@ -42,6 +56,19 @@ type App struct {
 	ID              AppID             `json:"id"`
 	Tasks           []Task            `json:"tasks"`
 	PortDefinitions []PortDefinition  `json:"portDefinitions"`
+	// Values are strings or objects referencing secrets
+	Env         map[string]interface{} `json:"env"`
+	VersionInfo VersionInfo            `json:"versionInfo"`
+	// Results are reported by Marathon only during deployments, when apps.readiness is embedded
+	ReadinessChecks       []ReadinessCheck       `json:"readinessChecks"`
+	ReadinessCheckResults []ReadinessCheckResult `json:"readinessCheckResults"`
+	// set by registration rules applied to the app
+	selection selection
+}
+
+type VersionInfo struct {
+	LastConfigChangeAt string `json:"lastConfigChangeAt"`
+	LastScalingAt      string `json:"lastScalingAt"`
 }
 
 // Marathon Application Id (aka PathId)
@@ -53,11 +80,75 @@ func (id AppID) String() string {
 	return string(id)
 }
 
+// Parts returns segments of the id, e.g. [rootGroup subGroup name]
+func (id AppID) Parts() []string {
+	trimmed := strings.Trim(id.String(), "/")
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// Last returns the last segment of the id, i.e. application name without groups
+func (id AppID) Last() string {
+	parts := id.Parts()
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// InGroup tells whether the app belongs to the group or any of its subgroups
+func (id AppID) InGroup(group AppID) bool {
+	groupParts := group.Parts()
+	parts := id.Parts()
+	if len(parts) < len(groupParts) {
+		return false
+	}
+	for i, part := range groupParts {
+		if parts[i] != part {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseGroups parses a comma separated list of group ids, e.g. /prod,/team/services
+func ParseGroups(groups string) []AppID {
+	var ids []AppID
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.Trim(strings.TrimSpace(group), "/"); group != "" {
+			ids = append(ids, AppID("/"+group))
+		}
+	}
+	return ids
+}
+
+// RunsCurrentConfig tells whether the task was started with the current configuration of the app.
+// Tasks started before the last configuration change (e.g. during a deployment) may need registrations
+// different from the ones resulting from the current configuration. Versions are ISO 8601 timestamps.
+func (app App) RunsCurrentConfig(task *Task) bool {
+	return app.VersionInfo.LastConfigChangeAt == "" || task.Version >= app.VersionInfo.LastConfigChangeAt
+}
+
+// IsConsulApp tells whether tasks of the app are registered, i.e. it has the consul label
+// or it is selected by registration rules
 func (app App) IsConsulApp() bool {
+	switch app.selection {
+	case includedByRule:
+		return true
+	case excludedByRule:
+		return false
+	}
 	_, ok := app.Labels[MarathonConsulLabel]
 	return ok
 }
 
+// ConsulTokenAlias returns the alias of the Consul ACL token of the app, empty when the default token is used
+func (app App) ConsulTokenAlias() string {
+	return strings.TrimSpace(app.Labels[ConsulTokenAliasLabel])
+}
+
 func (app App) labelsToRawName(labels map[string]string) string {
 	if value, ok := labels[MarathonConsulLabel]; ok && !isSpecialConsulNameValue(value) {
 		return value
@@ -77,16 +168,18 @@ func ParseApps(jsonBlob []byte) ([]*App, error) {
 }
 
 func ParseApp(jsonBlob []byte) (*App, error) {
-	wrapper := &appWrapper{}
+	wrapper := &AppResponse{}
 	err := json.Unmarshal(jsonBlob, wrapper)
 
 	return &wrapper.App, err
 }
 
 type RegistrationIntent struct {
-	Name string
-	Port int
-	Tags []string
+	Name    string
+	Port    int
+	Tags    []string
+	Connect *Connect
+	Options RegistrationOptions
 }
 
 func (app App) RegistrationIntentsNumber() int {
@@ -102,16 +195,20 @@ func (app App) RegistrationIntentsNumber() int {
 	return len(definitions)
 }
 
-func (app App) RegistrationIntents(task *Task, nameSeparator string) []RegistrationIntent {
-	commonTags := labelsToTags(app.Labels)
+// RegistrationIntents returns services that should be registered for the task. Names are built with
+// nameSeparator, labels prefixed with tagLabelPrefix are converted to key=value tags (empty prefix disables it).
+func (app App) RegistrationIntents(task *Task, nameSeparator string, tagLabelPrefix string) []RegistrationIntent {
 	taskPortsCount := len(task.Ports)
 	definitions := app.findConsulPortDefinitions()
 	if len(definitions) == 0 && taskPortsCount != 0 {
+		data := app.templateData(task, 0, nil)
 		return []RegistrationIntent{
 			{
-				Name: app.labelsToName(app.Labels, nameSeparator),
-				Port: task.Ports[0],
-				Tags: commonTags,
+				Name:    app.labelsToName(app.Labels, nameSeparator, data),
+				Port:    task.Ports[0],
+				Tags:    labelsToTags(tagLabelPrefix, data, app.Labels),
+				Connect: app.connect(task, nil),
+				Options: app.registrationOptions(nil),
 			},
 		}
 	}
@@ -122,10 +219,13 @@ func (app App) RegistrationIntents(task *Task, nameSeparator string) []Registrat
 			log.WithField("Id", task.ID.String()).Warnf("Port index (%d) out of bounds should be from range [0,%d)", d.Index, taskPortsCount)
 			continue
 		}
+		data := app.templateData(task, d.Index, d.Labels)
 		intents = append(intents, RegistrationIntent{
-			Name: app.labelsToName(d.Labels, nameSeparator),
-			Port: task.Ports[d.Index],
-			Tags: append(commonTags, labelsToTags(d.Labels)...),
+			Name:    app.labelsToName(d.Labels, nameSeparator, data),
+			Port:    task.Ports[d.Index],
+			Tags:    labelsToTags(tagLabelPrefix, data, app.Labels, d.Labels),
+			Connect: app.connect(task, d.Labels),
+			Options: app.registrationOptions(d.Labels),
 		})
 	}
 	return intents
@@ -135,19 +235,14 @@ func marathonAppNameToServiceName(name string, nameSeparator string) string {
 	return strings.Replace(strings.Trim(strings.TrimSpace(name), "/"), "/", nameSeparator, -1)
 }
 
-func labelsToTags(labels map[string]string) []string {
-	tags := []string{}
-	for key, value := range labels {
-		if value == "tag" {
-			tags = append(tags, key)
-		}
-	}
-	return tags
-}
-
-func (app App) labelsToName(labels map[string]string, nameSeparator string) string {
+func (app App) labelsToName(labels map[string]string, nameSeparator string, data TemplateData) string {
 	appConsulName := app.labelsToRawName(labels)
-	serviceName := marathonAppNameToServiceName(appConsulName, nameSeparator)
+	serviceName := ""
+	if !isTemplate(appConsulName) {
+		serviceName = marathonAppNameToServiceName(appConsulName, nameSeparator)
+	} else if rendered, err := render(appConsulName, data); err == nil {
+		serviceName = sanitizeServiceName(marathonAppNameToServiceName(rendered, nameSeparator), nameSeparator)
+	}
 	if serviceName == "" {
 		log.WithField("AppId", app.ID.String()).WithField("ConsulServiceName", appConsulName).
 			Warn("Warning! Invalid Consul service name provided for app. Will use default app name instead.")
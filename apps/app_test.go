@@ -25,20 +25,25 @@ func TestParseApps(t *testing.T) {
 					MaxConsecutiveFailures: 3,
 				},
 			},
-			ID: "/bridged-webapp",
+			ID:  "/bridged-webapp",
+			Env: map[string]interface{}{},
 			Tasks: []Task{
 				{
 					ID:                 "test.47de43bd-1a81-11e5-bdb6-e6cb6734eaf8",
 					AppID:              "/test",
 					Host:               "192.168.2.114",
 					Ports:              []int{31315},
-					HealthCheckResults: []HealthCheckResult{{Alive: true}},
+					HealthCheckResults: []HealthCheckResult{{Alive: true, LastSuccess: "2015-11-30T10:08:19.477Z"}},
+					Version:            "2015-06-24T14:56:57.466Z",
+					StartedAt:          "2015-06-24T14:57:06.466Z",
 				},
 				{
-					ID:    "test.4453212c-1a81-11e5-bdb6-e6cb6734eaf8",
-					AppID: "/test",
-					Host:  "192.168.2.114",
-					Ports: []int{31797},
+					ID:        "test.4453212c-1a81-11e5-bdb6-e6cb6734eaf8",
+					AppID:     "/test",
+					Host:      "192.168.2.114",
+					Ports:     []int{31797},
+					Version:   "2015-06-24T14:56:57.466Z",
+					StartedAt: "2015-06-24T14:57:00.611Z",
 				},
 			},
 		},
@@ -75,7 +80,8 @@ func TestParseApp(t *testing.T) {
 				MaxConsecutiveFailures: 3,
 			},
 		},
-		ID: "/myapp",
+		ID:  "/myapp",
+		Env: map[string]interface{}{},
 		Tasks: []Task{{
 			ID:    "myapp.cc49ccc1-9812-11e5-a06e-56847afe9799",
 			AppID: "/myapp",
@@ -84,7 +90,9 @@ func TestParseApp(t *testing.T) {
 				31679,
 				31680,
 				31681},
-			HealthCheckResults: []HealthCheckResult{{Alive: true}}},
+			HealthCheckResults: []HealthCheckResult{{Alive: true, LastSuccess: "2015-12-01T10:03:42.324Z"}},
+			Version:            "2015-12-01T10:03:32.003Z",
+			StartedAt:          "2015-12-01T10:03:40.966Z"},
 			{
 				ID:    "myapp.c8b449f0-9812-11e5-a06e-56847afe9799",
 				AppID: "/myapp",
@@ -93,7 +101,9 @@ func TestParseApp(t *testing.T) {
 					31308,
 					31309,
 					31310},
-				HealthCheckResults: []HealthCheckResult{{Alive: true}}}}}
+				HealthCheckResults: []HealthCheckResult{{Alive: true, LastSuccess: "2015-12-01T10:03:42.337Z"}},
+				Version:            "2015-12-01T10:03:32.003Z",
+				StartedAt:          "2015-12-01T10:03:34.945Z"}}}
 
 	app, err := ParseApp(appBlob)
 	assert.NoError(t, err)
@@ -128,6 +138,14 @@ func TestConsulApp(t *testing.T) {
 	assert.False(t, app.IsConsulApp())
 }
 
+func TestConsulTokenAlias(t *testing.T) {
+	t.Parallel()
+
+	// expect
+	assert.Equal(t, "payments", (&App{Labels: map[string]string{"consul-token-alias": " payments "}}).ConsulTokenAlias())
+	assert.Equal(t, "", (&App{Labels: map[string]string{"consul": ""}}).ConsulTokenAlias())
+}
+
 func TestAppId_String(t *testing.T) {
 	t.Parallel()
 	assert.Equal(t, "appId", AppID("appId").String())
@@ -147,7 +165,7 @@ func TestRegistrationIntent_NameWithoutConsulLabel(t *testing.T) {
 	}
 
 	// when
-	intent := app.RegistrationIntents(dummyTask, ".")[0]
+	intent := app.RegistrationIntents(dummyTask, ".", "consul-tag-")[0]
 
 	// then
 	assert.Equal(t, "rootGroup.subGroup.subSubGroup.name", intent.Name)
@@ -174,7 +192,7 @@ func TestRegistrationIntent_Name(t *testing.T) {
 		}
 
 		// when
-		intent := app.RegistrationIntents(dummyTask, "-")[0]
+		intent := app.RegistrationIntents(dummyTask, "-", "consul-tag-")[0]
 
 		// then
 		if intent.Name != testData.expectedName {
@@ -195,7 +213,7 @@ func TestRegistrationIntent_PickFirstPort(t *testing.T) {
 	}
 
 	// when
-	intent := app.RegistrationIntents(task, "-")[0]
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
 
 	// then
 	assert.Equal(t, 1234, intent.Port)
@@ -211,7 +229,7 @@ func TestRegistrationIntent_WithTags(t *testing.T) {
 	}
 
 	// when
-	intent := app.RegistrationIntents(dummyTask, "-")[0]
+	intent := app.RegistrationIntents(dummyTask, "-", "consul-tag-")[0]
 
 	// then
 	assert.Equal(t, []string{"private"}, intent.Tags)
@@ -236,7 +254,7 @@ func TestRegistrationIntent_NoOverrideViaPortDefinitionsIfNoConsulLabelThere(t *
 	}
 
 	// when
-	intents := app.RegistrationIntents(task, "-")
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
 
 	// then
 	assert.Len(t, intents, 1)
@@ -264,7 +282,7 @@ func TestRegistrationIntent_DontPanicIfTaskHasNoPorts(t *testing.T) {
 	}
 
 	// when
-	intents := app.RegistrationIntents(task, "-")
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
 
 	// then
 	assert.Empty(t, intents)
@@ -289,13 +307,13 @@ func TestRegistrationIntent_OverrideNameAndAddTagsViaPortDefinitions(t *testing.
 	}
 
 	// when
-	intents := app.RegistrationIntents(task, "-")
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
 
 	// then
 	assert.Len(t, intents, 1)
 	assert.Equal(t, "other-name", intents[0].Name)
 	assert.Equal(t, 1234, intents[0].Port)
-	assert.Equal(t, []string{"private", "other"}, intents[0].Tags)
+	assert.Equal(t, []string{"other", "private"}, intents[0].Tags)
 }
 
 func TestRegistrationIntent_PickDifferentPortViaPortDefinitions(t *testing.T) {
@@ -317,7 +335,7 @@ func TestRegistrationIntent_PickDifferentPortViaPortDefinitions(t *testing.T) {
 	}
 
 	// when
-	intent := app.RegistrationIntents(task, "-")[0]
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
 
 	// then
 	assert.Equal(t, 5678, intent.Port)
@@ -344,7 +362,7 @@ func TestRegistrationIntent_MultipleIntentsViaPortDefinitionIfMultipleContainCon
 	}
 
 	// when
-	intents := app.RegistrationIntents(task, "-")
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
 
 	// then
 	assert.Len(t, intents, 2)
@@ -377,7 +395,7 @@ func TestRegistrationIntent_TaskHasLessPortsThanApp(t *testing.T) {
 	}
 
 	// when
-	intents := app.RegistrationIntents(task, "-")
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
 
 	// then
 	assert.Len(t, intents, 1)
@@ -427,3 +445,23 @@ var registrationIntentsNumberTestCases = []struct {
 		},
 	}, 2},
 }
+
+func TestAppId_InGroup(t *testing.T) {
+	t.Parallel()
+
+	// expect
+	assert.True(t, AppID("/prod/app").InGroup("/prod"))
+	assert.True(t, AppID("/prod/team/app").InGroup("/prod"))
+	assert.True(t, AppID("/prod/app").InGroup("/"))
+	assert.False(t, AppID("/production/app").InGroup("/prod"))
+	assert.False(t, AppID("/prod").InGroup("/prod/team"))
+}
+
+func TestParseGroups(t *testing.T) {
+	t.Parallel()
+
+	// expect
+	assert.Equal(t, []AppID{"/prod", "/team/services"}, ParseGroups(" /prod/, team/services,,"))
+	assert.Empty(t, ParseGroups(""))
+	assert.Empty(t, ParseGroups("/"))
+}
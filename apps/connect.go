@@ -0,0 +1,103 @@
+package apps
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Apps (or port definitions) with this label are registered in Consul Connect service mesh.
+// Empty value (or "sidecar") registers a sidecar proxy along with the service, "native" marks the service
+// as Connect-native, i.e. handling mTLS on its own.
+const ConnectLabel = "consul-connect"
+
+// Port definition with this label is used as the port of the sidecar proxy running within the task.
+// When there is no such port definition, Consul assigns the sidecar port on its own.
+const ConnectProxyLabel = "consul-connect-proxy"
+
+// Labels with this prefix declare upstreams of the sidecar proxy, e.g. consul-upstream-db=5432
+// makes service db available on localhost:5432
+const UpstreamLabelPrefix = "consul-upstream-"
+
+type Connect struct {
+	Native      bool
+	SidecarPort int
+	Upstreams   []Upstream
+}
+
+type Upstream struct {
+	DestinationName string
+	LocalBindPort   int
+}
+
+func (app App) connect(task *Task, portLabels map[string]string) *Connect {
+	mode, ok := portLabels[ConnectLabel]
+	if !ok {
+		mode, ok = app.Labels[ConnectLabel]
+	}
+	if !ok {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "native":
+		return &Connect{Native: true}
+	case "", "true", "sidecar":
+		return &Connect{
+			SidecarPort: app.connectProxyPort(task),
+			Upstreams:   upstreams(task, app.Labels, portLabels),
+		}
+	case "false":
+		return nil
+	default:
+		log.WithField("Id", task.ID.String()).WithField("Value", mode).
+			Warnf("Unrecognized %s label value, skipping Connect registration", ConnectLabel)
+		return nil
+	}
+}
+
+func (app App) connectProxyPort(task *Task) int {
+	for i, d := range app.PortDefinitions {
+		if _, ok := d.Labels[ConnectProxyLabel]; !ok {
+			continue
+		}
+		if i >= len(task.Ports) {
+			log.WithField("Id", task.ID.String()).Warnf("Connect proxy port index (%d) out of bounds should be from range [0,%d)", i, len(task.Ports))
+			return 0
+		}
+		return task.Ports[i]
+	}
+	return 0
+}
+
+// upstreams merges upstreams declared in app and port definition labels,
+// the latter taking precedence when both declare the same destination
+func upstreams(task *Task, appLabels map[string]string, portLabels map[string]string) []Upstream {
+	ports := make(map[string]int)
+	for _, labels := range []map[string]string{appLabels, portLabels} {
+		for key, value := range labels {
+			if !strings.HasPrefix(key, UpstreamLabelPrefix) {
+				continue
+			}
+			name := strings.TrimPrefix(key, UpstreamLabelPrefix)
+			port, err := strconv.Atoi(strings.TrimSpace(value))
+			if name == "" || err != nil || port < 1 || port > 65535 {
+				log.WithField("Id", task.ID.String()).WithField("Label", key).WithField("Value", value).
+					Warn("Invalid upstream declaration, expected a local port number")
+				continue
+			}
+			ports[name] = port
+		}
+	}
+	var names []string
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var upstreams []Upstream
+	for _, name := range names {
+		upstreams = append(upstreams, Upstream{DestinationName: name, LocalBindPort: ports[name]})
+	}
+	return upstreams
+}
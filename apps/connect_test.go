@@ -0,0 +1,143 @@
+package apps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationIntent_WithoutConnect(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": "", "consul-upstream-db": "5432"},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Nil(t, intent.Connect)
+}
+
+func TestRegistrationIntent_ConnectSidecar(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "app-name",
+		Labels: map[string]string{
+			"consul":                "",
+			"consul-connect":        "",
+			"consul-upstream-db":    "5432",
+			"consul-upstream-cache": "6379",
+			"consul-upstream-bad":   "not-a-port",
+			"consul-upstream-":      "1234",
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, &Connect{
+		Upstreams: []Upstream{
+			{DestinationName: "cache", LocalBindPort: 6379},
+			{DestinationName: "db", LocalBindPort: 5432},
+		},
+	}, intent.Connect)
+}
+
+func TestRegistrationIntent_ConnectSidecarWithProxyPort(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": "", "consul-connect": "sidecar", "consul-upstream-db": "5432"},
+		PortDefinitions: []PortDefinition{
+			{Labels: map[string]string{"consul": ""}},
+			{Labels: map[string]string{"consul-connect-proxy": ""}},
+			{Labels: map[string]string{"consul": "other", "consul-connect": "native"}},
+		},
+	}
+	task := &Task{Ports: []int{1234, 5678, 9012}}
+
+	// when
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
+
+	// then
+	assert.Len(t, intents, 2)
+	assert.Equal(t, &Connect{
+		SidecarPort: 5678,
+		Upstreams:   []Upstream{{DestinationName: "db", LocalBindPort: 5432}},
+	}, intents[0].Connect)
+	assert.Equal(t, &Connect{Native: true}, intents[1].Connect)
+}
+
+func TestRegistrationIntent_ConnectUpstreamsOverriddenViaPortDefinition(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": "", "consul-connect": "", "consul-upstream-db": "5432"},
+		PortDefinitions: []PortDefinition{
+			{Labels: map[string]string{"consul": "", "consul-upstream-db": "15432", "consul-upstream-cache": "6379"}},
+			{Labels: map[string]string{"consul": "other", "consul-connect": "false"}},
+		},
+	}
+	task := &Task{Ports: []int{1234, 5678}}
+
+	// when
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
+
+	// then
+	assert.Equal(t, []Upstream{
+		{DestinationName: "cache", LocalBindPort: 6379},
+		{DestinationName: "db", LocalBindPort: 15432},
+	}, intents[0].Connect.Upstreams)
+	assert.Nil(t, intents[1].Connect)
+}
+
+func TestRegistrationIntent_UnknownConnectMode(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": "", "consul-connect": "mesh-please"},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Nil(t, intent.Connect)
+}
+
+func TestRegistrationIntent_ConnectProxyPortOutOfBounds(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": "", "consul-connect": ""},
+		PortDefinitions: []PortDefinition{
+			{},
+			{Labels: map[string]string{"consul-connect-proxy": ""}},
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, 0, intent.Connect.SidecarPort)
+}
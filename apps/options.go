@@ -0,0 +1,155 @@
+package apps
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Labels tuning service registrations. They can be set on the application or on a port definition,
+// the latter taking precedence.
+const (
+	WeightPassingLabel                       = "consul-weight-passing"
+	WeightWarningLabel                       = "consul-weight-warning"
+	EnableTagOverrideLabel                   = "consul-enable-tag-override"
+	CheckDeregisterCriticalServiceAfterLabel = "consul-check-deregister-critical-service-after"
+	CheckNotesLabel                          = "consul-check-notes"
+	CheckTLSSkipVerifyLabel                  = "consul-check-tls-skip-verify"
+	// Labels with this prefix add HTTP headers to HTTP health checks, e.g. consul-check-header-Host=example.com
+	CheckHeaderLabelPrefix = "consul-check-header-"
+)
+
+// Consul uses weight 1 for both states when weights are not specified
+const defaultWeight = 1
+
+type RegistrationOptions struct {
+	Weights           *Weights
+	EnableTagOverride bool
+	Check             CheckOptions
+}
+
+type Weights struct {
+	Passing int
+	Warning int
+}
+
+type CheckOptions struct {
+	DeregisterCriticalServiceAfter string
+	Notes                          string
+	// TLSSkipVerify and Header apply only to HTTP checks
+	TLSSkipVerify bool
+	Header        map[string][]string
+}
+
+type optionLabels struct {
+	id     AppID
+	labels []map[string]string
+}
+
+func (app App) registrationOptions(portLabels map[string]string) RegistrationOptions {
+	l := optionLabels{id: app.ID, labels: []map[string]string{portLabels, app.Labels}}
+	return RegistrationOptions{
+		Weights:           l.weights(),
+		EnableTagOverride: l.boolean(EnableTagOverrideLabel),
+		Check: CheckOptions{
+			DeregisterCriticalServiceAfter: l.duration(CheckDeregisterCriticalServiceAfterLabel),
+			Notes:                          l.lookup(CheckNotesLabel),
+			TLSSkipVerify:                  l.boolean(CheckTLSSkipVerifyLabel),
+			Header:                         l.header(),
+		},
+	}
+}
+
+func (l optionLabels) lookup(key string) string {
+	value, _ := l.find(key)
+	return value
+}
+
+func (l optionLabels) find(key string) (string, bool) {
+	for _, labels := range l.labels {
+		if value, ok := labels[key]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func (l optionLabels) warn(key, value, message string) {
+	log.WithField("Id", l.id.String()).WithField("Label", key).WithField("Value", value).Warn(message)
+}
+
+func (l optionLabels) weights() *Weights {
+	passing, passingOk := l.weight(WeightPassingLabel, 1)
+	warning, warningOk := l.weight(WeightWarningLabel, 0)
+	if !passingOk && !warningOk {
+		return nil
+	}
+	return &Weights{Passing: passing, Warning: warning}
+}
+
+func (l optionLabels) weight(key string, min int) (int, bool) {
+	value, ok := l.find(key)
+	if !ok {
+		return defaultWeight, false
+	}
+	weight, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || weight < min {
+		l.warn(key, value, "Invalid weight, expected an integer not lower than "+strconv.Itoa(min))
+		return defaultWeight, false
+	}
+	return weight, true
+}
+
+func (l optionLabels) boolean(key string) bool {
+	value, ok := l.find(key)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		l.warn(key, value, "Invalid boolean, expected true or false")
+		return false
+	}
+	return b
+}
+
+func (l optionLabels) duration(key string) string {
+	value, ok := l.find(key)
+	if !ok {
+		return ""
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || d <= 0 {
+		l.warn(key, value, "Invalid duration, expected a positive value like 90s or 10m")
+		return ""
+	}
+	if d < time.Minute {
+		l.warn(key, value, "Duration shorter than a minute will be rounded up to 1m by Consul")
+	}
+	return d.String()
+}
+
+// header merges headers declared in app and port definition labels,
+// the latter taking precedence when both declare the same header
+func (l optionLabels) header() map[string][]string {
+	header := make(map[string][]string)
+	for i := len(l.labels) - 1; i >= 0; i-- {
+		for key, value := range l.labels[i] {
+			if !strings.HasPrefix(key, CheckHeaderLabelPrefix) {
+				continue
+			}
+			name := strings.TrimPrefix(key, CheckHeaderLabelPrefix)
+			if name == "" || strings.ContainsAny(name, " :\t") {
+				l.warn(key, value, "Invalid header name")
+				continue
+			}
+			header[name] = []string{value}
+		}
+	}
+	if len(header) == 0 {
+		return nil
+	}
+	return header
+}
@@ -0,0 +1,144 @@
+package apps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationIntent_DefaultOptions(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": ""},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, RegistrationOptions{}, intent.Options)
+}
+
+func TestRegistrationIntent_OptionsFromAppLabels(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "app-name",
+		Labels: map[string]string{
+			"consul":                     "",
+			"consul-weight-passing":      "10",
+			"consul-weight-warning":      "2",
+			"consul-enable-tag-override": "true",
+			"consul-check-deregister-critical-service-after": "90s",
+			"consul-check-notes":                             "See runbook",
+			"consul-check-tls-skip-verify":                   "1",
+			"consul-check-header-Host":                       "example.com",
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, RegistrationOptions{
+		Weights:           &Weights{Passing: 10, Warning: 2},
+		EnableTagOverride: true,
+		Check: CheckOptions{
+			DeregisterCriticalServiceAfter: "1m30s",
+			Notes:                          "See runbook",
+			TLSSkipVerify:                  true,
+			Header:                         map[string][]string{"Host": {"example.com"}},
+		},
+	}, intent.Options)
+}
+
+func TestRegistrationIntent_OptionsOverriddenViaPortDefinition(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "app-name",
+		Labels: map[string]string{
+			"consul":                     "",
+			"consul-weight-passing":      "10",
+			"consul-enable-tag-override": "true",
+			"consul-check-header-Host":   "example.com",
+			"consul-check-header-X-Env":  "prod",
+		},
+		PortDefinitions: []PortDefinition{
+			{Labels: map[string]string{"consul": ""}},
+			{Labels: map[string]string{
+				"consul":                     "other",
+				"consul-weight-passing":      "3",
+				"consul-enable-tag-override": "false",
+				"consul-check-header-Host":   "other.example.com",
+			}},
+		},
+	}
+	task := &Task{Ports: []int{1234, 5678}}
+
+	// when
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
+
+	// then
+	assert.Equal(t, &Weights{Passing: 10, Warning: 1}, intents[0].Options.Weights)
+	assert.True(t, intents[0].Options.EnableTagOverride)
+	assert.Equal(t, map[string][]string{"Host": {"example.com"}, "X-Env": {"prod"}}, intents[0].Options.Check.Header)
+	assert.Equal(t, &Weights{Passing: 3, Warning: 1}, intents[1].Options.Weights)
+	assert.False(t, intents[1].Options.EnableTagOverride)
+	assert.Equal(t, map[string][]string{"Host": {"other.example.com"}, "X-Env": {"prod"}}, intents[1].Options.Check.Header)
+}
+
+func TestRegistrationIntent_InvalidOptionsAreIgnored(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "app-name",
+		Labels: map[string]string{
+			"consul":                     "",
+			"consul-weight-passing":      "0",
+			"consul-weight-warning":      "-1",
+			"consul-enable-tag-override": "yes please",
+			"consul-check-deregister-critical-service-after": "forever",
+			"consul-check-tls-skip-verify":                   "maybe",
+			"consul-check-header-":                           "value",
+			"consul-check-header-Bad Name":                   "value",
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, RegistrationOptions{}, intent.Options)
+}
+
+func TestRegistrationIntent_NegativeDeregisterCriticalServiceAfterIsIgnored(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "app-name",
+		Labels: map[string]string{
+			"consul": "",
+			"consul-check-deregister-critical-service-after": "-5m",
+			"consul-weight-warning":                          "0",
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, "", intent.Options.Check.DeregisterCriticalServiceAfter)
+	assert.Equal(t, &Weights{Passing: 1, Warning: 0}, intent.Options.Weights)
+}
@@ -0,0 +1,101 @@
+package apps
+
+import (
+	"strings"
+	"time"
+)
+
+// Label selecting when tasks of the app are registered
+const RegistrationPolicyLabel = "consul-registration-policy"
+
+// Registration policies
+const (
+	// Register tasks once all their health checks pass
+	HealthyPolicy = "healthy"
+	// Register running tasks unless a health check fails. When the app defines health checks
+	// and Marathon has not reported their results yet, wait until the longest grace period elapses.
+	RunningPolicy = "running"
+	// Register tasks that are healthy (or running when the app has no health checks)
+	// and passed their readiness checks
+	ReadyPolicy = "ready"
+)
+
+const taskRunning = "TASK_RUNNING"
+
+// RegistrationPolicy decides whether a task of the app can be registered.
+// Both the sync and event handlers use it, so tasks are registered the same way regardless of the path.
+type RegistrationPolicy struct {
+	Name string
+	app  *App
+}
+
+func (app *App) RegistrationPolicy() RegistrationPolicy {
+	name := strings.TrimSpace(app.Labels[RegistrationPolicyLabel])
+	switch name {
+	case "":
+		name = HealthyPolicy
+	case HealthyPolicy, RunningPolicy, ReadyPolicy:
+	default:
+		l := optionLabels{id: app.ID}
+		l.warn(RegistrationPolicyLabel, name, "Unknown registration policy, expected one of: healthy, running, ready")
+		name = HealthyPolicy
+	}
+	return RegistrationPolicy{Name: name, app: app}
+}
+
+func (p RegistrationPolicy) CanRegister(task *Task) bool {
+	return p.canRegisterAt(task, time.Now())
+}
+
+func (p RegistrationPolicy) canRegisterAt(task *Task, now time.Time) bool {
+	// Marathon keeps reporting health of tasks being killed, their services must not be registered again
+	if task.State != "" && task.State != taskRunning {
+		return false
+	}
+	switch p.Name {
+	case RunningPolicy:
+		return p.isRunningAt(task, now)
+	case ReadyPolicy:
+		if len(p.app.HealthChecks) == 0 {
+			return p.isRunningAt(task, now) && p.isReady(task)
+		}
+		return task.IsHealthy() && p.isReady(task)
+	default:
+		return task.IsHealthy()
+	}
+}
+
+func (p RegistrationPolicy) isRunningAt(task *Task, now time.Time) bool {
+	if !task.IsRunning() || task.IsUnhealthy() {
+		return false
+	}
+	if len(p.app.HealthChecks) == 0 || len(task.HealthCheckResults) >= len(p.app.HealthChecks) {
+		return true
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, task.StartedAt)
+	if err != nil {
+		return false
+	}
+	return now.Sub(startedAt) >= p.gracePeriod()
+}
+
+// isReady tells whether none of readiness check results reported by Marathon marks the task as not ready.
+// Marathon reports the results only while a deployment is in progress, afterwards all tasks are ready.
+func (p RegistrationPolicy) isReady(task *Task) bool {
+	for _, result := range p.app.ReadinessCheckResults {
+		if result.TaskID == task.ID && !result.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+func (p RegistrationPolicy) gracePeriod() time.Duration {
+	var longest int
+	for _, check := range p.app.HealthChecks {
+		if check.GracePeriodSeconds > longest {
+			longest = check.GracePeriodSeconds
+		}
+	}
+	return time.Duration(longest) * time.Second
+}
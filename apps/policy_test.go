@@ -0,0 +1,100 @@
+package apps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var policyNow = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRegistrationPolicy_DefaultsToHealthy(t *testing.T) {
+	t.Parallel()
+
+	// expect
+	assert.Equal(t, HealthyPolicy, (&App{}).RegistrationPolicy().Name)
+	assert.Equal(t, HealthyPolicy, (&App{Labels: map[string]string{RegistrationPolicyLabel: "whenever"}}).RegistrationPolicy().Name)
+	assert.Equal(t, RunningPolicy, (&App{Labels: map[string]string{RegistrationPolicyLabel: " running "}}).RegistrationPolicy().Name)
+}
+
+func TestRegistrationPolicy_Healthy(t *testing.T) {
+	t.Parallel()
+
+	// given
+	policy := (&App{}).RegistrationPolicy()
+
+	// expect
+	assert.False(t, policy.canRegisterAt(&Task{State: "TASK_RUNNING"}, policyNow))
+	assert.False(t, policy.canRegisterAt(&Task{HealthCheckResults: []HealthCheckResult{{Alive: false}}}, policyNow))
+	assert.True(t, policy.canRegisterAt(&Task{HealthCheckResults: []HealthCheckResult{{Alive: true}}}, policyNow))
+	assert.True(t, policy.canRegisterAt(&Task{State: "TASK_RUNNING", HealthCheckResults: []HealthCheckResult{{Alive: true}}}, policyNow))
+	assert.False(t, policy.canRegisterAt(&Task{State: "TASK_KILLING", HealthCheckResults: []HealthCheckResult{{Alive: true}}}, policyNow))
+}
+
+func TestRegistrationPolicy_RunningWithoutHealthChecks(t *testing.T) {
+	t.Parallel()
+
+	// given
+	policy := (&App{Labels: map[string]string{RegistrationPolicyLabel: RunningPolicy}}).RegistrationPolicy()
+
+	// expect
+	assert.True(t, policy.canRegisterAt(&Task{State: "TASK_RUNNING"}, policyNow))
+	assert.True(t, policy.canRegisterAt(&Task{StartedAt: "2017-01-01T11:59:00.000Z"}, policyNow))
+	assert.False(t, policy.canRegisterAt(&Task{State: "TASK_STAGING"}, policyNow))
+	assert.False(t, policy.canRegisterAt(&Task{}, policyNow))
+}
+
+func TestRegistrationPolicy_RunningWaitsOutGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	// given
+	policy := (&App{
+		Labels:       map[string]string{RegistrationPolicyLabel: RunningPolicy},
+		HealthChecks: []HealthCheck{{GracePeriodSeconds: 30}, {GracePeriodSeconds: 300}},
+	}).RegistrationPolicy()
+
+	// expect
+	assert.False(t, policy.canRegisterAt(&Task{State: "TASK_RUNNING", StartedAt: "2017-01-01T11:58:00.000Z"}, policyNow))
+	assert.True(t, policy.canRegisterAt(&Task{State: "TASK_RUNNING", StartedAt: "2017-01-01T11:55:00.000Z"}, policyNow))
+	assert.True(t, policy.canRegisterAt(&Task{
+		State:              "TASK_RUNNING",
+		StartedAt:          "2017-01-01T11:59:00.000Z",
+		HealthCheckResults: []HealthCheckResult{{Alive: true}, {Alive: true}},
+	}, policyNow))
+	assert.False(t, policy.canRegisterAt(&Task{
+		State:              "TASK_RUNNING",
+		StartedAt:          "2017-01-01T11:00:00.000Z",
+		HealthCheckResults: []HealthCheckResult{{Alive: false}},
+	}, policyNow))
+}
+
+func TestRegistrationPolicy_Ready(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		Labels:          map[string]string{RegistrationPolicyLabel: ReadyPolicy},
+		HealthChecks:    []HealthCheck{{}},
+		ReadinessChecks: []ReadinessCheck{{Name: "readiness"}},
+		ReadinessCheckResults: []ReadinessCheckResult{
+			{Name: "readiness", TaskID: "not-ready", Ready: false},
+			{Name: "readiness", TaskID: "ready", Ready: true},
+		},
+	}
+	policy := app.RegistrationPolicy()
+	healthy := []HealthCheckResult{{Alive: true}}
+
+	// expect
+	assert.False(t, policy.canRegisterAt(&Task{ID: "not-ready", HealthCheckResults: healthy}, policyNow))
+	assert.True(t, policy.canRegisterAt(&Task{ID: "ready", HealthCheckResults: healthy}, policyNow))
+	assert.True(t, policy.canRegisterAt(&Task{ID: "after-deployment", HealthCheckResults: healthy}, policyNow))
+	assert.False(t, policy.canRegisterAt(&Task{ID: "ready", State: "TASK_RUNNING"}, policyNow))
+
+	// when
+	app.HealthChecks = nil
+
+	// then
+	assert.True(t, policy.canRegisterAt(&Task{ID: "ready", State: "TASK_RUNNING"}, policyNow))
+	assert.False(t, policy.canRegisterAt(&Task{ID: "not-ready", State: "TASK_RUNNING"}, policyNow))
+}
@@ -0,0 +1,117 @@
+package apps
+
+import (
+	"path"
+	"strings"
+)
+
+// Rules select apps registered in Consul regardless of the consul label. Apps matching an exclude rule are
+// never registered, apps matching an include rule are registered even without the label, as if it was left blank.
+//
+// Rules are app id globs matched segment by segment: * and ? match within a segment, ** matches any number of
+// segments. A rule matching a group matches all apps under it, e.g. /prod, /prod/* and /prod/** all match /prod/team/app.
+type Rules struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+type selection int
+
+const (
+	selectedByLabel selection = iota
+	includedByRule
+	excludedByRule
+)
+
+// ParseRules parses comma separated lists of include and exclude rules
+func ParseRules(include string, exclude string) Rules {
+	return Rules{Include: parseRuleList(include), Exclude: parseRuleList(exclude)}
+}
+
+func parseRuleList(rules string) []string {
+	var parsed []string
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.Trim(strings.TrimSpace(rule), "/"); rule != "" {
+			parsed = append(parsed, "/"+rule)
+		}
+	}
+	return parsed
+}
+
+// Empty tells whether there are no rules, so only the consul label selects apps
+func (r Rules) Empty() bool {
+	return len(r.Include) == 0 && len(r.Exclude) == 0
+}
+
+// IncludedGroups returns groups containing all apps include rules can match, i.e. paths of the rules
+// up to their first segment with a wildcard. Groups nested in other ones are left out.
+func (r Rules) IncludedGroups() []AppID {
+	var candidates []AppID
+	for _, rule := range r.Include {
+		var literal []string
+		for _, part := range AppID(rule).Parts() {
+			if strings.ContainsAny(part, `*?[\`) {
+				break
+			}
+			literal = append(literal, part)
+		}
+		candidates = append(candidates, AppID("/"+strings.Join(literal, "/")))
+	}
+	var groups []AppID
+	for i, candidate := range candidates {
+		nested := false
+		for j, other := range candidates {
+			if i != j && candidate.InGroup(other) && (candidate != other || j < i) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			groups = append(groups, candidate)
+		}
+	}
+	return groups
+}
+
+// Apply makes IsConsulApp of the app follow the rules
+func (r Rules) Apply(app *App) {
+	switch {
+	case matchesAny(app.ID, r.Exclude):
+		app.selection = excludedByRule
+	case matchesAny(app.ID, r.Include):
+		app.selection = includedByRule
+	default:
+		app.selection = selectedByLabel
+	}
+}
+
+func matchesAny(id AppID, rules []string) bool {
+	for _, rule := range rules {
+		if matchRule(AppID(rule).Parts(), id.Parts()) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRule(rule []string, parts []string) bool {
+	if len(rule) == 0 {
+		// rules match whole groups, so everything under the matched path matches too
+		return true
+	}
+	if rule[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchRule(rule[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, err := path.Match(rule[0], parts[0]); err != nil || !matched {
+		return false
+	}
+	return matchRule(rule[1:], parts[1:])
+}
@@ -0,0 +1,99 @@
+package apps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRules(t *testing.T) {
+	t.Parallel()
+
+	// when
+	rules := ParseRules(" /prod/** , team/services/,", "")
+
+	// then
+	assert.Equal(t, []string{"/prod/**", "/team/services"}, rules.Include)
+	assert.Nil(t, rules.Exclude)
+	assert.False(t, rules.Empty())
+	assert.True(t, ParseRules("", " , ").Empty())
+}
+
+func TestRules_Match(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		rule    string
+		id      AppID
+		matches bool
+	}{
+		{"/prod", "/prod/app", true},
+		{"/prod", "/prod/team/app", true},
+		{"/prod", "/production/app", false},
+		{"/prod/**", "/prod/team/app", true},
+		{"/prod/*", "/prod/app", true},
+		{"/prod/*", "/dev/app", false},
+		{"/**/tmp", "/team/tmp/app", true},
+		{"/**/tmp", "/tmp/app", true},
+		{"/**/tmp", "/team/temp/app", false},
+		{"/*-canary", "/app-canary", true},
+		{"/*-canary", "/app", false},
+		{"/team/app?", "/team/app1", true},
+		{"/**", "/any/app", true},
+	} {
+		// expect
+		assert.Equal(t, tc.matches, matchesAny(tc.id, []string{tc.rule}), "%s matching %s", tc.rule, tc.id)
+	}
+}
+
+func TestRules_IncludedGroups(t *testing.T) {
+	t.Parallel()
+
+	// expect
+	assert.Equal(t, []AppID{"/prod", "/team/services"}, ParseRules("/prod/**,/team/services,/prod/team/*-canary", "").IncludedGroups())
+	assert.Equal(t, []AppID{"/team"}, ParseRules("/team/app?,/team/app?", "/team").IncludedGroups())
+	assert.Equal(t, []AppID{"/"}, ParseRules("/**/canary,/prod", "").IncludedGroups())
+	assert.Nil(t, ParseRules("", "/tmp/**").IncludedGroups())
+}
+
+func TestRules_Apply(t *testing.T) {
+	t.Parallel()
+
+	// given
+	rules := ParseRules("/prod", "/prod/tmp,/scratch")
+	labeled := map[string]string{MarathonConsulLabel: ""}
+	included := &App{ID: "/prod/app"}
+	excluded := &App{ID: "/prod/tmp/app", Labels: labeled}
+	excludedOutsideIncluded := &App{ID: "/scratch/app", Labels: labeled}
+	selectedByLabel := &App{ID: "/dev/app", Labels: labeled}
+	notSelected := &App{ID: "/dev/other"}
+
+	// when
+	for _, app := range []*App{included, excluded, excludedOutsideIncluded, selectedByLabel, notSelected} {
+		rules.Apply(app)
+	}
+
+	// then
+	assert.True(t, included.IsConsulApp())
+	assert.False(t, excluded.IsConsulApp())
+	assert.False(t, excludedOutsideIncluded.IsConsulApp())
+	assert.True(t, selectedByLabel.IsConsulApp())
+	assert.False(t, notSelected.IsConsulApp())
+	assert.Equal(t, 0, excluded.RegistrationIntentsNumber())
+}
+
+func TestRules_IncludedAppNamedAfterId(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{ID: "/prod/app"}
+	ParseRules("/prod", "").Apply(app)
+	task := &Task{ID: "prod_app.1", Ports: []int{8080}}
+
+	// when
+	intents := app.RegistrationIntents(task, ".", "")
+
+	// then
+	assert.Len(t, intents, 1)
+	assert.Equal(t, "prod.app", intents[0].Name)
+}
@@ -0,0 +1,78 @@
+package apps
+
+import (
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Value of this label is a comma-separated list of tags, e.g. consul-tags=public,v2
+const TagsLabel = "consul-tags"
+
+// Labels with this value are converted to tags named after the label key
+const tagLabelValue = "tag"
+
+// labelsToTags converts labels with value "tag", the consul-tags label and labels prefixed with
+// tagLabelPrefix (producing key=value tags, or just key when the value is empty) to tags.
+// Resulting tags are de-duplicated and sorted, so the order is stable between registrations.
+func labelsToTags(tagLabelPrefix string, data TemplateData, labels ...map[string]string) []string {
+	unique := make(map[string]struct{})
+	for _, l := range labels {
+		for key, value := range l {
+			for _, tag := range labelToTags(tagLabelPrefix, key, value, data) {
+				unique[tag] = struct{}{}
+			}
+		}
+	}
+	tags := make([]string, 0, len(unique))
+	for tag := range unique {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func labelToTags(tagLabelPrefix string, key string, value string, data TemplateData) []string {
+	var candidates []string
+	switch {
+	case key == TagsLabel:
+		candidates = strings.Split(value, ",")
+	case tagLabelPrefix != "" && strings.HasPrefix(key, tagLabelPrefix):
+		name := strings.TrimPrefix(key, tagLabelPrefix)
+		if name == "" {
+			log.WithField("Id", data.AppID.String()).WithField("Label", key).Warn("Tag label without tag name, skipping")
+			return nil
+		}
+		if value == "" {
+			candidates = []string{name}
+		} else {
+			candidates = []string{name + "=" + value}
+		}
+	case value == tagLabelValue:
+		candidates = []string{key}
+	}
+
+	var tags []string
+	for _, candidate := range candidates {
+		if tag, ok := renderTag(strings.TrimSpace(candidate), data); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func renderTag(tag string, data TemplateData) (string, bool) {
+	if !isTemplate(tag) {
+		return tag, tag != ""
+	}
+	rendered, err := render(tag, data)
+	if err != nil {
+		return "", false
+	}
+	if sanitized := sanitizeTag(rendered); sanitized != "" {
+		return sanitized, true
+	}
+	log.WithField("Id", data.AppID.String()).WithField("Template", tag).Warn("Tag template rendered empty tag, skipping")
+	return "", false
+}
@@ -0,0 +1,94 @@
+package apps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationIntent_KeyValueTagsFromPrefixedLabels(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "app-name",
+		Labels: map[string]string{
+			"consul":             "",
+			"consul-tag-version": "1.2.3",
+			"consul-tag-team":    "payments",
+			"consul-tag-canary":  "",
+			"consul-tag-env":     "tag",
+			"consul-tag-":        "ignored",
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, []string{"canary", "env=tag", "team=payments", "version=1.2.3"}, intent.Tags)
+}
+
+func TestRegistrationIntent_PrefixedLabelsAreNotTagsWhenPrefixIsEmpty(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": "", "consul-tag-version": "1.2.3", "public": "tag"},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "")[0]
+
+	// then
+	assert.Equal(t, []string{"public"}, intent.Tags)
+}
+
+func TestRegistrationIntent_CommaSeparatedTags(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "app-name",
+		Labels: map[string]string{"consul": "", "consul-tags": "public, v2,,team-{{.AppID.Last}} "},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, "-", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, []string{"public", "team-app-name", "v2"}, intent.Tags)
+}
+
+func TestRegistrationIntent_TagsAreDeduplicatedAndSorted(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "app-name",
+		Labels: map[string]string{
+			"consul":             "",
+			"public":             "tag",
+			"zone":               "tag",
+			"consul-tags":        "public,alpha",
+			"consul-tag-version": "1",
+		},
+		PortDefinitions: []PortDefinition{
+			{Labels: map[string]string{"consul": "", "consul-tags": "zone,beta", "consul-tag-version": "1", "alpha": "tag"}},
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intents := app.RegistrationIntents(task, "-", "consul-tag-")
+
+	// then
+	assert.Equal(t, []string{"alpha", "beta", "public", "version=1", "zone"}, intents[0].Tags)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, intents[0].Tags, app.RegistrationIntents(task, "-", "consul-tag-")[0].Tags)
+	}
+}
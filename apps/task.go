@@ -3,15 +3,20 @@ package apps
 import (
 	"encoding/json"
 	"strings"
+	"time"
 )
 
 type Task struct {
 	ID                 TaskID              `json:"id"`
 	TaskStatus         string              `json:"taskStatus"`
+	State              string              `json:"state"`
+	StartedAt          string              `json:"startedAt"`
 	AppID              AppID               `json:"appId"`
 	Host               string              `json:"host"`
 	Ports              []int               `json:"ports"`
 	HealthCheckResults []HealthCheckResult `json:"healthCheckResults"`
+	// Version of the application the task was started with
+	Version string `json:"version"`
 }
 
 // Marathon Task ID
@@ -28,7 +33,9 @@ func (id TaskID) AppID() AppID {
 }
 
 type HealthCheckResult struct {
-	Alive bool `json:"alive"`
+	Alive       bool   `json:"alive"`
+	LastSuccess string `json:"lastSuccess"`
+	LastFailure string `json:"lastFailure"`
 }
 
 type TasksResponse struct {
@@ -48,6 +55,50 @@ func ParseTask(event []byte) (*Task, error) {
 	return task, err
 }
 
+// IsUnhealthy tells whether any health check reports the task is not alive. Tasks without
+// health check results (e.g. just started or without health checks) are not considered unhealthy.
+func (t Task) IsUnhealthy() bool {
+	for _, healthCheckResult := range t.HealthCheckResults {
+		if !healthCheckResult.Alive {
+			return true
+		}
+	}
+	return false
+}
+
+// UnhealthySince returns the earliest last success of failing health checks, i.e. the time the task
+// is known to be unhealthy since. Last failure is used for checks that never succeeded.
+// Zero time is returned when it can't be determined.
+func (t Task) UnhealthySince() time.Time {
+	var since time.Time
+	for _, healthCheckResult := range t.HealthCheckResults {
+		if healthCheckResult.Alive {
+			continue
+		}
+		timestamp := healthCheckResult.LastSuccess
+		if timestamp == "" {
+			timestamp = healthCheckResult.LastFailure
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return time.Time{}
+		}
+		if since.IsZero() || parsed.Before(since) {
+			since = parsed
+		}
+	}
+	return since
+}
+
+// IsRunning tells whether the task is running. The state is reported by Marathon since 1.2,
+// for older versions a task that has started is considered running.
+func (t Task) IsRunning() bool {
+	if t.State != "" {
+		return t.State == taskRunning
+	}
+	return t.TaskStatus == taskRunning || t.StartedAt != ""
+}
+
 func (t Task) IsHealthy() bool {
 	if len(t.HealthCheckResults) < 1 {
 		return false
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -43,13 +44,17 @@ func TestParseTasks(t *testing.T) {
 			AppID:              "/test",
 			Host:               "192.168.2.114",
 			Ports:              []int{31315},
-			HealthCheckResults: []HealthCheckResult{{Alive: true}},
+			HealthCheckResults: []HealthCheckResult{{Alive: true, LastSuccess: "2015-11-30T10:08:19.477Z"}},
+			Version:            "2015-06-24T14:56:57.466Z",
+			StartedAt:          "2015-06-24T14:57:06.466Z",
 		},
 		{
-			ID:    "test.4453212c-1a81-11e5-bdb6-e6cb6734eaf8",
-			AppID: "/test",
-			Host:  "192.168.2.114",
-			Ports: []int{31797},
+			ID:        "test.4453212c-1a81-11e5-bdb6-e6cb6734eaf8",
+			AppID:     "/test",
+			Host:      "192.168.2.114",
+			Ports:     []int{31797},
+			Version:   "2015-06-24T14:56:57.466Z",
+			StartedAt: "2015-06-24T14:57:00.611Z",
 		},
 	}
 
@@ -104,6 +109,38 @@ func TestIsHealthy(t *testing.T) {
 	assert.True(t, task.IsHealthy())
 }
 
+func TestTask_IsUnhealthy(t *testing.T) {
+	t.Parallel()
+
+	// expect
+	assert.False(t, (&Task{}).IsUnhealthy())
+	assert.False(t, (&Task{HealthCheckResults: []HealthCheckResult{{Alive: true}}}).IsUnhealthy())
+	assert.True(t, (&Task{HealthCheckResults: []HealthCheckResult{{Alive: true}, {Alive: false}}}).IsUnhealthy())
+}
+
+func TestTask_UnhealthySince(t *testing.T) {
+	t.Parallel()
+
+	// given
+	task := &Task{HealthCheckResults: []HealthCheckResult{
+		{Alive: true, LastSuccess: "2015-12-01T10:00:00.000Z"},
+		{Alive: false, LastSuccess: "2015-12-01T10:03:42.324Z", LastFailure: "2015-12-01T10:05:00.000Z"},
+		{Alive: false, LastFailure: "2015-12-01T10:04:00.000Z"},
+	}}
+
+	// when
+	since := task.UnhealthySince()
+
+	// then
+	assert.Equal(t, time.Date(2015, 12, 1, 10, 3, 42, 324000000, time.UTC), since)
+
+	// when
+	task.HealthCheckResults = append(task.HealthCheckResults, HealthCheckResult{Alive: false})
+
+	// then
+	assert.True(t, task.UnhealthySince().IsZero())
+}
+
 func TestId_String(t *testing.T) {
 	t.Parallel()
 	assert.Equal(t, "id", TaskID("id").String())
@@ -0,0 +1,117 @@
+package apps
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/metrics"
+)
+
+// Service names and tag labels containing this delimiter are treated as Go templates,
+// e.g. "consul": "{{.AppID.Last}}-{{.Env.ENVIRONMENT}}" or "{{.Labels.team}}": "tag"
+const templateDelimiter = "{{"
+
+// TemplateData is available in service name and tag templates
+type TemplateData struct {
+	AppID  AppID
+	TaskID TaskID
+	Host   string
+	// Labels of the application merged with labels of the port definition
+	Labels map[string]string
+	// Env holds string environment variables of the application, secret references are skipped
+	Env       map[string]string
+	PortIndex int
+	PortName  string
+	Port      int
+}
+
+func (app App) templateData(task *Task, portIndex int, portLabels map[string]string) TemplateData {
+	labels := make(map[string]string, len(app.Labels)+len(portLabels))
+	for key, value := range app.Labels {
+		labels[key] = value
+	}
+	for key, value := range portLabels {
+		labels[key] = value
+	}
+	env := make(map[string]string, len(app.Env))
+	for key, value := range app.Env {
+		if s, ok := value.(string); ok {
+			env[key] = s
+		}
+	}
+	data := TemplateData{
+		AppID:     app.ID,
+		TaskID:    task.ID,
+		Host:      task.Host,
+		Labels:    labels,
+		Env:       env,
+		PortIndex: portIndex,
+	}
+	if portIndex < len(app.PortDefinitions) {
+		data.PortName = app.PortDefinitions[portIndex].Name
+	}
+	if portIndex < len(task.Ports) {
+		data.Port = task.Ports[portIndex]
+	}
+	return data
+}
+
+func isTemplate(text string) bool {
+	return strings.Contains(text, templateDelimiter)
+}
+
+// render executes text as a template. Errors, including references to missing labels
+// or environment variables, are logged and counted in apps.template.error metric.
+func render(text string, data TemplateData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err == nil {
+		var buffer bytes.Buffer
+		if err = tmpl.Execute(&buffer, data); err == nil {
+			return buffer.String(), nil
+		}
+	}
+	metrics.Mark("apps.template.error")
+	log.WithError(err).WithField("Id", data.AppID.String()).WithField("Template", text).
+		Warn("Invalid template")
+	return "", fmt.Errorf("invalid template %q: %s", text, err)
+}
+
+// sanitizeServiceName replaces characters not allowed in DNS labels with dashes. Characters of
+// the name separator are preserved, so names can still be split into segments.
+func sanitizeServiceName(name string, nameSeparator string) string {
+	if nameSeparator == "" {
+		return sanitizeDNSLabel(name, "")
+	}
+	var segments []string
+	for _, segment := range strings.Split(name, nameSeparator) {
+		if sanitized := sanitizeDNSLabel(segment, nameSeparator); sanitized != "" {
+			segments = append(segments, sanitized)
+		}
+	}
+	return strings.Join(segments, nameSeparator)
+}
+
+func sanitizeDNSLabel(label string, allowed string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		case strings.ContainsRune(allowed, r):
+			return r
+		default:
+			return '-'
+		}
+	}, label)
+	for strings.Contains(sanitized, "--") {
+		sanitized = strings.Replace(sanitized, "--", "-", -1)
+	}
+	return strings.Trim(sanitized, "-")
+}
+
+// sanitizeTag replaces whitespace in tags with dashes
+func sanitizeTag(tag string) string {
+	return strings.Join(strings.Fields(tag), "-")
+}
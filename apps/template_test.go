@@ -0,0 +1,111 @@
+package apps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppId_Parts(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"group", "sub", "app"}, AppID("/group/sub/app").Parts())
+	assert.Equal(t, "app", AppID("/group/sub/app").Last())
+	assert.Equal(t, "app", AppID("app").Last())
+	assert.Equal(t, []string{}, AppID("/").Parts())
+	assert.Equal(t, "", AppID("").Last())
+}
+
+func TestRegistrationIntent_NameFromTemplate(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "/rootGroup/subGroup/app-name",
+		Labels: map[string]string{"consul": "{{.AppID.Last}}-{{.Env.ENVIRONMENT}}"},
+		Env: map[string]interface{}{
+			"ENVIRONMENT": "prod",
+			"SECRET":      map[string]interface{}{"secret": "password"},
+		},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, ".", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, "app-name-prod", intent.Name)
+}
+
+func TestRegistrationIntent_NameFromTemplateIsSanitized(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "/rootGroup/app-name",
+		Labels: map[string]string{"consul": "{{.Labels.team}}/{{.AppID.Last}}", "team": "Data Science_"},
+	}
+	task := &Task{Ports: []int{1234}}
+
+	// when
+	intent := app.RegistrationIntents(task, ".", "consul-tag-")[0]
+
+	// then
+	assert.Equal(t, "Data-Science.app-name", intent.Name)
+}
+
+func TestRegistrationIntent_InvalidNameTemplateFallsBackToDefaultName(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID:     "/rootGroup/app-name",
+		Labels: map[string]string{"consul": "{{.Labels.missing}}"},
+		PortDefinitions: []PortDefinition{
+			{Labels: map[string]string{"consul": "{{.Unknown}}"}},
+			{Labels: map[string]string{"consul": "{{.AppID"}},
+		},
+	}
+	task := &Task{Ports: []int{1234, 5678}}
+
+	// when
+	intents := app.RegistrationIntents(task, ".", "consul-tag-")
+
+	// then
+	assert.Equal(t, "rootGroup.app-name", intents[0].Name)
+	assert.Equal(t, "rootGroup.app-name", intents[1].Name)
+}
+
+func TestRegistrationIntent_NameAndTagsFromPortDefinitionTemplate(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := &App{
+		ID: "/app-name",
+		Labels: map[string]string{
+			"consul":                "",
+			"team":                  "core",
+			"team-{{.Labels.team}}": "tag",
+			"{{.Labels.missing}}":   "tag",
+		},
+		PortDefinitions: []PortDefinition{
+			{Name: "http", Labels: map[string]string{"consul": "{{.AppID.Last}}-{{.PortName}}"}},
+			{Name: "admin", Labels: map[string]string{
+				"consul":                        "{{.AppID.Last}}-{{.PortName}}",
+				"team":                          "ops",
+				"port-{{.PortIndex}}-{{.Port}}": "tag",
+			}},
+		},
+	}
+	task := &Task{ID: "task-id", Host: "host", Ports: []int{1234, 5678}}
+
+	// when
+	intents := app.RegistrationIntents(task, ".", "consul-tag-")
+
+	// then
+	assert.Len(t, intents, 2)
+	assert.Equal(t, "app-name-http", intents[0].Name)
+	assert.Equal(t, []string{"team-core"}, intents[0].Tags)
+	assert.Equal(t, "app-name-admin", intents[1].Name)
+	assert.Equal(t, []string{"port-1-5678", "team-ops"}, intents[1].Tags)
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/logging"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/service"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionRegister           = "register"
+	ActionDeregister         = "deregister"
+	ActionEnableMaintenance  = "maintenance-enable"
+	ActionDisableMaintenance = "maintenance-disable"
+)
+
+// Results of recorded actions
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Entry records a single change of registered services
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	Cluster   string            `json:"cluster,omitempty"`
+	Action    string            `json:"action"`
+	Trigger   service.Trigger   `json:"trigger"`
+	AppID     apps.AppID        `json:"appId,omitempty"`
+	TaskID    apps.TaskID       `json:"taskId,omitempty"`
+	ServiceID service.ServiceId `json:"serviceId,omitempty"`
+	Agent     string            `json:"agent,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Result    string            `json:"result"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Query selects recent entries, empty fields match all entries
+type Query struct {
+	AppID  apps.AppID
+	TaskID apps.TaskID
+	Action string
+	// Limit of entries returned, 0 returns all matching ones
+	Limit int
+}
+
+func (q Query) matches(entry Entry) bool {
+	return (q.AppID == "" || q.AppID == entry.AppID) &&
+		(q.TaskID == "" || q.TaskID == entry.TaskID) &&
+		(q.Action == "" || q.Action == entry.Action)
+}
+
+// Log writes entries as JSON lines, separately from the application log, and keeps recent ones for queries
+type Log struct {
+	sync.Mutex
+	writer io.WriteCloser
+	recent []Entry
+	next   int
+	full   bool
+}
+
+func New(config Config) (*Log, error) {
+	l := &Log{recent: make([]Entry, config.Recent)}
+	if config.File != "" {
+		file, err := logging.OpenRotatingFile(config.File, logging.Rotation{MaxSize: config.MaxSize, MaxBackups: config.MaxBackups})
+		if err != nil {
+			return nil, err
+		}
+		l.writer = file
+	}
+	log.WithField("File", config.File).WithField("Recent", config.Recent).Info("Audit log enabled")
+	return l, nil
+}
+
+// Record appends the entry to the log, failures of writing it are logged and counted but not returned
+// as the change it records has already been made
+func (l *Log) Record(entry Entry) {
+	l.Lock()
+	defer l.Unlock()
+
+	metrics.Mark("audit." + entry.Action + "." + entry.Result)
+	if len(l.recent) > 0 {
+		l.recent[l.next] = entry
+		l.next = (l.next + 1) % len(l.recent)
+		l.full = l.full || l.next == 0
+	}
+	if l.writer == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = l.writer.Write(append(line, '\n'))
+	}
+	if err != nil {
+		metrics.Mark("audit.write.error")
+		log.WithError(err).WithField("Action", entry.Action).WithField("Id", entry.TaskID).Error("Unable to write audit log entry")
+	}
+}
+
+// Recent returns recent entries matching the query, the newest first
+func (l *Log) Recent(query Query) []Entry {
+	l.Lock()
+	defer l.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.recent)
+	}
+	entries := []Entry{}
+	for i := 1; i <= count; i++ {
+		entry := l.recent[(l.next-i+len(l.recent))%len(l.recent)]
+		if !query.matches(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if query.Limit > 0 && len(entries) == query.Limit {
+			break
+		}
+	}
+	return entries
+}
+
+func (l *Log) Close() error {
+	if l.writer == nil {
+		return nil
+	}
+	return l.writer.Close()
+}
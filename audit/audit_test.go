@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord_WritesJSONLines(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	auditLog, err := New(Config{File: file, Recent: 10})
+	require.NoError(t, err)
+
+	// when
+	auditLog.Record(Entry{Action: ActionRegister, TaskID: "app.1", Result: ResultSuccess,
+		Trigger: service.Trigger{Type: service.TriggerSync, SyncRun: "run"}})
+	auditLog.Record(Entry{Action: ActionDeregister, TaskID: "app.2", Result: ResultError, Error: "failed"})
+	auditLog.Close()
+
+	// then
+	content, err := os.Open(file)
+	require.NoError(t, err)
+	defer content.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(content)
+	for scanner.Scan() {
+		entry := Entry{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, "run", entries[0].Trigger.SyncRun)
+	assert.Equal(t, "failed", entries[1].Error)
+}
+
+func TestRecord_RotatesFile(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	auditLog, err := New(Config{File: file, MaxSize: 1, MaxBackups: 1})
+	require.NoError(t, err)
+
+	// when
+	for i := 0; i < 20000; i++ {
+		auditLog.Record(Entry{Action: ActionRegister, TaskID: "app.1", Result: ResultSuccess})
+	}
+	auditLog.Close()
+
+	// then
+	current, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.True(t, current.Size() <= 1024*1024)
+	backup, err := os.Stat(file + ".1")
+	require.NoError(t, err)
+	assert.True(t, backup.Size() <= 1024*1024)
+	_, err = os.Stat(file + ".2")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRecent_ReturnsNewestMatchingEntries(t *testing.T) {
+	t.Parallel()
+	// given
+	auditLog, _ := New(Config{Recent: 3})
+	for _, taskID := range []apps.TaskID{"a.1", "b.1", "a.2", "a.3"} {
+		auditLog.Record(Entry{Action: ActionRegister, TaskID: taskID, AppID: taskID.AppID(), Result: ResultSuccess})
+	}
+
+	// when
+	all := auditLog.Recent(Query{})
+	ofApp := auditLog.Recent(Query{AppID: "/a", Limit: 1})
+
+	// then
+	require.Len(t, all, 3)
+	assert.Equal(t, apps.TaskID("a.3"), all[0].TaskID)
+	assert.Equal(t, apps.TaskID("b.1"), all[2].TaskID)
+	require.Len(t, ofApp, 1)
+	assert.Equal(t, apps.TaskID("a.3"), ofApp[0].TaskID)
+}
+
+func TestRecent_WithoutRecentEntries(t *testing.T) {
+	t.Parallel()
+	// given
+	auditLog, _ := New(Config{})
+	auditLog.Record(Entry{Action: ActionRegister, Result: ResultSuccess})
+
+	// expect
+	assert.Empty(t, auditLog.Recent(Query{}))
+}
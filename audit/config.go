@@ -0,0 +1,12 @@
+package audit
+
+type Config struct {
+	Enabled bool
+	// File entries are appended to as JSON lines, empty keeps recent entries in memory only
+	File string
+	// MaxSize in megabytes the file is rotated at
+	MaxSize    int64
+	MaxBackups int
+	// Recent is the number of entries kept in memory for queries
+	Recent int
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"strings"
+	"time"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/service"
+)
+
+// Registry records changes made through the wrapped registry in the audit log.
+// Reads are passed through unrecorded.
+type Registry struct {
+	service.ServiceRegistry
+	log     *Log
+	cluster string
+	trigger service.Trigger
+}
+
+func NewRegistry(registry service.ServiceRegistry, log *Log, cluster string) *Registry {
+	return &Registry{ServiceRegistry: registry, log: log, cluster: cluster}
+}
+
+// WithTrigger returns the registry attributing changes to the trigger, it shares the audit log with r
+func (r *Registry) WithTrigger(trigger service.Trigger) service.ServiceRegistry {
+	triggered := *r
+	triggered.trigger = trigger
+	return &triggered
+}
+
+// Register records an entry for every service the task is expected to be registered as
+func (r *Registry) Register(task *apps.Task, app *apps.App) error {
+	err := r.ServiceRegistry.Register(task, app)
+	entry := r.entry(ActionRegister, task.ID, err)
+	entry.AppID = app.ID
+	expected, expectedErr := r.ServiceRegistry.ExpectedServices(task, app)
+	if expectedErr != nil || len(expected) == 0 {
+		entry.Agent = task.Host
+		r.log.Record(entry)
+		return err
+	}
+	for _, s := range expected {
+		entry.ServiceID = s.ID
+		entry.Agent = s.RegisteringAgentAddress
+		r.log.Record(entry)
+	}
+	return err
+}
+
+func (r *Registry) Deregister(toDeregister *service.Service) error {
+	err := r.ServiceRegistry.Deregister(toDeregister)
+	r.log.Record(r.serviceEntry(ActionDeregister, toDeregister, err))
+	return err
+}
+
+func (r *Registry) DeregisterByTask(taskID apps.TaskID) error {
+	err := r.ServiceRegistry.DeregisterByTask(taskID)
+	r.log.Record(r.entry(ActionDeregister, taskID, err))
+	return err
+}
+
+func (r *Registry) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	err := r.ServiceRegistry.EnableMaintenance(toMaintain, reason)
+	entry := r.serviceEntry(ActionEnableMaintenance, toMaintain, err)
+	entry.Reason = reason
+	r.log.Record(entry)
+	return err
+}
+
+func (r *Registry) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	err := r.ServiceRegistry.EnableMaintenanceByTask(taskID, reason)
+	entry := r.entry(ActionEnableMaintenance, taskID, err)
+	entry.Reason = reason
+	r.log.Record(entry)
+	return err
+}
+
+func (r *Registry) DisableMaintenance(toMaintain *service.Service) error {
+	err := r.ServiceRegistry.DisableMaintenance(toMaintain)
+	r.log.Record(r.serviceEntry(ActionDisableMaintenance, toMaintain, err))
+	return err
+}
+
+func (r *Registry) entry(action string, taskID apps.TaskID, err error) Entry {
+	entry := Entry{
+		Time:    time.Now(),
+		Cluster: r.cluster,
+		Action:  action,
+		Trigger: r.trigger,
+		TaskID:  taskID,
+		Result:  ResultSuccess,
+	}
+	// task id without a dot can't be mapped to an app id
+	if strings.Contains(taskID.String(), ".") {
+		entry.AppID = taskID.AppID()
+	}
+	if err != nil {
+		entry.Result = ResultError
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+func (r *Registry) serviceEntry(action string, s *service.Service, err error) Entry {
+	taskID, _ := s.TaskId()
+	entry := r.entry(action, taskID, err)
+	entry.ServiceID = s.ID
+	entry.Agent = s.RegisteringAgentAddress
+	return entry
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/allegro/marathon-consul/consul"
+	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_RecordsChangesWithTrigger(t *testing.T) {
+	t.Parallel()
+	// given
+	auditLog, _ := New(Config{Recent: 10})
+	registry := NewRegistry(consul.NewConsulStub(), auditLog, "team-a")
+	triggered := service.WithTrigger(registry, service.Trigger{Type: service.TriggerEvent, Event: "status_update_event"})
+	app := utils.ConsulApp("serviceA", 1)
+
+	// when
+	require.NoError(t, triggered.Register(&app.Tasks[0], app))
+	services, _ := triggered.GetAllServices()
+	require.NoError(t, triggered.EnableMaintenance(services[0], "deploy"))
+	require.NoError(t, registry.Deregister(services[0]))
+
+	// then
+	entries := auditLog.Recent(Query{})
+	require.Len(t, entries, 3)
+	deregister, maintenance, register := entries[0], entries[1], entries[2]
+
+	assert.Equal(t, ActionRegister, register.Action)
+	assert.Equal(t, "team-a", register.Cluster)
+	assert.Equal(t, service.Trigger{Type: service.TriggerEvent, Event: "status_update_event"}, register.Trigger)
+	assert.Equal(t, app.ID, register.AppID)
+	assert.Equal(t, app.Tasks[0].ID, register.TaskID)
+	assert.Equal(t, services[0].ID, register.ServiceID)
+	assert.Equal(t, ResultSuccess, register.Result)
+
+	assert.Equal(t, ActionEnableMaintenance, maintenance.Action)
+	assert.Equal(t, "deploy", maintenance.Reason)
+	assert.Equal(t, app.Tasks[0].ID, maintenance.TaskID)
+
+	assert.Equal(t, ActionDeregister, deregister.Action)
+	assert.Equal(t, service.Trigger{}, deregister.Trigger)
+}
+
+func TestRegistry_RecordsFailures(t *testing.T) {
+	t.Parallel()
+	// given
+	auditLog, _ := New(Config{Recent: 10})
+	stub := consul.NewConsulStub()
+	registry := NewRegistry(stub, auditLog, "")
+	app := utils.ConsulApp("serviceA", 1)
+	stub.FailRegisterForID(app.Tasks[0].ID)
+
+	// when
+	err := registry.Register(&app.Tasks[0], app)
+
+	// then
+	assert.Error(t, err)
+	entries := auditLog.Recent(Query{Action: ActionRegister})
+	require.Len(t, entries, 1)
+	assert.Equal(t, ResultError, entries[0].Result)
+	assert.Equal(t, err.Error(), entries[0].Error)
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/allegro/marathon-consul/marathon"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/sync"
+	"github.com/allegro/marathon-consul/web"
+)
+
+// Cluster is a Marathon cluster synced by the process. Its Marathon and Sync options are the top level ones
+// overridden by options given for the cluster, its services are registered with its own tag.
+type Cluster struct {
+	Name     string
+	Tag      string
+	Marathon marathon.Config
+	Sync     sync.Config
+}
+
+var clusterName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// MarathonClusters returns configured Marathon clusters,
+// or the one configured with top level options when no clusters are listed
+func (config *Config) MarathonClusters() []Cluster {
+	if len(config.Clusters) == 0 {
+		return []Cluster{{Tag: config.Consul.Tag, Marathon: config.Marathon, Sync: config.Sync}}
+	}
+	clusters := make([]Cluster, 0, len(config.Clusters))
+	for _, cluster := range config.Clusters {
+		scope := metrics.Cluster(cluster.Name)
+		cluster.Marathon.MetricsScope = scope
+		cluster.Sync.MetricsScope = scope
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// EventsPath returns the path Marathon events of the cluster are accepted at
+func (c Cluster) EventsPath() string {
+	if c.Name == "" {
+		return "/events"
+	}
+	return "/events/" + c.Name
+}
+
+// Web returns web options of the cluster, events of every cluster are spilled to its own directory
+func (c Cluster) Web(config web.Config) web.Config {
+	if c.Name == "" {
+		return config
+	}
+	config.MetricsScope = metrics.Cluster(c.Name)
+	if config.QueueSpillDir != "" {
+		config.QueueSpillDir = filepath.Join(config.QueueSpillDir, c.Name)
+	}
+	return config
+}
+
+// loadClusters reads clusters listed in the configuration file. Options not given for a cluster
+// are taken from the top level ones, so they must be loaded first.
+func (config *Config) loadClusters(jsonBlob []byte) error {
+	config.Clusters = nil
+	if jsonBlob == nil {
+		return nil
+	}
+	file := struct {
+		Clusters []json.RawMessage
+	}{}
+	if err := json.Unmarshal(jsonBlob, &file); err != nil {
+		return err
+	}
+	for _, raw := range file.Clusters {
+		cluster := Cluster{Marathon: config.Marathon, Sync: config.Sync}
+		if err := json.Unmarshal(raw, &cluster); err != nil {
+			return err
+		}
+		if cluster.Tag == "" {
+			cluster.Tag = cluster.Name
+		}
+		config.Clusters = append(config.Clusters, cluster)
+	}
+	return config.validateClusters()
+}
+
+func (config *Config) validateClusters() error {
+	names := make(map[string]bool)
+	tags := make(map[string]bool)
+	for _, cluster := range config.Clusters {
+		if !clusterName.MatchString(cluster.Name) {
+			return fmt.Errorf("Invalid Marathon cluster name %q, expected letters, digits, _ or -", cluster.Name)
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("Marathon cluster %s is configured more than once", cluster.Name)
+		}
+		if tags[cluster.Tag] {
+			return fmt.Errorf("Consul tag %s is used by more than one Marathon cluster", cluster.Tag)
+		}
+		names[cluster.Name] = true
+		tags[cluster.Tag] = true
+	}
+	return nil
+}
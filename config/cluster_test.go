@@ -0,0 +1,105 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/marathon"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/sync"
+	timeutil "github.com/allegro/marathon-consul/time"
+	"github.com/allegro/marathon-consul/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ClustersInheritTopLevelOptions(t *testing.T) {
+	t.Parallel()
+
+	// given
+	config := &Config{
+		Marathon: marathon.Config{Location: "localhost:8080", Protocol: "https", Timeout: timeutil.Interval{Duration: time.Second}},
+		Sync:     sync.Config{Enabled: true, Leader: "localhost:8080"},
+	}
+	config.Consul.Tag = "marathon"
+
+	// when
+	err := config.loadClusters([]byte(`{"Clusters": [
+		{"Name": "team-a", "Marathon": {"Location": "team-a:8080", "Token": "secret"}, "Sync": {"Leader": "team-a:8080"}},
+		{"Name": "team-b", "Tag": "marathon-b"}
+	]}`))
+	clusters := config.MarathonClusters()
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, clusters, 2)
+	assert.Equal(t, "team-a", clusters[0].Tag)
+	assert.Equal(t, "team-a:8080", clusters[0].Marathon.Location)
+	assert.Equal(t, "secret", clusters[0].Marathon.Token)
+	assert.Equal(t, "https", clusters[0].Marathon.Protocol)
+	assert.Equal(t, time.Second, clusters[0].Marathon.Timeout.Duration)
+	assert.Equal(t, "team-a:8080", clusters[0].Sync.Leader)
+	assert.True(t, clusters[0].Sync.Enabled)
+	assert.Equal(t, metrics.Cluster("team-a"), clusters[0].Marathon.MetricsScope)
+	assert.Equal(t, metrics.Cluster("team-a"), clusters[0].Sync.MetricsScope)
+	assert.Equal(t, "/events/team-a", clusters[0].EventsPath())
+
+	assert.Equal(t, "marathon-b", clusters[1].Tag)
+	assert.Equal(t, "localhost:8080", clusters[1].Marathon.Location)
+	assert.Equal(t, "", clusters[1].Marathon.Token)
+	assert.Equal(t, "localhost:8080", clusters[1].Sync.Leader)
+}
+
+func TestConfig_SingleClusterWithoutClustersListed(t *testing.T) {
+	t.Parallel()
+
+	// given
+	config := &Config{Marathon: marathon.Config{Location: "localhost:8080"}}
+	config.Consul.Tag = "marathon"
+
+	// when
+	err := config.loadClusters([]byte(`{"Registry": "consul"}`))
+	clusters := config.MarathonClusters()
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "marathon", clusters[0].Tag)
+	assert.Equal(t, "localhost:8080", clusters[0].Marathon.Location)
+	assert.Equal(t, metrics.Scope(""), clusters[0].Marathon.MetricsScope)
+	assert.Equal(t, "/events", clusters[0].EventsPath())
+}
+
+func TestConfig_InvalidClusters(t *testing.T) {
+	t.Parallel()
+
+	for _, clusters := range []string{
+		`{"Clusters": [{"Name": ""}]}`,
+		`{"Clusters": [{"Name": "team/a"}]}`,
+		`{"Clusters": [{"Name": "a"}, {"Name": "a", "Tag": "other"}]}`,
+		`{"Clusters": [{"Name": "a", "Tag": "marathon"}, {"Name": "b", "Tag": "marathon"}]}`,
+	} {
+		// when
+		err := (&Config{}).loadClusters([]byte(clusters))
+
+		// then
+		assert.Error(t, err, clusters)
+	}
+}
+
+func TestCluster_Web(t *testing.T) {
+	t.Parallel()
+
+	// given
+	config := web.Config{QueueSize: 10, QueueSpillDir: "/var/spool/marathon-consul"}
+
+	// when
+	single := Cluster{}.Web(config)
+	named := Cluster{Name: "team-a"}.Web(config)
+
+	// then
+	assert.Equal(t, config, single)
+	assert.Equal(t, 10, named.QueueSize)
+	assert.Equal(t, "/var/spool/marathon-consul/team-a", named.QueueSpillDir)
+	assert.Equal(t, metrics.Cluster("team-a"), named.MetricsScope)
+}
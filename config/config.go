@@ -2,50 +2,68 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/audit"
 	"github.com/allegro/marathon-consul/consul"
+	"github.com/allegro/marathon-consul/etcd"
+	"github.com/allegro/marathon-consul/file"
+	"github.com/allegro/marathon-consul/logging"
 	"github.com/allegro/marathon-consul/marathon"
 	"github.com/allegro/marathon-consul/metrics"
 	"github.com/allegro/marathon-consul/sentry"
 	"github.com/allegro/marathon-consul/sync"
+	"github.com/allegro/marathon-consul/vault"
 	"github.com/allegro/marathon-consul/web"
+	"github.com/allegro/marathon-consul/zookeeper"
 	flag "github.com/ogier/pflag"
 )
 
 type Config struct {
-	Consul   consul.Config
-	Web      web.Config
-	Sync     sync.Config
-	Marathon marathon.Config
-	Metrics  metrics.Config
+	Registry  string
+	Consul    consul.Config
+	Etcd      etcd.Config
+	Zookeeper zookeeper.Config
+	File      file.Config
+	Web       web.Config
+	Sync      sync.Config
+	Marathon  marathon.Config
+	Metrics   metrics.Config
+	Vault     vault.Config
+	Secrets   Secrets
+	Audit     audit.Config
+	// Clusters are Marathon clusters synced by the process, they are read from the configuration file only
+	Clusters []Cluster `json:"-"`
 	Log      struct {
 		Level  string
 		Format string
 		File   string
-		Sentry sentry.Config
+		// Rotation of the log file
+		Rotation logging.Rotation
+		Syslog   logging.Syslog
+		// Journald sends logs to the journal in addition to the file or STDERR
+		Journald bool
+		Sentry   sentry.Config
 	}
-	configFile string
+	configFile  string
+	printConfig bool
 }
 
 var config = &Config{}
 
+// New reads the configuration. Options are taken from defaults, overridden by the configuration file,
+// then by MARATHON_CONSUL_* environment variables and finally by flags given on the command line.
 func New() (*Config, error) {
-	if !flag.Parsed() {
-		config.parseFlags()
-	}
-	flag.Parse()
-	err := config.loadConfigFromFile()
-
-	if err != nil {
+	if err := config.load(os.Args[1:]); err != nil {
 		return nil, err
 	}
 
-	err = config.setLogOutput()
+	err := config.setLogOutput()
 	if err != nil {
 		return nil, err
 	}
@@ -58,43 +76,133 @@ func New() (*Config, error) {
 	return config, err
 }
 
-func (config *Config) parseFlags() {
+// Reload reads the configuration again the way New does, the configuration in use is not changed
+func (config *Config) Reload() (*Config, error) {
+	reloaded := &Config{}
+	if err := reloaded.load(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	return reloaded, nil
+}
+
+func (config *Config) load(args []string) error {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	config.parseFlags(flags)
+	flags.Parse(args)
+
+	// flags given on the command line are set again once the file and environment are applied
+	commandLine := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = f.Value.String()
+	})
+	if _, ok := commandLine["config-file"]; !ok {
+		if file, ok := os.LookupEnv(envName("config-file")); ok {
+			config.configFile = file
+		}
+	}
+
+	jsonBlob, err := config.loadConfigFromFile()
+	if err != nil {
+		return err
+	}
+	if err := applyEnvAndCommandLine(flags, commandLine); err != nil {
+		return err
+	}
+	if err := config.loadClusters(jsonBlob); err != nil {
+		return err
+	}
+	return config.validate()
+}
+
+func (config *Config) parseFlags(flag *flag.FlagSet) {
+	// Registry
+	flag.StringVar(&config.Registry, "registry", "consul", "Service registry backend tasks are registered in: consul, etcd, zookeeper or file")
+
 	// Consul
 	flag.StringVar(&config.Consul.Port, "consul-port", "8500", "Consul port")
 	flag.BoolVar(&config.Consul.Auth.Enabled, "consul-auth", false, "Use Consul with authentication")
 	flag.StringVar(&config.Consul.Auth.Username, "consul-auth-username", "", "The basic authentication username")
 	flag.StringVar(&config.Consul.Auth.Password, "consul-auth-password", "", "The basic authentication password")
+	flag.StringVar(&config.Consul.Auth.PasswordFile, "consul-auth-password-file", "", "File the basic authentication password is read from")
+	flag.StringVar(&config.Consul.Auth.PasswordVault, "consul-auth-password-vault", "", "Vault secret the basic authentication password is read from, as <mount>/<path>#<key>")
 	flag.BoolVar(&config.Consul.SslEnabled, "consul-ssl", false, "Use HTTPS when talking to Consul")
 	flag.BoolVar(&config.Consul.SslVerify, "consul-ssl-verify", true, "Verify certificates when connecting via SSL")
 	flag.StringVar(&config.Consul.SslCert, "consul-ssl-cert", "", "Path to an SSL client certificate to use to authenticate to the Consul server")
 	flag.StringVar(&config.Consul.SslCaCert, "consul-ssl-ca-cert", "", "Path to a CA certificate file, containing one or more CA certificates to use to validate the certificate sent by the Consul server to us")
 	flag.StringVar(&config.Consul.Token, "consul-token", "", "The Consul ACL token")
+	flag.StringVar(&config.Consul.TokenFile, "consul-token-file", "", "File the Consul ACL token is read from")
+	flag.StringVar(&config.Consul.TokenVault, "consul-token-vault", "", "Vault secret the Consul ACL token is read from, as <mount>/<path>#<key>")
+	flag.Var(stringMap{&config.Consul.Tokens}, "consul-tokens", "Consul ACL tokens of apps labeled with consul-token-alias, as comma separated alias=token pairs")
+	flag.StringVar(&config.Consul.TokensVault, "consul-tokens-vault", "", "Vault secret Consul ACL tokens of apps are read from, as <mount>/<path> with a key per token alias")
 	flag.StringVar(&config.Consul.Tag, "consul-tag", "marathon", "Common tag name added to every service registered in Consul, should be unique for every Marathon-cluster connected to Consul")
 	flag.DurationVar(&config.Consul.Timeout.Duration, "consul-timeout", 3*time.Second, "Time limit for requests made by the Consul HTTP client. A Timeout of zero means no timeout")
 	flag.Uint32Var(&config.Consul.AgentFailuresTolerance, "consul-max-agent-failures", 3, "Max number of consecutive request failures for agent before removal from cache")
 	flag.Uint32Var(&config.Consul.RequestRetries, "consul-get-services-retry", 3, "Number of retries on failure when performing requests to Consul. Each retry uses different cached agent")
 	flag.StringVar(&config.Consul.ConsulNameSeparator, "consul-name-separator", ".", "Separator used to create default service name for Consul")
+	flag.StringVar(&config.Consul.ConsulTagLabelPrefix, "consul-tag-label-prefix", "", "Labels with this prefix are converted to key=value tags, e.g. consul-tag-. Empty value disables the conversion")
 	flag.StringVar(&config.Consul.IgnoredHealthChecks, "consul-ignored-healthchecks", "", "A comma separated blacklist of Marathon health check types that will not be migrated to Consul, e.g. command,tcp")
 
+	// Etcd
+	flag.StringVar(&config.Etcd.Endpoints, "etcd-endpoints", "http://localhost:2379", "A comma separated list of etcd endpoints (used when registry is set to etcd)")
+	flag.StringVar(&config.Etcd.Prefix, "etcd-prefix", "/marathon-consul/services", "Key prefix under which services are registered in etcd")
+	flag.DurationVar(&config.Etcd.LeaseTTL.Duration, "etcd-lease-ttl", 30*time.Second, "TTL of the lease attached to registrations in etcd, registrations expire when marathon-consul stops refreshing it")
+	flag.DurationVar(&config.Etcd.Timeout.Duration, "etcd-timeout", 3*time.Second, "Time limit for requests made by the etcd HTTP client. A Timeout of zero means no timeout")
+
+	// ZooKeeper
+	flag.StringVar(&config.Zookeeper.Servers, "zookeeper-servers", "localhost:2181", "A comma separated list of ZooKeeper servers (used when registry is set to zookeeper)")
+	flag.StringVar(&config.Zookeeper.Prefix, "zookeeper-prefix", "/marathon-consul/services", "Path under which services are registered in ZooKeeper")
+	flag.DurationVar(&config.Zookeeper.SessionTimeout.Duration, "zookeeper-session-timeout", 30*time.Second, "Timeout of the ZooKeeper session, registrations expire when marathon-consul stops keeping it alive")
+	flag.DurationVar(&config.Zookeeper.Timeout.Duration, "zookeeper-timeout", 3*time.Second, "Time limit for connecting to ZooKeeper and for its requests. A Timeout of zero means no timeout")
+
+	// File
+	flag.StringVar(&config.File.Path, "file-path", "", "Path to a file the snapshot of registrations is written to (used when registry is set to file)")
+	flag.StringVar(&config.File.Format, "file-format", "json", "Format of the registrations snapshot: json or yaml")
+
 	// Web
 	flag.StringVar(&config.Web.Listen, "listen", ":4000", "Accept connections at this address")
 	flag.IntVar(&config.Web.QueueSize, "events-queue-size", 1000, "Size of events queue")
+	flag.StringVar(&config.Web.QueueOverflow, "events-queue-overflow", "drop", "Strategy applied to events when the queue is full: drop, block, spill or resync")
+	flag.DurationVar(&config.Web.QueueBlockTimeout.Duration, "events-queue-block-timeout", time.Second, "Time an event waits for room in the queue before it is dropped (used when events-queue-overflow is set to block)")
+	flag.StringVar(&config.Web.QueueSpillDir, "events-queue-spill-dir", "", "Directory events that do not fit into the queue are written to (used when events-queue-overflow is set to spill)")
 	flag.IntVar(&config.Web.WorkersCount, "workers-pool-size", 10, "Number of concurrent workers processing events")
 	flag.Int64Var(&config.Web.MaxEventSize, "event-max-size", 4096, "Maximum size of event to process (bytes)")
+	flag.DurationVar(&config.Web.MaxEventAge.Duration, "event-max-age", 0, "Events older than this are dropped, 0 disables the check")
+	flag.IntVar(&config.Web.DedupWindow, "event-dedup-window", 1000, "Number of recent task events remembered to drop duplicates and events about tasks in terminal state, 0 disables it")
+	flag.DurationVar(&config.Web.DrainDelay.Duration, "drain-delay", 0, "Time services of tasks being killed are kept in maintenance before deregistration, 0 deregisters them immediately")
+	flag.BoolVar(&config.Web.DrainDeployments, "drain-deployments", false, "Drain tasks that are going to be killed by deployments, announced by deployment events")
 
 	// Sync
 	flag.BoolVar(&config.Sync.Enabled, "sync-enabled", true, "Enable Marathon-consul scheduled sync")
 	flag.DurationVar(&config.Sync.Interval.Duration, "sync-interval", 15*time.Minute, "Marathon-consul sync interval")
 	flag.StringVar(&config.Sync.Leader, "sync-leader", "", "Marathon cluster-wide node name (defaults to <hostname>:8080), the sync will run only if the specified node is the current Marathon-leader")
 	flag.BoolVar(&config.Sync.Force, "sync-force", false, "Force leadership-independent Marathon-consul sync (run always)")
+	flag.StringVar(&config.Sync.UnhealthyTasks, "sync-unhealthy-tasks", "keep", "Action taken on services of tasks unhealthy for longer than the grace period: keep, deregister or maintenance")
+	flag.DurationVar(&config.Sync.UnhealthyGracePeriod.Duration, "sync-unhealthy-grace-period", 5*time.Minute, "Time a task may be unhealthy before its services are deregistered or put into maintenance")
+	flag.StringVar(&config.Sync.Groups, "sync-groups", "", "A comma separated list of Marathon groups the sync is restricted to, e.g. /prod,/team/services. Empty syncs the whole cluster")
 
 	// Marathon
-	flag.StringVar(&config.Marathon.Location, "marathon-location", "localhost:8080", "Marathon URL")
+	flag.StringVar(&config.Marathon.Location, "marathon-location", "localhost:8080", "Marathon URL, or a comma separated list of Marathon masters URLs to fail over between")
 	flag.StringVar(&config.Marathon.Protocol, "marathon-protocol", "http", "Marathon protocol (http or https)")
 	flag.StringVar(&config.Marathon.Username, "marathon-username", "", "Marathon username for basic auth")
 	flag.StringVar(&config.Marathon.Password, "marathon-password", "", "Marathon password for basic auth")
+	flag.StringVar(&config.Marathon.PasswordFile, "marathon-password-file", "", "File the Marathon password for basic auth is read from")
+	flag.StringVar(&config.Marathon.PasswordVault, "marathon-password-vault", "", "Vault secret the Marathon password for basic auth is read from, as <mount>/<path>#<key>")
+	flag.StringVar(&config.Marathon.Token, "marathon-token", "", "Bearer token Marathon requests are authorized with")
+	flag.StringVar(&config.Marathon.TokenFile, "marathon-token-file", "", "File the bearer token Marathon requests are authorized with is read from, the file is read again when it changes")
+	flag.StringVar(&config.Marathon.TokenVault, "marathon-token-vault", "", "Vault secret the bearer token Marathon requests are authorized with is read from, as <mount>/<path>#<key>")
+	flag.StringVar(&config.Marathon.DCOSServiceAccount, "marathon-dcos-service-account", "", "DC/OS service account ID Marathon requests are authorized with")
+	flag.StringVar(&config.Marathon.DCOSPrivateKey, "marathon-dcos-private-key", "", "File with PEM encoded private key of the DC/OS service account")
+	flag.StringVar(&config.Marathon.DCOSLoginURL, "marathon-dcos-login-url", "https://leader.mesos/acs/api/v1/auth/login", "DC/OS login endpoint the service account is exchanging login tokens for authentication tokens at")
+	flag.StringVar(&config.Marathon.AppsInclude, "marathon-apps-include", "", "A comma separated list of app id globs or group paths registered even without the consul label, e.g. /prod/**")
+	flag.StringVar(&config.Marathon.AppsExclude, "marathon-apps-exclude", "", "A comma separated list of app id globs or group paths never registered, e.g. /tmp/**")
+	flag.BoolVar(&config.Marathon.FetchByGroup, "marathon-fetch-by-group", false, "Fetch apps from Marathon group by group instead of all at once, for clusters with too many apps to fetch in a single response")
 	flag.BoolVar(&config.Marathon.VerifySsl, "marathon-ssl-verify", true, "Verify certificates when connecting via SSL")
+	flag.StringVar(&config.Marathon.SslCaCert, "marathon-ssl-ca-cert", "", "Path to a CA certificate file, containing one or more CA certificates to use to validate the certificate sent by Marathon")
+	flag.StringVar(&config.Marathon.SslCert, "marathon-ssl-cert", "", "Path to an SSL client certificate to use to authenticate to Marathon")
+	flag.StringVar(&config.Marathon.SslKey, "marathon-ssl-key", "", "Path to the key of the SSL client certificate, if it is not included in the certificate file")
 	flag.DurationVar(&config.Marathon.Timeout.Duration, "marathon-timeout", 30*time.Second, "Time limit for requests made by the Marathon HTTP client. A Timeout of zero means no timeout")
+	flag.DurationVar(&config.Marathon.EndpointBackoff.Duration, "marathon-endpoint-backoff", 30*time.Second, "Time a failing Marathon master is tried only after the other ones")
+	flag.DurationVar(&config.Marathon.AppCacheTTL.Duration, "marathon-app-cache-ttl", 5*time.Second, "Time apps fetched from Marathon are kept to be shared between events workers, with 0 only concurrent lookups are shared")
 
 	// Metrics
 	flag.StringVar(&config.Metrics.Target, "metrics-target", "stdout", "Metrics destination stdout or graphite (empty string disables metrics)")
@@ -102,10 +210,35 @@ func (config *Config) parseFlags() {
 	flag.DurationVar(&config.Metrics.Interval.Duration, "metrics-interval", 30*time.Second, "Metrics reporting interval")
 	flag.StringVar(&config.Metrics.Addr, "metrics-location", "", "Graphite URL (used when metrics-target is set to graphite)")
 
+	// Vault
+	flag.StringVar(&config.Vault.Address, "vault-address", "", "Vault URL secrets referenced by *-vault options are read from, e.g. https://vault.service.consul:8200")
+	flag.StringVar(&config.Vault.Token, "vault-token", "", "Vault token secrets are read with")
+	flag.StringVar(&config.Vault.TokenFile, "vault-token-file", "", "File the Vault token secrets are read with is read from")
+	flag.StringVar(&config.Vault.AppRoleMount, "vault-approle-mount", "approle", "Path the Vault AppRole auth method is mounted at")
+	flag.StringVar(&config.Vault.AppRoleID, "vault-approle-role-id", "", "Vault AppRole role ID used to log in instead of a token")
+	flag.StringVar(&config.Vault.AppRoleSecretID, "vault-approle-secret-id", "", "Vault AppRole secret ID")
+	flag.StringVar(&config.Vault.AppRoleSecretIDFile, "vault-approle-secret-id-file", "", "File the Vault AppRole secret ID is read from")
+	flag.DurationVar(&config.Vault.Timeout.Duration, "vault-timeout", 10*time.Second, "Time limit for requests made by the Vault HTTP client. A Timeout of zero means no timeout")
+	flag.DurationVar(&config.Secrets.RefreshInterval.Duration, "secrets-refresh-interval", time.Minute, "Interval secrets are read again from files and Vault at, 0 reads them only on start and reload")
+
+	// Audit
+	flag.BoolVar(&config.Audit.Enabled, "audit-enabled", false, "Record every registration, deregistration and maintenance change in the audit log")
+	flag.StringVar(&config.Audit.File, "audit-file", "", "File audit log entries are appended to as JSON lines. If empty entries are only kept in memory for the /audit endpoint")
+	flag.Int64Var(&config.Audit.MaxSize, "audit-max-size", 100, "Size in megabytes the audit log file is rotated at, 0 disables rotation")
+	flag.IntVar(&config.Audit.MaxBackups, "audit-max-backups", 5, "Number of rotated audit log files kept")
+	flag.IntVar(&config.Audit.Recent, "audit-recent", 1000, "Number of recent audit log entries kept in memory for the /audit endpoint")
+
 	// Log
 	flag.StringVar(&config.Log.Level, "log-level", "info", "Log level: panic, fatal, error, warn, info, or debug")
 	flag.StringVar(&config.Log.Format, "log-format", "text", "Log format: JSON, text")
 	flag.StringVar(&config.Log.File, "log-file", "", "Save logs to file (e.g.: `/var/log/marathon-consul.log`). If empty logs are published to STDERR")
+	flag.Int64Var(&config.Log.Rotation.MaxSize, "log-file-max-size", 0, "Size in megabytes the log file is rotated at, 0 disables rotation by size")
+	flag.DurationVar(&config.Log.Rotation.Interval.Duration, "log-file-rotate-interval", 0, "Interval the log file is rotated every, 0 disables rotation by time")
+	flag.IntVar(&config.Log.Rotation.MaxBackups, "log-file-max-backups", 5, "Number of rotated log files kept")
+	flag.DurationVar(&config.Log.Rotation.MaxAge.Duration, "log-file-max-age", 0, "Age rotated log files are removed at, 0 keeps them until there are more than log-file-max-backups")
+	flag.StringVar(&config.Log.Syslog.Address, "log-syslog", "", "Syslog server logs are sent to as RFC 5424 messages (e.g.: `udp://localhost:514`, `tcp://localhost:514` or `unix:///dev/log`). If empty logs are not sent to syslog")
+	flag.StringVar(&config.Log.Syslog.Facility, "log-syslog-facility", "local0", "Syslog facility: kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp or local0 to local7")
+	flag.BoolVar(&config.Log.Journald, "log-journald", false, "Send logs to journald with log fields as journal fields")
 
 	// Log -> Sentry
 	flag.StringVar(&config.Log.Sentry.DSN, "sentry-dsn", "", "Sentry DSN. If it's not set sentry will be disabled")
@@ -113,18 +246,27 @@ func (config *Config) parseFlags() {
 	flag.StringVar(&config.Log.Sentry.Level, "sentry-level", "error", "Sentry alerting level (info|warning|error|fatal|panic)")
 
 	// General
-	flag.StringVar(&config.configFile, "config-file", "", "Path to a JSON file to read configuration from. Note: Will override options set earlier on the command line")
+	flag.StringVar(&config.configFile, "config-file", "", "Path to a JSON, YAML (.yaml, .yml) or HCL (.hcl) file to read configuration from. Environment variables and flags override options set in the file")
+	flag.BoolVar(&config.printConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit")
 }
 
-func (config *Config) loadConfigFromFile() error {
+// loadConfigFromFile reads options set in the file and returns them as JSON
+func (config *Config) loadConfigFromFile() ([]byte, error) {
 	if config.configFile == "" {
-		return nil
+		return nil, nil
 	}
-	jsonBlob, err := ioutil.ReadFile(config.configFile)
+	content, err := ioutil.ReadFile(config.configFile)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	jsonBlob, err := toJSON(config.configFile, content)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse config file %s: %s", config.configFile, err)
 	}
-	return json.Unmarshal(jsonBlob, config)
+	if err := json.Unmarshal(jsonBlob, config); err != nil {
+		return nil, fmt.Errorf("Unable to read config file %s: %s", config.configFile, err)
+	}
+	return jsonBlob, nil
 }
 
 func (config *Config) setLogLevel() error {
@@ -138,6 +280,23 @@ func (config *Config) setLogLevel() error {
 }
 
 func (config *Config) setLogOutput() error {
+	if config.Log.Syslog.Address != "" {
+		hook, err := logging.NewSyslogHook(config.Log.Syslog)
+		if err != nil {
+			log.WithError(err).WithField("Address", config.Log.Syslog.Address).Error("Unable to connect to syslog")
+			return err
+		}
+		log.AddHook(hook)
+	}
+	if config.Log.Journald {
+		hook, err := logging.NewJournaldHook()
+		if err != nil {
+			log.WithError(err).Error("Unable to connect to journald")
+			return err
+		}
+		log.AddHook(hook)
+	}
+
 	path := config.Log.File
 
 	if len(path) == 0 {
@@ -145,7 +304,7 @@ func (config *Config) setLogOutput() error {
 		return nil
 	}
 
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	f, err := logging.OpenRotatingFile(path, config.Log.Rotation)
 	if err != nil {
 		log.WithError(err).Errorf("error opening file: %s", path)
 		return err
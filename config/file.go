@@ -0,0 +1,103 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	flag "github.com/ogier/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// Prefix of environment variables options are read from, e.g. MARATHON_CONSUL_CONSUL_PORT sets consul-port
+const envPrefix = "MARATHON_CONSUL_"
+
+// toJSON converts content of the configuration file to JSON, so options are decoded the same way
+// whatever the file format is. The format is told by the file extension, JSON is the default.
+func toJSON(path string, content []byte) ([]byte, error) {
+	var options interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &options); err != nil {
+			return nil, err
+		}
+	case ".hcl":
+		hclOptions := make(map[string]interface{})
+		if err := hcl.Unmarshal(content, &hclOptions); err != nil {
+			return nil, err
+		}
+		options = hclOptions
+	default:
+		return content, nil
+	}
+	return json.Marshal(normalize("", options))
+}
+
+// normalize makes decoded YAML and HCL options encodable as JSON. YAML maps have keys of any type,
+// HCL decodes every block as a list of blocks, so blocks given once are unwrapped unless they are clusters.
+func normalize(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			name := fmt.Sprint(k)
+			normalized[name] = normalize(name, item)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for name, item := range v {
+			normalized[name] = normalize(name, item)
+		}
+		return normalized
+	case []map[string]interface{}:
+		if len(v) == 1 && !strings.EqualFold(key, "Clusters") {
+			return normalize(key, v[0])
+		}
+		normalized := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			normalized = append(normalized, normalize("", item))
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			normalized = append(normalized, normalize("", item))
+		}
+		return normalized
+	default:
+		return value
+	}
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// applyEnvAndCommandLine sets flags from environment variables, then sets flags given on the command line again,
+// so they override both the file and the environment
+func applyEnvAndCommandLine(flags *flag.FlagSet, commandLine map[string]string) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if _, ok := commandLine[f.Name]; ok || err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("Invalid value %q of %s: %s", value, envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for name, value := range commandLine {
+		if err := flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Value printed instead of secrets
+const redacted = "<redacted>"
+
+// PrintRequested tells whether the effective configuration should be printed instead of running
+func (config *Config) PrintRequested() bool {
+	return config.printConfig
+}
+
+// Print writes the effective configuration as JSON with secrets redacted
+func (config *Config) Print(w io.Writer) error {
+	printed := *config
+	printed.Consul.Auth.Password = redact(printed.Consul.Auth.Password)
+	printed.Consul.Token = redact(printed.Consul.Token)
+	if config.Consul.Tokens != nil {
+		printed.Consul.Tokens = make(map[string]string, len(config.Consul.Tokens))
+		for alias, token := range config.Consul.Tokens {
+			printed.Consul.Tokens[alias] = redact(token)
+		}
+	}
+	printed.Log.Sentry.DSN = redact(printed.Log.Sentry.DSN)
+	printed.Marathon.Password = redact(printed.Marathon.Password)
+	printed.Marathon.Token = redact(printed.Marathon.Token)
+	printed.Vault.Token = redact(printed.Vault.Token)
+	printed.Vault.AppRoleSecretID = redact(printed.Vault.AppRoleSecretID)
+	printed.Clusters = make([]Cluster, 0, len(config.Clusters))
+	for _, cluster := range config.Clusters {
+		cluster.Marathon.Password = redact(cluster.Marathon.Password)
+		cluster.Marathon.Token = redact(cluster.Marathon.Token)
+		printed.Clusters = append(printed.Clusters, cluster)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		*Config
+		Clusters []Cluster `json:",omitempty"`
+	}{&printed, printed.Clusters})
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/allegro/marathon-consul/time"
+)
+
+// reloadable lists options applied while running, changes of other options require a restart.
+// Options of a Marathon cluster are listed without the Clusters[name] prefix.
+var reloadable = []string{
+	"Log.Level",
+	"Log.Format",
+	"Sync.Interval",
+	"Sync.Leader",
+	"Sync.Force",
+	"Consul.IgnoredHealthChecks",
+	"Consul.Token",
+	"Consul.TokenFile",
+	"Consul.TokenVault",
+	"Consul.Tokens",
+	"Consul.TokensVault",
+	"Consul.Auth",
+	"Marathon.Username",
+	"Marathon.Password",
+	"Marathon.PasswordFile",
+	"Marathon.PasswordVault",
+	"Marathon.Token",
+	"Marathon.TokenFile",
+	"Marathon.TokenVault",
+	"Marathon.DCOSServiceAccount",
+	"Marathon.DCOSPrivateKey",
+	"Marathon.DCOSLoginURL",
+	"Marathon.AppsInclude",
+	"Marathon.AppsExclude",
+	"Metrics",
+	"Vault",
+}
+
+var intervalType = reflect.TypeOf(time.Interval{})
+
+// Update copies options that can change while running from the reloaded configuration and applies changed log options.
+// It returns names of the options changed, split into the applied ones and the ones requiring a restart.
+func (config *Config) Update(reloaded *Config) (applied []string, rejected []string) {
+	visit := func(option string, current reflect.Value, changed reflect.Value) {
+		if isReloadable(option) {
+			current.Set(changed)
+			applied = append(applied, option)
+		} else {
+			rejected = append(rejected, option)
+		}
+	}
+	diff("", reflect.ValueOf(config).Elem(), reflect.ValueOf(reloaded).Elem(), visit)
+
+	clusters := make(map[string]*Cluster)
+	for i := range reloaded.Clusters {
+		clusters[reloaded.Clusters[i].Name] = &reloaded.Clusters[i]
+	}
+	for i := range config.Clusters {
+		current := &config.Clusters[i]
+		changed, ok := clusters[current.Name]
+		if !ok {
+			rejected = append(rejected, fmt.Sprintf("Clusters[%s]", current.Name))
+			continue
+		}
+		delete(clusters, current.Name)
+		diff(fmt.Sprintf("Clusters[%s].", current.Name), reflect.ValueOf(current).Elem(), reflect.ValueOf(changed).Elem(), visit)
+	}
+	for _, added := range reloaded.Clusters {
+		if _, ok := clusters[added.Name]; ok {
+			rejected = append(rejected, fmt.Sprintf("Clusters[%s]", added.Name))
+		}
+	}
+
+	for _, option := range applied {
+		if strings.HasPrefix(option, "Log.") {
+			config.setLogFormat()
+			config.setLogLevel()
+			break
+		}
+	}
+	return applied, rejected
+}
+
+// diff calls visit with every option that differs between the configurations, structs are compared field by field.
+// Unexported fields and fields not read from the configuration file are skipped.
+func diff(prefix string, current reflect.Value, reloaded reflect.Value, visit func(string, reflect.Value, reflect.Value)) {
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		option := prefix + field.Name
+		if field.Type.Kind() == reflect.Struct && field.Type != intervalType {
+			diff(option+".", current.Field(i), reloaded.Field(i), visit)
+			continue
+		}
+		if !reflect.DeepEqual(current.Field(i).Interface(), reloaded.Field(i).Interface()) {
+			visit(option, current.Field(i), reloaded.Field(i))
+		}
+	}
+}
+
+func isReloadable(option string) bool {
+	if strings.HasPrefix(option, "Clusters[") {
+		option = option[strings.Index(option, "].")+2:]
+	}
+	for _, r := range reloadable {
+		if option == r || strings.HasPrefix(option, r+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/sync"
+	timeutil "github.com/allegro/marathon-consul/time"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdate_AppliesReloadableOptionsOnly(t *testing.T) {
+	// given
+	current := &Config{}
+	current.Log.Level, current.Log.Format = "info", "text"
+	current.Consul.Tag, current.Consul.Token = "marathon", "old"
+	current.Sync.Interval = timeutil.Interval{Duration: time.Minute}
+	current.Web.Listen = ":4000"
+
+	reloaded := &Config{}
+	reloaded.Log.Level, reloaded.Log.Format = "info", "text"
+	reloaded.Consul.Tag, reloaded.Consul.Token = "other-marathon", "new"
+	reloaded.Sync.Interval = timeutil.Interval{Duration: 2 * time.Minute}
+	reloaded.Web.Listen = ":5000"
+
+	// when
+	applied, rejected := current.Update(reloaded)
+
+	// then
+	assert.Equal(t, []string{"Consul.Token", "Sync.Interval"}, applied)
+	assert.Equal(t, []string{"Consul.Tag", "Web.Listen"}, rejected)
+	assert.Equal(t, "new", current.Consul.Token)
+	assert.Equal(t, 2*time.Minute, current.Sync.Interval.Duration)
+	assert.Equal(t, "marathon", current.Consul.Tag)
+	assert.Equal(t, ":4000", current.Web.Listen)
+}
+
+func TestUpdate_ComparesClustersByName(t *testing.T) {
+	// given
+	current := &Config{Clusters: []Cluster{
+		{Name: "a", Tag: "a", Sync: sync.Config{Leader: "x:8080"}},
+		{Name: "b", Tag: "b"},
+	}}
+	current.Log.Level, current.Log.Format = "info", "text"
+	reloaded := &Config{Clusters: []Cluster{
+		{Name: "c", Tag: "c"},
+		{Name: "a", Tag: "a-tag", Sync: sync.Config{Leader: "y:8080"}},
+	}}
+	reloaded.Log.Level, reloaded.Log.Format = "info", "text"
+
+	// when
+	applied, rejected := current.Update(reloaded)
+
+	// then
+	assert.Equal(t, []string{"Clusters[a].Sync.Leader"}, applied)
+	assert.Equal(t, []string{"Clusters[a].Tag", "Clusters[b]", "Clusters[c]"}, rejected)
+	assert.Equal(t, "y:8080", current.Clusters[0].Sync.Leader)
+	assert.Equal(t, "a", current.Clusters[0].Tag)
+}
+
+func TestUpdate_AppliesRegistrationRulesOfClusters(t *testing.T) {
+	// given
+	current := &Config{Clusters: []Cluster{{Name: "a", Tag: "a"}}}
+	current.Log.Level, current.Log.Format = "info", "text"
+	reloaded := &Config{Clusters: []Cluster{{Name: "a", Tag: "a"}}}
+	reloaded.Log.Level, reloaded.Log.Format = "info", "text"
+	reloaded.Clusters[0].Marathon.AppsInclude = "/prod/**"
+	reloaded.Clusters[0].Marathon.AppsExclude = "/tmp/**"
+
+	// when
+	applied, rejected := current.Update(reloaded)
+
+	// then
+	assert.Equal(t, []string{"Clusters[a].Marathon.AppsInclude", "Clusters[a].Marathon.AppsExclude"}, applied)
+	assert.Empty(t, rejected)
+	assert.Equal(t, "/prod/**", current.Clusters[0].Marathon.AppsInclude)
+	assert.Equal(t, "/tmp/**", current.Clusters[0].Marathon.AppsExclude)
+}
+
+func TestReload_ReadsChangedFile(t *testing.T) {
+	clear()
+
+	// given
+	file := tempConfigFile(t, "config.yaml", "Consul:\n  IgnoredHealthChecks: tcp\n")
+	os.Args = []string{"./marathon-consul", "--config-file=" + file, "--log-level=info"}
+	current, err := New()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(file, []byte("Consul:\n  IgnoredHealthChecks: http\n  Tag: other\n"), 0600))
+
+	// when
+	reloaded, err := current.Reload()
+
+	// then
+	require.NoError(t, err)
+	applied, rejected := current.Update(reloaded)
+	assert.Equal(t, []string{"Consul.IgnoredHealthChecks"}, applied)
+	assert.Equal(t, []string{"Consul.Tag"}, rejected)
+	assert.Equal(t, "http", current.Consul.IgnoredHealthChecks)
+}
+
+func TestReload_ReturnsErrorForInvalidFile(t *testing.T) {
+	clear()
+
+	// given
+	file := tempConfigFile(t, "config.json", `{"Sync": {"Interval": "1m"}}`)
+	os.Args = []string{"./marathon-consul", "--config-file=" + file}
+	current, err := New()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"Sync": {"Interval": "-1m"}}`), 0600))
+
+	// when
+	_, err = current.Reload()
+
+	// then
+	assert.Error(t, err)
+	assert.Equal(t, time.Minute, current.Sync.Interval.Duration)
+}
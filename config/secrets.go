@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/allegro/marathon-consul/marathon"
+	"github.com/allegro/marathon-consul/time"
+	"github.com/allegro/marathon-consul/utils"
+)
+
+// Secrets configures reading secrets given as files or Vault references instead of plain options
+type Secrets struct {
+	RefreshInterval time.Interval
+}
+
+// SecretReader reads secrets referenced as <mount>/<path>#<key>
+type SecretReader interface {
+	Read(reference string) (string, error)
+	// ReadAll reads all keys of the secret at <mount>/<path>
+	ReadAll(path string) (map[string]string, error)
+}
+
+type secretResolver struct {
+	vault  SecretReader
+	errors []error
+}
+
+// ResolveSecrets sets secret options given as files or Vault references to their current values,
+// vault is nil when Vault is not configured
+func (config *Config) ResolveSecrets(vault SecretReader) error {
+	r := &secretResolver{vault: vault}
+	r.resolve(&config.Consul.Token, config.Consul.TokenFile, config.Consul.TokenVault, "Consul.Token")
+	r.resolveAll(&config.Consul.Tokens, config.Consul.TokensVault, "Consul.Tokens")
+	r.resolve(&config.Consul.Auth.Password, config.Consul.Auth.PasswordFile, config.Consul.Auth.PasswordVault, "Consul.Auth.Password")
+	r.resolveMarathon(&config.Marathon, "Marathon")
+	for i := range config.Clusters {
+		r.resolveMarathon(&config.Clusters[i].Marathon, fmt.Sprintf("Clusters[%s].Marathon", config.Clusters[i].Name))
+	}
+	return utils.MergeErrorsOrNil(r.errors, "reading secrets")
+}
+
+func (r *secretResolver) resolveMarathon(config *marathon.Config, field string) {
+	r.resolve(&config.Password, config.PasswordFile, config.PasswordVault, field+".Password")
+	// the token file is read by the Marathon client whenever it changes
+	r.resolve(&config.Token, "", config.TokenVault, field+".Token")
+}
+
+// resolveAll replaces values with all keys of the Vault secret, the map is replaced rather than
+// modified as it may be shared with a copy of the configuration
+func (r *secretResolver) resolveAll(values *map[string]string, path string, field string) {
+	if path == "" {
+		return
+	}
+	if r.vault == nil {
+		r.errors = append(r.errors, fmt.Errorf("%s: Vault is not configured", field))
+		return
+	}
+	secrets, err := r.vault.ReadAll(path)
+	if err != nil {
+		r.errors = append(r.errors, fmt.Errorf("%s: %s", field, err))
+		return
+	}
+	*values = secrets
+}
+
+func (r *secretResolver) resolve(value *string, file string, reference string, field string) {
+	switch {
+	case file != "":
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			r.errors = append(r.errors, fmt.Errorf("%s: %s", field, err))
+			return
+		}
+		*value = strings.TrimSpace(string(content))
+	case reference != "":
+		if r.vault == nil {
+			r.errors = append(r.errors, fmt.Errorf("%s: Vault is not configured", field))
+			return
+		}
+		secret, err := r.vault.Read(reference)
+		if err != nil {
+			r.errors = append(r.errors, fmt.Errorf("%s: %s", field, err))
+			return
+		}
+		*value = secret
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/allegro/marathon-consul/marathon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type secretsStub map[string]string
+
+func (s secretsStub) Read(reference string) (string, error) {
+	if secret, ok := s[reference]; ok {
+		return secret, nil
+	}
+	return "", errors.New("secret not found")
+}
+
+func (s secretsStub) ReadAll(path string) (map[string]string, error) {
+	values := make(map[string]string)
+	for reference, secret := range s {
+		if strings.HasPrefix(reference, path+"#") {
+			values[strings.TrimPrefix(reference, path+"#")] = secret
+		}
+	}
+	if len(values) == 0 {
+		return nil, errors.New("secret not found")
+	}
+	return values, nil
+}
+
+func TestResolveSecrets_FromFiles(t *testing.T) {
+	t.Parallel()
+
+	// given
+	tokenFile := tempConfigFile(t, "token", "consul-secret\n")
+	defer os.RemoveAll(filepath.Dir(tokenFile))
+	passwordFile := tempConfigFile(t, "password", "marathon-secret")
+	defer os.RemoveAll(filepath.Dir(passwordFile))
+	config := &Config{}
+	config.Consul.TokenFile = tokenFile
+	config.Marathon.PasswordFile = passwordFile
+	config.Clusters = []Cluster{{Name: "team-a", Marathon: marathon.Config{PasswordFile: passwordFile}}}
+
+	// when
+	err := config.ResolveSecrets(nil)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "consul-secret", config.Consul.Token)
+	assert.Equal(t, "marathon-secret", config.Marathon.Password)
+	assert.Equal(t, "marathon-secret", config.Clusters[0].Marathon.Password)
+}
+
+func TestResolveSecrets_FromVault(t *testing.T) {
+	t.Parallel()
+
+	// given
+	vault := secretsStub{
+		"secret/marathon-consul#consul-token":    "consul-secret",
+		"secret/marathon-consul#consul-password": "consul-password",
+		"secret/marathon-consul#marathon-token":  "marathon-secret",
+		"secret/consul-tokens#payments":          "payments-token",
+	}
+	config := &Config{}
+	config.Consul.TokenVault = "secret/marathon-consul#consul-token"
+	config.Consul.Auth.PasswordVault = "secret/marathon-consul#consul-password"
+	config.Marathon.TokenVault = "secret/marathon-consul#marathon-token"
+	config.Consul.TokensVault = "secret/consul-tokens"
+
+	// when
+	err := config.ResolveSecrets(vault)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "consul-secret", config.Consul.Token)
+	assert.Equal(t, "consul-password", config.Consul.Auth.Password)
+	assert.Equal(t, "marathon-secret", config.Marathon.Token)
+	assert.Equal(t, map[string]string{"payments": "payments-token"}, config.Consul.Tokens)
+}
+
+func TestResolveSecrets_ReturnsErrorsOfAllSecrets(t *testing.T) {
+	t.Parallel()
+
+	// given
+	config := &Config{}
+	config.Consul.TokenFile = "/nonexistent/token"
+	config.Marathon.PasswordVault = "secret/marathon-consul#password"
+
+	// when
+	err := config.ResolveSecrets(nil)
+
+	// then
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Consul.Token: open /nonexistent/token")
+	assert.Contains(t, err.Error(), "Marathon.Password: Vault is not configured")
+}
+
+func TestConfig_ValidatesSecrets(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./marathon-consul", "--consul-token=secret", "--consul-token-file=/etc/token",
+		"--marathon-password-vault=secret/marathon#password"}
+
+	// when
+	_, err := New()
+
+	// then
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Consul.Token (--consul-token): only one of the value, file and Vault secret can be set")
+	assert.Contains(t, err.Error(), "Marathon.PasswordVault (--marathon-password-vault): requires Vault address to be set")
+}
+
+func TestConfig_ValidatesVaultAuth(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./marathon-consul", "--vault-address=http://localhost:8200",
+		"--vault-token=token", "--vault-approle-role-id=role"}
+
+	// when
+	_, err := New()
+
+	// then
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Vault: exactly one of token (--vault-token), token file (--vault-token-file) "+
+		"and AppRole (--vault-approle-role-id) must be set when Vault address is set")
+}
+
+func TestConfig_ParsesConsulTokens(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./marathon-consul", "--consul-tokens=payments=payments-token, search=search-token"}
+
+	// when
+	config, err := New()
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"payments": "payments-token", "search": "search-token"}, config.Consul.Tokens)
+}
+
+func TestConfig_ValidatesConsulTokens(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./marathon-consul", "--consul-tokens==token", "--consul-tokens-vault=secret/consul-tokens"}
+
+	// when
+	_, err := New()
+
+	// then
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Consul.Tokens (--consul-tokens): only one of the tokens and Vault secret can be set")
+	assert.Contains(t, err.Error(), "Consul.Tokens (--consul-tokens): token alias must not be empty")
+	assert.Contains(t, err.Error(), "Consul.TokensVault (--consul-tokens-vault): requires Vault address to be set")
+}
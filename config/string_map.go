@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stringMap is a flag value of comma separated key=value pairs, setting it replaces the whole map
+type stringMap struct {
+	values *map[string]string
+}
+
+func (m stringMap) Set(value string) error {
+	parsed := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		parsed[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	*m.values = parsed
+	return nil
+}
+
+func (m stringMap) String() string {
+	if m.values == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*m.values))
+	for key, value := range *m.values {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
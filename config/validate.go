@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/logging"
+	"github.com/allegro/marathon-consul/marathon"
+	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/sync"
+	"github.com/allegro/marathon-consul/time"
+	"github.com/allegro/marathon-consul/utils"
+	"github.com/allegro/marathon-consul/vault"
+	"github.com/allegro/marathon-consul/web"
+)
+
+// validator collects errors of invalid options, every error names the option and its flag
+type validator struct {
+	errors []error
+}
+
+func (v *validator) check(valid bool, field string, flag string, format string, args ...interface{}) {
+	if valid {
+		return
+	}
+	name := field
+	if flag != "" {
+		name = fmt.Sprintf("%s (--%s)", field, flag)
+	}
+	v.errors = append(v.errors, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) oneOf(value string, field string, flag string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.check(false, field, flag, "%q is not one of: %s", value, strings.Join(allowed, ", "))
+}
+
+// secret checks that a secret is given at most once: as a value, a file or a Vault reference
+func (v *validator) secret(field string, flag string, vaultAddress string, value string, file string, reference string) {
+	given := 0
+	for _, option := range []string{value, file, reference} {
+		if option != "" {
+			given++
+		}
+	}
+	v.check(given <= 1, field, flag, "only one of the value, file and Vault secret can be set")
+	if reference != "" {
+		v.check(vaultAddress != "", field+"Vault", flagOf(flag+"-vault", flag != ""), "requires Vault address to be set")
+	}
+}
+
+func (v *validator) notNegative(interval time.Interval, field string, flag string) {
+	v.check(interval.Duration >= 0, field, flag, "%s must not be negative", interval.Duration)
+}
+
+func (config *Config) validate() error {
+	v := &validator{}
+	v.oneOf(config.Registry, "Registry", "registry", "consul", "etcd", "zookeeper", "file")
+
+	port, err := strconv.Atoi(config.Consul.Port)
+	v.check(err == nil && port > 0 && port < 65536, "Consul.Port", "consul-port", "%q is not a valid port", config.Consul.Port)
+	v.check(config.Consul.Tag != "", "Consul.Tag", "consul-tag", "must not be empty")
+	v.notNegative(config.Consul.Timeout, "Consul.Timeout", "consul-timeout")
+
+	if config.Registry == "etcd" {
+		v.check(config.Etcd.Endpoints != "", "Etcd.Endpoints", "etcd-endpoints", "must not be empty when registry is etcd")
+		v.check(config.Etcd.LeaseTTL.Duration > 0, "Etcd.LeaseTTL", "etcd-lease-ttl", "%s must be positive", config.Etcd.LeaseTTL.Duration)
+	}
+	if config.Registry == "zookeeper" {
+		v.check(config.Zookeeper.Servers != "", "Zookeeper.Servers", "zookeeper-servers", "must not be empty when registry is zookeeper")
+		v.check(config.Zookeeper.SessionTimeout.Duration > 0, "Zookeeper.SessionTimeout", "zookeeper-session-timeout", "%s must be positive", config.Zookeeper.SessionTimeout.Duration)
+	}
+	if config.Registry == "file" {
+		v.check(config.File.Path != "", "File.Path", "file-path", "must not be empty when registry is file")
+	}
+	v.oneOf(strings.ToLower(config.File.Format), "File.Format", "file-format", "json", "yaml")
+
+	v.check(config.Web.QueueSize >= 0, "Web.QueueSize", "events-queue-size", "%d must not be negative", config.Web.QueueSize)
+	v.check(config.Web.WorkersCount > 0, "Web.WorkersCount", "workers-pool-size", "%d must be positive", config.Web.WorkersCount)
+	v.check(config.Web.DedupWindow >= 0, "Web.DedupWindow", "event-dedup-window", "%d must not be negative", config.Web.DedupWindow)
+	v.oneOf(config.Web.QueueOverflow, "Web.QueueOverflow", "events-queue-overflow",
+		web.OverflowDrop, web.OverflowBlock, web.OverflowSpill, web.OverflowResync)
+	if config.Web.QueueOverflow == web.OverflowSpill {
+		v.check(config.Web.QueueSpillDir != "", "Web.QueueSpillDir", "events-queue-spill-dir", "must not be empty when overflow strategy is spill")
+	}
+	v.notNegative(config.Web.DrainDelay, "Web.DrainDelay", "drain-delay")
+	v.notNegative(config.Web.MaxEventAge, "Web.MaxEventAge", "event-max-age")
+
+	_, err = log.ParseLevel(config.Log.Level)
+	v.check(err == nil, "Log.Level", "log-level", "%q is not a valid log level", config.Log.Level)
+	v.check(config.Log.Rotation.MaxSize >= 0, "Log.Rotation.MaxSize", "log-file-max-size", "%d must not be negative", config.Log.Rotation.MaxSize)
+	v.notNegative(config.Log.Rotation.Interval, "Log.Rotation.Interval", "log-file-rotate-interval")
+	v.check(config.Log.Rotation.MaxBackups >= 0, "Log.Rotation.MaxBackups", "log-file-max-backups", "%d must not be negative", config.Log.Rotation.MaxBackups)
+	v.notNegative(config.Log.Rotation.MaxAge, "Log.Rotation.MaxAge", "log-file-max-age")
+	if config.Log.Syslog.Address != "" {
+		_, _, err = logging.ParseSyslogAddress(config.Log.Syslog.Address)
+		v.check(err == nil, "Log.Syslog.Address", "log-syslog", "%v", err)
+		v.check(logging.IsSyslogFacility(config.Log.Syslog.Facility), "Log.Syslog.Facility", "log-syslog-facility",
+			"%q is not a syslog facility", config.Log.Syslog.Facility)
+	}
+
+	v.oneOf(config.Metrics.Target, "Metrics.Target", "metrics-target", "stdout", "graphite", "")
+	if config.Metrics.Target == "graphite" {
+		v.check(config.Metrics.Addr != "", "Metrics.Addr", "metrics-location", "must not be empty when metrics target is graphite")
+	}
+	if config.Metrics.Target != "" {
+		v.check(config.Metrics.Interval.Duration > 0, "Metrics.Interval", "metrics-interval", "%s must be positive", config.Metrics.Interval.Duration)
+	}
+
+	v.secret("Consul.Token", "consul-token", config.Vault.Address,
+		config.Consul.Token, config.Consul.TokenFile, config.Consul.TokenVault)
+	v.check(len(config.Consul.Tokens) == 0 || config.Consul.TokensVault == "", "Consul.Tokens", "consul-tokens",
+		"only one of the tokens and Vault secret can be set")
+	if config.Consul.TokensVault != "" {
+		v.check(config.Vault.Address != "", "Consul.TokensVault", "consul-tokens-vault", "requires Vault address to be set")
+	}
+	for alias := range config.Consul.Tokens {
+		v.check(alias != "", "Consul.Tokens", "consul-tokens", "token alias must not be empty")
+	}
+	v.secret("Consul.Auth.Password", "consul-auth-password", config.Vault.Address,
+		config.Consul.Auth.Password, config.Consul.Auth.PasswordFile, config.Consul.Auth.PasswordVault)
+	v.validateVault(config.Vault)
+	v.notNegative(config.Secrets.RefreshInterval, "Secrets.RefreshInterval", "secrets-refresh-interval")
+	v.check(config.Audit.MaxSize >= 0, "Audit.MaxSize", "audit-max-size", "%d must not be negative", config.Audit.MaxSize)
+	v.check(config.Audit.MaxBackups >= 0, "Audit.MaxBackups", "audit-max-backups", "%d must not be negative", config.Audit.MaxBackups)
+	v.check(config.Audit.Recent >= 0, "Audit.Recent", "audit-recent", "%d must not be negative", config.Audit.Recent)
+
+	v.validateMarathon(config.Marathon, "Marathon", config.Vault.Address, true)
+	v.validateSync(config.Sync, "Sync", true)
+	for _, cluster := range config.Clusters {
+		prefix := fmt.Sprintf("Clusters[%s].", cluster.Name)
+		v.validateMarathon(cluster.Marathon, prefix+"Marathon", config.Vault.Address, false)
+		v.validateSync(cluster.Sync, prefix+"Sync", false)
+	}
+
+	return utils.MergeErrorsOrNil(v.errors, "validating configuration")
+}
+
+// flagOf returns the flag of the option, options of clusters can be set in the file only
+func flagOf(name string, hasFlags bool) string {
+	if !hasFlags {
+		return ""
+	}
+	return name
+}
+
+func (v *validator) validateVault(config vault.Config) {
+	if config.Address == "" {
+		return
+	}
+	auths := 0
+	for _, option := range []string{config.Token, config.TokenFile, config.AppRoleID} {
+		if option != "" {
+			auths++
+		}
+	}
+	v.check(auths == 1, "Vault", "", "exactly one of token (--vault-token), token file (--vault-token-file) "+
+		"and AppRole (--vault-approle-role-id) must be set when Vault address is set")
+	v.check(config.AppRoleSecretID == "" || config.AppRoleSecretIDFile == "", "Vault.AppRoleSecretID", "vault-approle-secret-id",
+		"only one of the value and file can be set")
+	v.notNegative(config.Timeout, "Vault.Timeout", "vault-timeout")
+}
+
+func (v *validator) validateMarathon(config marathon.Config, field string, vaultAddress string, hasFlags bool) {
+	v.check(config.Location != "", field+".Location", flagOf("marathon-location", hasFlags), "must not be empty")
+	v.oneOf(strings.ToLower(config.Protocol), field+".Protocol", flagOf("marathon-protocol", hasFlags), "http", "https")
+	v.check(config.VerifySsl || config.SslCaCert == "", field+".SslCaCert", flagOf("marathon-ssl-ca-cert", hasFlags),
+		"must not be set when certificates are not verified (--marathon-ssl-verify=false)")
+	v.notNegative(config.Timeout, field+".Timeout", flagOf("marathon-timeout", hasFlags))
+	v.notNegative(config.AppCacheTTL, field+".AppCacheTTL", flagOf("marathon-app-cache-ttl", hasFlags))
+	v.notNegative(config.EndpointBackoff, field+".EndpointBackoff", flagOf("marathon-endpoint-backoff", hasFlags))
+	v.secret(field+".Password", flagOf("marathon-password", hasFlags), vaultAddress, config.Password, config.PasswordFile, config.PasswordVault)
+	v.secret(field+".Token", flagOf("marathon-token", hasFlags), vaultAddress, config.Token, config.TokenFile, config.TokenVault)
+	if config.DCOSServiceAccount != "" {
+		v.check(config.DCOSPrivateKey != "", field+".DCOSPrivateKey", flagOf("marathon-dcos-private-key", hasFlags),
+			"must not be empty when DC/OS service account is set")
+	}
+}
+
+func (v *validator) validateSync(config sync.Config, field string, hasFlags bool) {
+	if config.Enabled {
+		v.check(config.Interval.Duration > 0, field+".Interval", flagOf("sync-interval", hasFlags),
+			"%s must be positive when sync is enabled", config.Interval.Duration)
+	}
+	v.oneOf(config.UnhealthyTasks, field+".UnhealthyTasks", flagOf("sync-unhealthy-tasks", hasFlags),
+		service.UnhealthyKeep, service.UnhealthyDeregister, service.UnhealthyMaintenance)
+	v.notNegative(config.UnhealthyGracePeriod, field+".UnhealthyGracePeriod", flagOf("sync-unhealthy-grace-period", hasFlags))
+}
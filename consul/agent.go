@@ -23,7 +23,7 @@ func (a *Agent) ClearFailures() {
 }
 
 func (a *ConcurrentAgents) createAgent(ipAddress string) (*Agent, error) {
-	client, err := a.newConsulClient(ipAddress)
+	client, err := a.newConsulClient(ipAddress, "")
 	agent := &Agent{
 		Client: client,
 		IP:     ipAddress,
@@ -31,15 +31,17 @@ func (a *ConcurrentAgents) createAgent(ipAddress string) (*Agent, error) {
 	return agent, err
 }
 
-func (a *ConcurrentAgents) newConsulClient(ipAddress string) (*consulapi.Client, error) {
+// newConsulClient creates a client of the agent, token is sent with every request when it is not empty.
+// Cached clients are created without a token, ACL tokens are passed with each request instead.
+func (a *ConcurrentAgents) newConsulClient(ipAddress string, token string) (*consulapi.Client, error) {
 	config := consulapi.DefaultConfig()
 
 	config.HttpClient = a.client
 
 	config.Address = fmt.Sprintf("%s:%s", ipAddress, a.config.Port)
 
-	if a.config.Token != "" {
-		config.Token = a.config.Token
+	if token != "" {
+		config.Token = token
 	}
 
 	if a.config.SslEnabled {
@@ -58,7 +60,7 @@ func (a *ConcurrentAgents) newConsulClient(ipAddress string) (*consulapi.Client,
 		"Scheme":                 config.Scheme,
 		"Timeout":                config.HttpClient.Timeout,
 		"BasicAuthEnabled":       a.config.Auth.Enabled,
-		"TokenEnabled":           a.config.Token != "",
+		"TokenEnabled":           token != "",
 		"SslVerificationEnabled": a.config.SslVerify,
 	}).Debug("Creating Consul client")
 
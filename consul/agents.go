@@ -16,14 +16,23 @@ import (
 type Agents interface {
 	GetAgent(agentAddress string) (agent *consulapi.Client, err error)
 	GetAnyAgent() (agent *Agent, err error)
+	GetAgentWithToken(agentAddress string, token string) (agent *consulapi.Client, err error)
 	RemoveAgent(agentAddress string)
+	UpdateAuth(auth Auth) error
 }
 
 type ConcurrentAgents struct {
 	agents map[string]*Agent
-	config *Config
-	lock   sync.Mutex
-	client *http.Client
+	// tokenClients are clients of agents sending an ACL token with every request
+	tokenClients map[tokenClientKey]*consulapi.Client
+	config       *Config
+	lock         sync.Mutex
+	client       *http.Client
+}
+
+type tokenClientKey struct {
+	ipAddress string
+	token     string
 }
 
 func NewAgents(config *Config) *ConcurrentAgents {
@@ -37,9 +46,10 @@ func NewAgents(config *Config) *ConcurrentAgents {
 		Timeout: config.Timeout.Duration,
 	}
 	return &ConcurrentAgents{
-		agents: make(map[string]*Agent),
-		config: config,
-		client: client,
+		agents:       make(map[string]*Agent),
+		tokenClients: make(map[tokenClientKey]*consulapi.Client),
+		config:       config,
+		client:       client,
 	}
 }
 
@@ -73,6 +83,11 @@ func (a *ConcurrentAgents) RemoveAgent(agentAddress string) {
 		ipAddress := IP.String()
 		log.WithField("Address", ipAddress).Info("Removing agent from cache")
 		delete(a.agents, ipAddress)
+		for key := range a.tokenClients {
+			if key.ipAddress == ipAddress {
+				delete(a.tokenClients, key)
+			}
+		}
 		a.updateAgentsCacheSizeMetricValue()
 	}
 }
@@ -108,3 +123,53 @@ func (a *ConcurrentAgents) addAgent(agentHost string, agent *Agent) {
 func (a *ConcurrentAgents) updateAgentsCacheSizeMetricValue() {
 	metrics.UpdateGauge("consul.agents.cache.size", int64(len(a.agents)))
 }
+
+// GetAgentWithToken returns a client of the agent sending the ACL token with every request. It is used for
+// requests taking no per-request options, other requests pass the token with a client returned by GetAgent.
+// Clients are cached per agent and token until the agent is removed or credentials are updated.
+func (a *ConcurrentAgents) GetAgentWithToken(agentAddress string, token string) (*consulapi.Client, error) {
+	agent, err := a.GetAgent(agentAddress)
+	if err != nil || token == "" {
+		return agent, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	IP, err := utils.HostToIPv4(agentAddress)
+	if err != nil {
+		return nil, err
+	}
+	key := tokenClientKey{ipAddress: IP.String(), token: token}
+	if client, ok := a.tokenClients[key]; ok {
+		return client, nil
+	}
+	client, err := a.newConsulClient(key.ipAddress, token)
+	if err != nil {
+		return nil, err
+	}
+	a.tokenClients[key] = client
+	return client, nil
+}
+
+// UpdateAuth makes agents use the basic authentication credentials,
+// clients of cached agents are created again with them and clients with tokens are dropped
+func (a *ConcurrentAgents) UpdateAuth(auth Auth) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.config.Auth = auth
+	// clients with tokens are created again when needed, which also drops the ones of tokens no longer used
+	a.tokenClients = make(map[tokenClientKey]*consulapi.Client)
+	var errs []error
+	for ipAddress := range a.agents {
+		// agents are replaced rather than modified as they are used outside the lock
+		agent, err := a.createAgent(ipAddress)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		a.agents[ipAddress] = agent
+	}
+	log.WithField("Agents", len(a.agents)).Info("Consul credentials updated")
+	return utils.MergeErrorsOrNil(errs, "updating credentials of Consul agents")
+}
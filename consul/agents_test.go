@@ -133,3 +133,63 @@ func TestRemoveAgentTwiceShouldPass(t *testing.T) {
 	// then
 	assert.Empty(t, agents.agents)
 }
+
+func TestUpdateAuth_RecreatesCachedAgents(t *testing.T) {
+	t.Parallel()
+	// given
+	agents := NewAgents(&Config{})
+	oldClient, _ := agents.GetAgent("127.0.0.1")
+
+	// when
+	err := agents.UpdateAuth(Auth{Enabled: true, Username: "user", Password: "secret"})
+
+	// then
+	assert.NoError(t, err)
+	newClient, err := agents.GetAgent("127.0.0.1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldClient, newClient)
+	assert.Len(t, agents.agents, 1)
+	assert.Equal(t, "user", agents.config.Auth.Username)
+}
+
+func TestGetAgentWithToken_CachesClientsPerToken(t *testing.T) {
+	t.Parallel()
+	// given
+	agents := NewAgents(&Config{})
+	cached, _ := agents.GetAgent("127.0.0.1")
+
+	// when
+	withToken, err := agents.GetAgentWithToken("127.0.0.1", "token")
+	withTokenAgain, _ := agents.GetAgentWithToken("127.0.0.1", "token")
+	withOtherToken, _ := agents.GetAgentWithToken("127.0.0.1", "other")
+	withoutToken, _ := agents.GetAgentWithToken("127.0.0.1", "")
+
+	// then
+	assert.NoError(t, err)
+	assert.False(t, cached == withToken)
+	assert.True(t, withToken == withTokenAgain)
+	assert.False(t, withToken == withOtherToken)
+	assert.True(t, cached == withoutToken)
+	assert.Len(t, agents.agents, 1)
+	assert.Len(t, agents.tokenClients, 2)
+}
+
+func TestGetAgentWithToken_ClientsAreDroppedWithAgentAndOnAuthUpdate(t *testing.T) {
+	t.Parallel()
+	// given
+	agents := NewAgents(&Config{})
+	withToken, _ := agents.GetAgentWithToken("127.0.0.1", "token")
+
+	// when
+	agents.UpdateAuth(Auth{Enabled: true, Username: "user", Password: "secret"})
+	afterUpdate, _ := agents.GetAgentWithToken("127.0.0.1", "token")
+
+	// then
+	assert.False(t, withToken == afterUpdate)
+
+	// when
+	agents.RemoveAgent("127.0.0.1")
+
+	// then
+	assert.Empty(t, agents.tokenClients)
+}
@@ -10,16 +10,23 @@ type Config struct {
 	SslCert                string
 	SslCaCert              string
 	Token                  string
+	TokenFile              string
+	TokenVault             string
+	Tokens                 map[string]string
+	TokensVault            string
 	Tag                    string
 	Timeout                time.Interval
 	RequestRetries         uint32
 	AgentFailuresTolerance uint32
 	ConsulNameSeparator    string
+	ConsulTagLabelPrefix   string
 	IgnoredHealthChecks    string
 }
 
 type Auth struct {
-	Enabled  bool
-	Username string
-	Password string
+	Enabled       bool
+	Username      string
+	Password      string
+	PasswordFile  string
+	PasswordVault string
 }
@@ -17,7 +17,8 @@ import (
 type Consul struct {
 	agents                  Agents
 	config                  Config
-	ignoredHealthCheckTypes []string
+	ignoredHealthCheckTypes *healthCheckTypes
+	tokens                  *tokens
 }
 
 type ServicesProvider func(agent *consulapi.Client) ([]*service.Service, error)
@@ -26,7 +27,8 @@ func New(config Config) *Consul {
 	return &Consul{
 		agents:                  NewAgents(&config),
 		config:                  config,
-		ignoredHealthCheckTypes: ignoredHealthCheckTypesFromRawConfigEntry(config.IgnoredHealthChecks),
+		ignoredHealthCheckTypes: newHealthCheckTypes(config.IgnoredHealthChecks),
+		tokens:                  newTokens(config),
 	}
 }
 
@@ -59,23 +61,52 @@ func (c *Consul) getServicesUsingProviderWithRetriesOnAgentFailure(provide Servi
 }
 
 func (c *Consul) getServicesUsingAgent(name string, agent *consulapi.Client) ([]*service.Service, error) {
-	dcAwareQueries, err := dcAwareQueriesForAllDCs(agent)
-	if err != nil {
-		return nil, err
-	}
-	var allServices []*service.Service
+	return c.readWithAllTokens(func(token string) ([]*service.Service, error) {
+		dcAwareQueries, err := dcAwareQueriesForAllDCs(agent, token)
+		if err != nil {
+			return nil, err
+		}
+		var allServices []*service.Service
 
-	for _, dcAwareQuery := range dcAwareQueries {
-		allConsulServices, _, err := agent.Catalog().Service(name, c.config.Tag, dcAwareQuery)
+		for _, dcAwareQuery := range dcAwareQueries {
+			consulServiceEntries, _, err := agent.Health().Service(name, c.config.Tag, false, dcAwareQuery)
+			if err != nil {
+				return nil, err
+			}
+			allServices = append(allServices, consulServiceEntriesToServices(consulServiceEntries)...)
+		}
+		return allServices, nil
+	})
+}
+
+// readWithAllTokens reads services with tokens of all aliases and the default token, as per-app ACLs may hide
+// services from the default token. Services are attributed to the first token they are read with, unless
+// the token they were registered with is known already.
+func (c *Consul) readWithAllTokens(read func(token string) ([]*service.Service, error)) ([]*service.Service, error) {
+	var allServices []*service.Service
+	seen := make(map[service.ServiceId]struct{})
+	for _, alias := range c.tokens.readAliases() {
+		token, ok := c.tokens.get(alias)
+		if !ok {
+			continue
+		}
+		services, err := read(token)
 		if err != nil {
 			return nil, err
 		}
-		allServices = append(allServices, consulServicesToServices(allConsulServices)...)
+		for _, s := range services {
+			if _, ok := seen[s.ID]; ok {
+				continue
+			}
+			seen[s.ID] = struct{}{}
+			c.tokens.learn(s.ID, alias)
+			allServices = append(allServices, s)
+		}
 	}
 	return allServices, nil
 }
 
-func dcAwareQueriesForAllDCs(agent *consulapi.Client) ([]*consulapi.QueryOptions, error) {
+func dcAwareQueriesForAllDCs(agent *consulapi.Client, token string) ([]*consulapi.QueryOptions, error) {
 	datacenters, err := agent.Catalog().Datacenters()
 	if err != nil {
 		return nil, err
@@ -85,6 +116,7 @@ func dcAwareQueriesForAllDCs(agent *consulapi.Client) ([]*consulapi.QueryOptions
 	for _, dc := range datacenters {
 		queries = append(queries, &consulapi.QueryOptions{
 			Datacenter: dc,
+			Token:      token,
 		})
 	}
 
@@ -96,28 +128,31 @@ func (c *Consul) GetAllServices() ([]*service.Service, error) {
 }
 
 func (c *Consul) getAllServices(agent *consulapi.Client) ([]*service.Service, error) {
-	dcAwareQueries, err := dcAwareQueriesForAllDCs(agent)
-	if err != nil {
-		return nil, err
-	}
-	var allInstances []*service.Service
-
-	for _, dcAwareQuery := range dcAwareQueries {
-		consulServices, _, err := agent.Catalog().Services(dcAwareQuery)
+	return c.readWithAllTokens(func(token string) ([]*service.Service, error) {
+		dcAwareQueries, err := dcAwareQueriesForAllDCs(agent, token)
 		if err != nil {
 			return nil, err
 		}
-		for consulService, tags := range consulServices {
-			if contains(tags, c.config.Tag) {
-				consulServiceInstances, _, err := agent.Catalog().Service(consulService, c.config.Tag, dcAwareQuery)
-				if err != nil {
-					return nil, err
+		var allInstances []*service.Service
+
+		for _, dcAwareQuery := range dcAwareQueries {
+			consulServices, _, err := agent.Catalog().Services(dcAwareQuery)
+			if err != nil {
+				return nil, err
+			}
+			for consulService, tags := range consulServices {
+				if contains(tags, c.config.Tag) {
+					// health endpoint is used instead of the catalog one, as it also returns checks of the services
+					consulServiceEntries, _, err := agent.Health().Service(consulService, c.config.Tag, false, dcAwareQuery)
+					if err != nil {
+						return nil, err
+					}
+					allInstances = append(allInstances, consulServiceEntriesToServices(consulServiceEntries)...)
 				}
-				allInstances = append(allInstances, consulServicesToServices(consulServiceInstances)...)
 			}
 		}
-	}
-	return allInstances, nil
+		return allInstances, nil
+	})
 }
 
 func consulServiceToService(consulService *consulapi.CatalogService) *service.Service {
@@ -126,17 +161,58 @@ func consulServiceToService(consulService *consulapi.CatalogService) *service.Se
 		Name: consulService.ServiceName,
 		Tags: consulService.ServiceTags,
 		RegisteringAgentAddress: consulService.Address,
+		Address:                 consulService.ServiceAddress,
+		Port:                    consulService.ServicePort,
 	}
 }
 
 func consulServicesToServices(consulServices []*consulapi.CatalogService) []*service.Service {
 	var allServices []*service.Service
 	for _, c := range consulServices {
+		if isSidecarProxy(c.ServiceID) {
+			continue
+		}
 		allServices = append(allServices, consulServiceToService(c))
 	}
 	return allServices
 }
 
+func consulServiceEntriesToServices(entries []*consulapi.ServiceEntry) []*service.Service {
+	var allServices []*service.Service
+	for _, entry := range entries {
+		if entry.Service == nil || isSidecarProxy(entry.Service.ID) {
+			continue
+		}
+		s := &service.Service{
+			ID:      service.ServiceId(entry.Service.ID),
+			Name:    entry.Service.Service,
+			Tags:    entry.Service.Tags,
+			Address: entry.Service.Address,
+			Port:    entry.Service.Port,
+		}
+		if entry.Node != nil {
+			s.RegisteringAgentAddress = entry.Node.Address
+		}
+		for _, check := range entry.Checks {
+			// node checks, like serfHealth, are returned along with service checks,
+			// maintenance checks are managed by the agent, not registered with the service
+			if check.ServiceID != entry.Service.ID {
+				continue
+			}
+			if strings.HasPrefix(check.CheckID, serviceMaintenanceCheckPrefix) {
+				s.MaintenanceReason = check.Notes
+				if s.MaintenanceReason == "" {
+					s.MaintenanceReason = defaultMaintenanceReason
+				}
+			} else {
+				s.Checks++
+			}
+		}
+		allServices = append(allServices, s)
+	}
+	return allServices
+}
+
 func contains(slice []string, search string) bool {
 	for _, element := range slice {
 		if element == search {
@@ -163,7 +239,7 @@ func (c *Consul) Register(task *apps.Task, app *apps.App) error {
 	return err
 }
 
-func (c *Consul) registerMultipleServices(services []*consulapi.AgentServiceRegistration) error {
+func (c *Consul) registerMultipleServices(services []*ServiceRegistration) error {
 	var registerErrors []error
 	for _, s := range services {
 		registerErr := c.register(s)
@@ -175,7 +251,12 @@ func (c *Consul) registerMultipleServices(services []*consulapi.AgentServiceRegi
 	return utils.MergeErrorsOrNil(registerErrors, fmt.Sprint("registering services"))
 }
 
-func (c *Consul) register(service *consulapi.AgentServiceRegistration) error {
+func (c *Consul) register(service *ServiceRegistration) error {
+	token, ok := c.tokens.get(service.tokenAlias)
+	if !ok {
+		metrics.Mark("consul.token.unknown")
+		return fmt.Errorf("Unknown Consul token alias %q of service %s", service.tokenAlias, service.ID)
+	}
 	agent, err := c.agents.GetAgent(service.Address)
 	if err != nil {
 		return err
@@ -187,13 +268,18 @@ func (c *Consul) register(service *consulapi.AgentServiceRegistration) error {
 		"Address": service.Address,
 		"Port":    service.Port,
 	}
+	if service.Connect != nil {
+		fields["Connect"] = true
+	}
 	log.WithFields(fields).Info("Registering")
 
-	err = agent.Agent().ServiceRegister(service)
+	_, err = agent.Raw().Write("/v1/agent/service/register", service, nil, &consulapi.WriteOptions{Token: token})
 	if err != nil {
 		log.WithError(err).WithFields(fields).Error("Unable to register")
+		return err
 	}
-	return err
+	c.tokens.remember(service.ID, service.tokenAlias)
+	return nil
 }
 
 func (c *Consul) DeregisterByTask(taskID apps.TaskID) error {
@@ -220,29 +306,31 @@ func (c *Consul) deregisterMultipleServices(services []*service.Service, taskID
 
 func (c *Consul) findServicesByTaskID(searchedTaskID apps.TaskID) ([]*service.Service, error) {
 	return c.getServicesUsingProviderWithRetriesOnAgentFailure(func(agent *consulapi.Client) ([]*service.Service, error) {
-		dcAwareQueries, err := dcAwareQueriesForAllDCs(agent)
-		if err != nil {
-			return nil, err
-		}
-
-		var allFound []*service.Service
-		searchedTag := service.MarathonTaskTag(searchedTaskID)
-		for _, dcAwareQuery := range dcAwareQueries {
-			consulServices, _, err := agent.Catalog().Services(dcAwareQuery)
+		return c.readWithAllTokens(func(token string) ([]*service.Service, error) {
+			dcAwareQueries, err := dcAwareQueriesForAllDCs(agent, token)
 			if err != nil {
 				return nil, err
 			}
-			for consulService, tags := range consulServices {
-				if contains(tags, searchedTag) {
-					instancesForTask, _, err := agent.Catalog().Service(consulService, searchedTag, dcAwareQuery)
-					if err != nil {
-						return nil, err
+
+			var allFound []*service.Service
+			searchedTag := service.MarathonTaskTag(searchedTaskID)
+			for _, dcAwareQuery := range dcAwareQueries {
+				consulServices, _, err := agent.Catalog().Services(dcAwareQuery)
+				if err != nil {
+					return nil, err
+				}
+				for consulService, tags := range consulServices {
+					if contains(tags, searchedTag) {
+						instancesForTask, _, err := agent.Catalog().Service(consulService, searchedTag, dcAwareQuery)
+						if err != nil {
+							return nil, err
+						}
+						allFound = append(allFound, consulServicesToServices(instancesForTask)...)
 					}
-					allFound = append(allFound, consulServicesToServices(instancesForTask)...)
 				}
 			}
-		}
-		return allFound, nil
+			return allFound, nil
+		})
 	})
 }
 
@@ -265,14 +353,92 @@ func (c *Consul) deregister(toDeregister *service.Service) error {
 
 	log.WithField("Id", toDeregister.ID).WithField("Address", toDeregister.RegisteringAgentAddress).Info("Deregistering")
 
-	err = agent.Agent().ServiceDeregister(toDeregister.ID.String())
+	token, err := c.tokenOf(toDeregister)
+	if err != nil {
+		return err
+	}
+	_, err = agent.Raw().Write("/v1/agent/service/deregister/"+toDeregister.ID.String(), nil, nil, &consulapi.WriteOptions{Token: token})
 	if err != nil {
 		log.WithError(err).WithField("Id", toDeregister.ID).WithField("Address", toDeregister.RegisteringAgentAddress).Error("Unable to deregister")
+		return err
+	}
+	c.tokens.forget(toDeregister.ID)
+	return nil
+}
+
+// tokenOf returns the token the service was registered with
+func (c *Consul) tokenOf(registered *service.Service) (string, error) {
+	token, err := c.tokens.of(registered.ID)
+	if err != nil {
+		metrics.Mark("consul.token.unknown")
+		log.WithError(err).WithField("Id", registered.ID).Error("Unable to determine Consul token of service")
+	}
+	return token, err
+}
+
+func (c *Consul) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	var err error
+	metrics.Time("consul.maintenance.enable", func() { err = c.maintenance(toMaintain, true, reason) })
+	if err != nil {
+		metrics.Mark("consul.maintenance.enable.error")
+	} else {
+		metrics.Mark("consul.maintenance.enable.success")
 	}
 	return err
 }
 
-func (c *Consul) marathonTaskToConsulServices(task *apps.Task, app *apps.App) ([]*consulapi.AgentServiceRegistration, error) {
+func (c *Consul) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	services, err := c.findServicesByTaskID(taskID)
+	if err != nil {
+		return err
+	} else if len(services) == 0 {
+		return fmt.Errorf("Couldn't find any service matching task id %s", taskID)
+	}
+	var maintenanceErrors []error
+	for _, s := range services {
+		if err := c.EnableMaintenance(s, reason); err != nil {
+			maintenanceErrors = append(maintenanceErrors, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(maintenanceErrors, fmt.Sprintf("enabling maintenance by task %s", taskID))
+}
+
+func (c *Consul) DisableMaintenance(toMaintain *service.Service) error {
+	var err error
+	metrics.Time("consul.maintenance.disable", func() { err = c.maintenance(toMaintain, false, "") })
+	if err != nil {
+		metrics.Mark("consul.maintenance.disable.error")
+	} else {
+		metrics.Mark("consul.maintenance.disable.success")
+	}
+	return err
+}
+
+func (c *Consul) maintenance(toMaintain *service.Service, enable bool, reason string) error {
+	// maintenance requests of the Consul API take no per-request options, so a client with the token is used
+	token, err := c.tokenOf(toMaintain)
+	if err != nil {
+		return err
+	}
+	agent, err := c.agents.GetAgentWithToken(toMaintain.RegisteringAgentAddress, token)
+	if err != nil {
+		return err
+	}
+
+	fields := log.Fields{"Id": toMaintain.ID, "Address": toMaintain.RegisteringAgentAddress, "Enable": enable}
+	log.WithFields(fields).Info("Changing maintenance mode")
+	if enable {
+		err = agent.Agent().EnableServiceMaintenance(toMaintain.ID.String(), reason)
+	} else {
+		err = agent.Agent().DisableServiceMaintenance(toMaintain.ID.String())
+	}
+	if err != nil {
+		log.WithError(err).WithFields(fields).Error("Unable to change maintenance mode")
+	}
+	return err
+}
+
+func (c *Consul) marathonTaskToConsulServices(task *apps.Task, app *apps.App) ([]*ServiceRegistration, error) {
 	IP, err := utils.HostToIPv4(task.Host)
 	if err != nil {
 		return nil, err
@@ -280,30 +446,63 @@ func (c *Consul) marathonTaskToConsulServices(task *apps.Task, app *apps.App) ([
 	serviceAddress := IP.String()
 	checks := c.marathonToConsulChecks(task, app.HealthChecks, serviceAddress)
 
-	var registrations []*consulapi.AgentServiceRegistration
-	for _, intent := range app.RegistrationIntents(task, c.config.ConsulNameSeparator) {
+	var registrations []*ServiceRegistration
+	for _, intent := range app.RegistrationIntents(task, c.config.ConsulNameSeparator, c.config.ConsulTagLabelPrefix) {
 		tags := append([]string{c.config.Tag}, intent.Tags...)
 		tags = append(tags, service.MarathonTaskTag(task.ID))
-		registrations = append(registrations, &consulapi.AgentServiceRegistration{
-			ID:      c.serviceID(task, intent.Name, intent.Port),
-			Name:    intent.Name,
-			Port:    intent.Port,
-			Address: serviceAddress,
-			Tags:    tags,
-			Checks:  checks,
+		alias := app.ConsulTokenAlias()
+		registrations = append(registrations, &ServiceRegistration{
+			AgentServiceRegistration: consulapi.AgentServiceRegistration{
+				ID:                c.serviceID(task, intent.Name, intent.Port),
+				Name:              intent.Name,
+				Port:              intent.Port,
+				Address:           serviceAddress,
+				Tags:              tags,
+				EnableTagOverride: intent.Options.EnableTagOverride,
+			},
+			Checks:     serviceChecks(checks, intent.Options.Check),
+			Weights:    serviceWeights(intent.Options.Weights),
+			Connect:    connectRegistration(intent.Connect),
+			tokenAlias: alias,
 		})
 	}
 	return registrations, nil
 }
 
+func (c *Consul) ExpectedServices(task *apps.Task, app *apps.App) ([]*service.Service, error) {
+	registrations, err := c.marathonTaskToConsulServices(task, app)
+	if err != nil {
+		return nil, err
+	}
+	return registrationsToServices(registrations), nil
+}
+
+func registrationsToServices(registrations []*ServiceRegistration) []*service.Service {
+	var services []*service.Service
+	for _, r := range registrations {
+		services = append(services, &service.Service{
+			ID:                      service.ServiceId(r.ID),
+			Name:                    r.Name,
+			Tags:                    r.Tags,
+			RegisteringAgentAddress: r.Address,
+			Address:                 r.Address,
+			Port:                    r.Port,
+			Checks:                  len(r.Checks),
+			EnableTagOverride:       r.EnableTagOverride,
+		})
+	}
+	return services
+}
+
 func (c *Consul) serviceID(task *apps.Task, name string, port int) string {
 	return fmt.Sprintf("%s_%s_%d", task.ID, name, port)
 }
 
 func (c *Consul) marathonToConsulChecks(task *apps.Task, healthChecks []apps.HealthCheck, serviceAddress string) consulapi.AgentServiceChecks {
 	var checks = make(consulapi.AgentServiceChecks, 0, len(healthChecks))
+	ignoredTypes := c.ignoredHealthCheckTypes.get()
 	for _, check := range healthChecks {
-		if contains(c.ignoredHealthCheckTypes, check.Protocol) {
+		if contains(ignoredTypes, check.Protocol) {
 			log.WithField("Id", task.AppID.String()).WithField("Address", serviceAddress).
 				Info(fmt.Sprintf("Ignoring health check of type %s", check.Protocol))
 			continue
@@ -398,3 +597,24 @@ func (c *Consul) AddAgent(agentAddress string) error {
 	_, err := c.agents.GetAgent(agentAddress)
 	return err
 }
+
+// WithTag returns Consul registering services with the tag, sharing the agents cache and ignored health checks with c.
+// It is used to register services of multiple Marathon clusters, each with its own tag.
+func (c *Consul) WithTag(tag string) *Consul {
+	config := c.config
+	config.Tag = tag
+	return &Consul{
+		agents:                  c.agents,
+		config:                  config,
+		ignoredHealthCheckTypes: c.ignoredHealthCheckTypes,
+		tokens:                  c.tokens,
+	}
+}
+
+// Reconfigure applies options that can change while running: ignored health check types, ACL tokens
+// and basic authentication credentials. Consul instances returned by WithTag share them.
+func (c *Consul) Reconfigure(config Config) error {
+	c.ignoredHealthCheckTypes.set(ignoredHealthCheckTypesFromRawConfigEntry(config.IgnoredHealthChecks))
+	c.tokens.set(config)
+	return c.agents.UpdateAuth(config.Auth)
+}
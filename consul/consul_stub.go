@@ -12,11 +12,12 @@ import (
 // TODO this should be a service registry stub in the service package, requires abstracting from AgentServiceRegistration
 type Stub struct {
 	sync.RWMutex
-	services                   map[service.ServiceId]*consulapi.AgentServiceRegistration
+	services                   map[service.ServiceId]*ServiceRegistration
 	failGetServicesForNames    map[string]bool
 	failRegisterForIDs         map[apps.TaskID]bool
 	failDeregisterByTaskForIDs map[apps.TaskID]bool
 	failDeregisterForIDs       map[service.ServiceId]bool
+	maintenance                map[service.ServiceId]string
 	consul                     *Consul
 }
 
@@ -26,28 +27,28 @@ func NewConsulStub() *Stub {
 
 func NewConsulStubWithTag(tag string) *Stub {
 	return &Stub{
-		services:                   make(map[service.ServiceId]*consulapi.AgentServiceRegistration),
+		services:                   make(map[service.ServiceId]*ServiceRegistration),
 		failGetServicesForNames:    make(map[string]bool),
 		failRegisterForIDs:         make(map[apps.TaskID]bool),
 		failDeregisterByTaskForIDs: make(map[apps.TaskID]bool),
 		failDeregisterForIDs:       make(map[service.ServiceId]bool),
-		consul:                     New(Config{Tag: tag, ConsulNameSeparator: "."}),
+		maintenance:                make(map[service.ServiceId]string),
+		consul:                     New(Config{Tag: tag, ConsulNameSeparator: ".", ConsulTagLabelPrefix: "consul-tag-"}),
 	}
 }
 
 func (c *Stub) GetAllServices() ([]*service.Service, error) {
 	c.RLock()
 	defer c.RUnlock()
-	var allServices []*service.Service
+	var allServices []*ServiceRegistration
 	for _, s := range c.services {
-		allServices = append(allServices, &service.Service{
-			ID:   service.ServiceId(s.ID),
-			Name: s.Name,
-			Tags: s.Tags,
-			RegisteringAgentAddress: s.Address,
-		})
+		allServices = append(allServices, s)
 	}
-	return allServices, nil
+	services := registrationsToServices(allServices)
+	for _, s := range services {
+		s.MaintenanceReason = c.maintenance[s.ID]
+	}
+	return services, nil
 }
 
 func (c *Stub) FailGetServicesForName(failOnName string) {
@@ -80,6 +81,7 @@ func (c *Stub) GetServices(name string) ([]*service.Service, error) {
 				Name: s.Name,
 				Tags: s.Tags,
 				RegisteringAgentAddress: s.Address,
+				MaintenanceReason:       c.maintenance[service.ServiceId(s.ID)],
 			})
 		}
 	}
@@ -102,17 +104,39 @@ func (c *Stub) Register(task *apps.Task, app *apps.App) error {
 	return nil
 }
 
+func (c *Stub) ExpectedServices(task *apps.Task, app *apps.App) ([]*service.Service, error) {
+	return c.consul.ExpectedServices(task, app)
+}
+
+// Overwrite replaces registrations with given ones, simulating registrations drifted from the expected ones
+func (c *Stub) Overwrite(registrations ...*ServiceRegistration) {
+	c.Lock()
+	defer c.Unlock()
+	for _, r := range registrations {
+		c.services[service.ServiceId(r.ID)] = r
+	}
+}
+
+// Registration returns registration of given id, nil when not registered
+func (c *Stub) Registration(id service.ServiceId) *ServiceRegistration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.services[id]
+}
+
 func (c *Stub) RegisterWithoutMarathonTaskTag(task *apps.Task, app *apps.App) {
 	c.Lock()
 	defer c.Unlock()
-	for _, intent := range app.RegistrationIntents(task, c.consul.config.ConsulNameSeparator) {
-		serviceRegistration := consulapi.AgentServiceRegistration{
-			ID:      task.ID.String(),
-			Name:    intent.Name,
-			Port:    intent.Port,
-			Address: task.Host,
-			Tags:    intent.Tags,
-			Checks:  consulapi.AgentServiceChecks{},
+	for _, intent := range app.RegistrationIntents(task, c.consul.config.ConsulNameSeparator, c.consul.config.ConsulTagLabelPrefix) {
+		serviceRegistration := ServiceRegistration{
+			AgentServiceRegistration: consulapi.AgentServiceRegistration{
+				ID:      task.ID.String(),
+				Name:    intent.Name,
+				Port:    intent.Port,
+				Address: task.Host,
+				Tags:    intent.Tags,
+			},
+			Checks: ServiceChecks{},
 		}
 		c.services[service.ServiceId(serviceRegistration.ID)] = &serviceRegistration
 	}
@@ -144,11 +168,48 @@ func (c *Stub) Deregister(toDeregister *service.Service) error {
 		return fmt.Errorf("Consul stub programmed to fail when deregistering service of id %s", toDeregister.ID)
 	}
 	delete(c.services, toDeregister.ID)
+	delete(c.maintenance, toDeregister.ID)
 	return nil
 }
 
-func (c *Stub) servicesMatchingTask(taskID apps.TaskID) []*consulapi.AgentServiceRegistration {
-	matching := []*consulapi.AgentServiceRegistration{}
+func (c *Stub) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.services[toMaintain.ID]; !ok {
+		return fmt.Errorf("Consul stub has no service of id %s", toMaintain.ID)
+	}
+	c.maintenance[toMaintain.ID] = reason
+	return nil
+}
+
+func (c *Stub) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	c.Lock()
+	defer c.Unlock()
+	matching := c.servicesMatchingTask(taskID)
+	if len(matching) == 0 {
+		return fmt.Errorf("Consul stub has no service matching task id %s", taskID)
+	}
+	for _, s := range matching {
+		c.maintenance[service.ServiceId(s.ID)] = reason
+	}
+	return nil
+}
+
+func (c *Stub) DisableMaintenance(toMaintain *service.Service) error {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.maintenance, toMaintain.ID)
+	return nil
+}
+
+func (c *Stub) MaintenanceReason(id service.ServiceId) string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.maintenance[id]
+}
+
+func (c *Stub) servicesMatchingTask(taskID apps.TaskID) []*ServiceRegistration {
+	matching := []*ServiceRegistration{}
 	for _, s := range c.services {
 		if s.ID == taskID.String() || contains(s.Tags, fmt.Sprintf("marathon-task:%s", taskID.String())) {
 			matching = append(matching, s)
@@ -693,7 +693,7 @@ func TestGetIgnoredHealthCheckTypes(t *testing.T) {
 		consul := New(Config{IgnoredHealthChecks: types.config})
 
 		// then
-		assert.Equal(t, types.parsed, consul.ignoredHealthCheckTypes)
+		assert.Equal(t, types.parsed, consul.ignoredHealthCheckTypes.get())
 	}
 }
 
@@ -785,37 +785,37 @@ func TestMarathonTaskToConsulServiceMapping(t *testing.T) {
 	assert.Nil(t, service.Check)
 	assert.Equal(t, 5, len(service.Checks))
 
-	assert.Equal(t, consulapi.AgentServiceChecks{
-		{
+	assert.Equal(t, ServiceChecks{
+		{AgentServiceCheck: consulapi.AgentServiceCheck{
 			HTTP:     "http://127.0.0.6:8123/api/health?with=query",
 			Interval: "60s",
 			Timeout:  "20s",
 			Status:   "passing",
-		},
-		{
+		}},
+		{AgentServiceCheck: consulapi.AgentServiceCheck{
 			HTTP:     "https://127.0.0.6:8090/secure/health?with=query",
 			Interval: "50s",
 			Timeout:  "20s",
 			Status:   "passing",
-		},
-		{
+		}},
+		{AgentServiceCheck: consulapi.AgentServiceCheck{
 			TCP:      "127.0.0.6:8443",
 			Interval: "40s",
 			Timeout:  "20s",
 			Status:   "passing",
-		},
-		{
+		}},
+		{AgentServiceCheck: consulapi.AgentServiceCheck{
 			TCP:      "127.0.0.6:8234",
 			Interval: "40s",
 			Timeout:  "20s",
 			Status:   "passing",
-		},
-		{
+		}},
+		{AgentServiceCheck: consulapi.AgentServiceCheck{
 			Script:   "echo 1",
 			Interval: "30s",
 			Timeout:  "20s",
 			Status:   "passing",
-		},
+		}},
 	}, service.Checks)
 }
 
@@ -924,19 +924,19 @@ func TestMarathonTaskToConsulServiceMapping_IgnoredHealthcheckTypes(t *testing.T
 	assert.Nil(t, service.Check)
 	assert.Equal(t, 2, len(service.Checks))
 
-	assert.Equal(t, consulapi.AgentServiceChecks{
-		{
+	assert.Equal(t, ServiceChecks{
+		{AgentServiceCheck: consulapi.AgentServiceCheck{
 			HTTP:     "http://127.0.0.6:8090/api/health?with=query",
 			Interval: "60s",
 			Timeout:  "20s",
 			Status:   "passing",
-		},
-		{
+		}},
+		{AgentServiceCheck: consulapi.AgentServiceCheck{
 			HTTP:     "https://127.0.0.6:8090/secure/health?with=query",
 			Interval: "50s",
 			Timeout:  "20s",
 			Status:   "passing",
-		},
+		}},
 	}, service.Checks)
 }
 
@@ -975,3 +975,43 @@ func TestMarathonTaskToConsulServiceMapping_NotResolvableTaskHost(t *testing.T)
 	// then
 	assert.Error(t, err)
 }
+
+func TestWithTag_SharesAgents(t *testing.T) {
+	t.Parallel()
+
+	// given
+	consul := New(Config{Tag: "marathon"})
+	other := consul.WithTag("other-marathon")
+
+	// when
+	err := other.AddAgent("127.0.0.1")
+
+	// then
+	assert.NoError(t, err)
+	agent, err := consul.agents.GetAnyAgent()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", agent.IP)
+	assert.Equal(t, "marathon", consul.config.Tag)
+	assert.Equal(t, "other-marathon", other.config.Tag)
+}
+
+func TestReconfigure_SharedWithTaggedInstances(t *testing.T) {
+	t.Parallel()
+
+	// given
+	consul := New(Config{Tag: "marathon", IgnoredHealthChecks: "tcp"})
+	other := consul.WithTag("other-marathon")
+
+	// when
+	err := consul.Reconfigure(Config{IgnoredHealthChecks: "http, command", Token: "token",
+		Tokens: map[string]string{"payments": "payments-token"}})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HTTP", "COMMAND"}, other.ignoredHealthCheckTypes.get())
+	assert.Equal(t, "other-marathon", other.config.Tag)
+	assert.Equal(t, "token", other.tokens.getDefault())
+	token, ok := other.tokens.get("payments")
+	assert.True(t, ok)
+	assert.Equal(t, "payments-token", token)
+}
@@ -0,0 +1,26 @@
+package consul
+
+import "sync"
+
+// healthCheckTypes holds types of Marathon health checks not registered in Consul,
+// they are shared by Consul instances of all Marathon clusters and can change while running
+type healthCheckTypes struct {
+	lock  sync.RWMutex
+	types []string
+}
+
+func newHealthCheckTypes(raw string) *healthCheckTypes {
+	return &healthCheckTypes{types: ignoredHealthCheckTypesFromRawConfigEntry(raw)}
+}
+
+func (h *healthCheckTypes) get() []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.types
+}
+
+func (h *healthCheckTypes) set(types []string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.types = types
+}
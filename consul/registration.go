@@ -0,0 +1,110 @@
+package consul
+
+import (
+	"strings"
+
+	"github.com/allegro/marathon-consul/apps"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+const sidecarProxySuffix = "-sidecar-proxy"
+
+const serviceMaintenanceCheckPrefix = "_service_maintenance:"
+
+// Consul uses this reason when maintenance is enabled without one
+const defaultMaintenanceReason = "Maintenance mode is enabled for this service, but no reason was provided. This is a default message."
+
+// ServiceRegistration extends AgentServiceRegistration with fields supported by Consul agents
+// but not yet known to the vendored API client. It is sent to the agent as a raw request.
+type ServiceRegistration struct {
+	consulapi.AgentServiceRegistration
+	Checks  ServiceChecks
+	Weights *ServiceWeights `json:",omitempty"`
+	Connect *ServiceConnect `json:",omitempty"`
+	// alias of the ACL token the service is registered with, empty for the default token
+	tokenAlias string
+}
+
+// ServiceWeights mirrors AgentWeights of the Consul API, see https://www.consul.io/api/agent/service.html#weights
+type ServiceWeights struct {
+	Passing int
+	Warning int
+}
+
+// ServiceCheck extends AgentServiceCheck with custom HTTP headers
+type ServiceCheck struct {
+	consulapi.AgentServiceCheck
+	Header map[string][]string `json:",omitempty"`
+}
+
+type ServiceChecks []*ServiceCheck
+
+func serviceChecks(checks consulapi.AgentServiceChecks, options apps.CheckOptions) ServiceChecks {
+	serviceChecks := make(ServiceChecks, 0, len(checks))
+	for _, check := range checks {
+		serviceCheck := &ServiceCheck{AgentServiceCheck: *check}
+		serviceCheck.DeregisterCriticalServiceAfter = options.DeregisterCriticalServiceAfter
+		serviceCheck.Notes = options.Notes
+		if check.HTTP != "" {
+			serviceCheck.TLSSkipVerify = options.TLSSkipVerify
+			serviceCheck.Header = options.Header
+		}
+		serviceChecks = append(serviceChecks, serviceCheck)
+	}
+	return serviceChecks
+}
+
+func serviceWeights(weights *apps.Weights) *ServiceWeights {
+	if weights == nil {
+		return nil
+	}
+	return &ServiceWeights{Passing: weights.Passing, Warning: weights.Warning}
+}
+
+// ServiceConnect mirrors AgentServiceConnect of the Consul API, see https://www.consul.io/api/agent/service.html#connect
+type ServiceConnect struct {
+	Native         bool            `json:",omitempty"`
+	SidecarService *SidecarService `json:",omitempty"`
+}
+
+// SidecarService is registered by the agent along with the parent service. Name, ID, tags, address
+// and health checks are inherited from the parent, and the sidecar is deregistered together with it.
+type SidecarService struct {
+	Port  int           `json:",omitempty"`
+	Proxy *ServiceProxy `json:",omitempty"`
+}
+
+type ServiceProxy struct {
+	Upstreams []ServiceUpstream `json:",omitempty"`
+}
+
+type ServiceUpstream struct {
+	DestinationName string
+	LocalBindPort   int
+}
+
+func connectRegistration(connect *apps.Connect) *ServiceConnect {
+	if connect == nil {
+		return nil
+	}
+	if connect.Native {
+		return &ServiceConnect{Native: true}
+	}
+	sidecar := &SidecarService{Port: connect.SidecarPort}
+	if len(connect.Upstreams) > 0 {
+		sidecar.Proxy = &ServiceProxy{}
+		for _, upstream := range connect.Upstreams {
+			sidecar.Proxy.Upstreams = append(sidecar.Proxy.Upstreams, ServiceUpstream{
+				DestinationName: upstream.DestinationName,
+				LocalBindPort:   upstream.LocalBindPort,
+			})
+		}
+	}
+	return &ServiceConnect{SidecarService: sidecar}
+}
+
+// Sidecar proxies inherit tags of their parent services, including the marathon-task tag, but their
+// lifecycle is managed by the agent, so they must not be treated as separate registrations
+func isSidecarProxy(serviceID string) bool {
+	return strings.HasSuffix(serviceID, sidecarProxySuffix)
+}
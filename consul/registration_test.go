@@ -0,0 +1,277 @@
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/service"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarathonTaskToConsulServiceMapping_ConnectSidecar(t *testing.T) {
+	t.Parallel()
+
+	// given
+	consul := New(Config{Tag: "marathon"})
+	app := &apps.App{
+		ID: "someApp",
+		Labels: map[string]string{
+			"consul":             "",
+			"consul-connect":     "",
+			"consul-upstream-db": "5432",
+		},
+		PortDefinitions: []apps.PortDefinition{
+			{},
+			{Labels: map[string]string{"consul-connect-proxy": ""}},
+		},
+	}
+	task := &apps.Task{
+		ID:    "someTask",
+		AppID: app.ID,
+		Host:  "127.0.0.6",
+		Ports: []int{8090, 8443},
+	}
+
+	// when
+	services, err := consul.marathonTaskToConsulServices(task, app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, services, 1)
+	blob, _ := json.Marshal(services[0].Connect)
+	assert.JSONEq(t, `{
+		"SidecarService": {
+			"Port": 8443,
+			"Proxy": {"Upstreams": [{"DestinationName": "db", "LocalBindPort": 5432}]}
+		}
+	}`, string(blob))
+}
+
+func TestMarathonTaskToConsulServiceMapping_ConnectNative(t *testing.T) {
+	t.Parallel()
+
+	// given
+	consul := New(Config{Tag: "marathon"})
+	app := &apps.App{
+		ID:     "someApp",
+		Labels: map[string]string{"consul": "", "consul-connect": "native"},
+	}
+	task := &apps.Task{ID: "someTask", AppID: app.ID, Host: "127.0.0.6", Ports: []int{8090}}
+
+	// when
+	services, err := consul.marathonTaskToConsulServices(task, app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, &ServiceConnect{Native: true}, services[0].Connect)
+}
+
+func TestRegister_ShouldSendConnectRegistrationToAgent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	agent := newAgentStub()
+	defer agent.Close()
+	consul := consulClientAtAddress(agent.host, agent.port)
+	app := &apps.App{
+		ID:     "someApp",
+		Labels: map[string]string{"consul": "", "consul-connect": "", "consul-upstream-db": "5432"},
+	}
+	task := &apps.Task{ID: "someTask", AppID: app.ID, Host: agent.host, Ports: []int{8090}}
+
+	// when
+	err := consul.Register(task, app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, agent.registrations, 1)
+	assert.Equal(t, "someApp", agent.registrations[0]["Name"])
+	assert.Equal(t, map[string]interface{}{
+		"SidecarService": map[string]interface{}{
+			"Proxy": map[string]interface{}{
+				"Upstreams": []interface{}{
+					map[string]interface{}{"DestinationName": "db", "LocalBindPort": float64(5432)},
+				},
+			},
+		},
+	}, agent.registrations[0]["Connect"])
+}
+
+func TestRegister_ShouldNotSendConnectWhenDisabled(t *testing.T) {
+	t.Parallel()
+
+	// given
+	agent := newAgentStub()
+	defer agent.Close()
+	consul := consulClientAtAddress(agent.host, agent.port)
+	app := &apps.App{ID: "someApp", Labels: map[string]string{"consul": ""}}
+	task := &apps.Task{ID: "someTask", AppID: app.ID, Host: agent.host, Ports: []int{8090}}
+
+	// when
+	err := consul.Register(task, app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, agent.registrations, 1)
+	assert.NotContains(t, agent.registrations[0], "Connect")
+}
+
+func TestConsulServicesToServices_ShouldSkipSidecarProxies(t *testing.T) {
+	t.Parallel()
+
+	// given
+	consulServices := []*consulapi.CatalogService{
+		{ServiceID: "someTask_someApp_8090", ServiceName: "someApp", ServiceTags: []string{"marathon-task:someTask"}},
+		{ServiceID: "someTask_someApp_8090-sidecar-proxy", ServiceName: "someApp-sidecar-proxy", ServiceTags: []string{"marathon-task:someTask"}},
+	}
+
+	// when
+	services := consulServicesToServices(consulServices)
+
+	// then
+	assert.Len(t, services, 1)
+	assert.Equal(t, "someApp", services[0].Name)
+}
+
+// agentStub records service registrations sent to the Consul agent HTTP API
+type agentStub struct {
+	*httptest.Server
+	sync.Mutex
+	host          string
+	port          int
+	registrations []map[string]interface{}
+}
+
+func newAgentStub() *agentStub {
+	a := &agentStub{}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/v1/agent/service/register" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		registration := map[string]interface{}{}
+		json.Unmarshal(body, &registration)
+		a.Lock()
+		a.registrations = append(a.registrations, registration)
+		a.Unlock()
+	}))
+	addr := a.Server.Listener.Addr().(*net.TCPAddr)
+	a.host = addr.IP.String()
+	a.port = addr.Port
+	return a
+}
+
+func TestMarathonTaskToConsulServiceMapping_RegistrationOptions(t *testing.T) {
+	t.Parallel()
+
+	// given
+	consul := New(Config{Tag: "marathon"})
+	app := &apps.App{
+		ID: "someApp",
+		HealthChecks: []apps.HealthCheck{
+			{Path: "/health", Protocol: "HTTPS", PortIndex: 0, IntervalSeconds: 60, TimeoutSeconds: 20},
+			{Protocol: "TCP", PortIndex: 0, IntervalSeconds: 40, TimeoutSeconds: 20},
+		},
+		Labels: map[string]string{
+			"consul":                                         "",
+			"consul-weight-passing":                          "5",
+			"consul-enable-tag-override":                     "true",
+			"consul-check-notes":                             "See runbook",
+			"consul-check-tls-skip-verify":                   "true",
+			"consul-check-header-Host":                       "example.com",
+			"consul-check-deregister-critical-service-after": "10m",
+		},
+	}
+	task := &apps.Task{ID: "someTask", AppID: app.ID, Host: "127.0.0.6", Ports: []int{8090}}
+
+	// when
+	services, err := consul.marathonTaskToConsulServices(task, app)
+
+	// then
+	assert.NoError(t, err)
+	service := services[0]
+	assert.True(t, service.EnableTagOverride)
+	assert.Equal(t, &ServiceWeights{Passing: 5, Warning: 1}, service.Weights)
+	assert.Equal(t, ServiceChecks{
+		{
+			AgentServiceCheck: consulapi.AgentServiceCheck{
+				HTTP:                           "https://127.0.0.6:8090/health",
+				Interval:                       "60s",
+				Timeout:                        "20s",
+				Status:                         "passing",
+				Notes:                          "See runbook",
+				TLSSkipVerify:                  true,
+				DeregisterCriticalServiceAfter: "10m0s",
+			},
+			Header: map[string][]string{"Host": {"example.com"}},
+		},
+		{
+			AgentServiceCheck: consulapi.AgentServiceCheck{
+				TCP:                            "127.0.0.6:8090",
+				Interval:                       "40s",
+				Timeout:                        "20s",
+				Status:                         "passing",
+				Notes:                          "See runbook",
+				DeregisterCriticalServiceAfter: "10m0s",
+			},
+		},
+	}, service.Checks)
+
+	blob, _ := json.Marshal(service)
+	registration := map[string]interface{}{}
+	json.Unmarshal(blob, &registration)
+	assert.Equal(t, map[string]interface{}{"Passing": float64(5), "Warning": float64(1)}, registration["Weights"])
+	assert.Equal(t, map[string]interface{}{"Host": []interface{}{"example.com"}},
+		registration["Checks"].([]interface{})[0].(map[string]interface{})["Header"])
+}
+
+func TestConsulServiceEntriesToServices(t *testing.T) {
+	t.Parallel()
+
+	// given
+	entries := []*consulapi.ServiceEntry{
+		{
+			Node: &consulapi.Node{Address: "10.0.0.1"},
+			Service: &consulapi.AgentService{
+				ID:      "someTask_someApp_8090",
+				Service: "someApp",
+				Tags:    []string{"marathon", "marathon-task:someTask"},
+				Address: "10.0.0.2",
+				Port:    8090,
+			},
+			Checks: consulapi.HealthChecks{
+				{CheckID: "serfHealth"},
+				{CheckID: "service:someTask_someApp_8090:1", ServiceID: "someTask_someApp_8090"},
+				{CheckID: "service:someTask_someApp_8090:2", ServiceID: "someTask_someApp_8090"},
+				{CheckID: "_service_maintenance:someTask_someApp_8090", ServiceID: "someTask_someApp_8090", Notes: "upgrade"},
+			},
+		},
+		{
+			Node:    &consulapi.Node{Address: "10.0.0.1"},
+			Service: &consulapi.AgentService{ID: "someTask_someApp_8090-sidecar-proxy", Service: "someApp-sidecar-proxy"},
+		},
+	}
+
+	// when
+	services := consulServiceEntriesToServices(entries)
+
+	// then
+	assert.Equal(t, []*service.Service{{
+		ID:                      "someTask_someApp_8090",
+		Name:                    "someApp",
+		Tags:                    []string{"marathon", "marathon-task:someTask"},
+		RegisteringAgentAddress: "10.0.0.1",
+		Address:                 "10.0.0.2",
+		Port:                    8090,
+		Checks:                  2,
+		MaintenanceReason:       "upgrade",
+	}}, services)
+}
@@ -0,0 +1,107 @@
+package consul
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/allegro/marathon-consul/service"
+)
+
+// tokens holds the default ACL token and tokens of apps by their aliases, they are shared
+// by Consul instances of all Marathon clusters and can change while running
+type tokens struct {
+	lock         sync.RWMutex
+	defaultToken string
+	aliases      map[string]string
+	// aliases of tokens services were registered or read with, so they are deregistered with the same token
+	services map[service.ServiceId]string
+}
+
+func newTokens(config Config) *tokens {
+	t := &tokens{services: make(map[service.ServiceId]string)}
+	t.set(config)
+	return t
+}
+
+func (t *tokens) set(config Config) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.defaultToken = config.Token
+	t.aliases = config.Tokens
+}
+
+func (t *tokens) getDefault() string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.defaultToken
+}
+
+// get returns the token of the alias, or the default token when the alias is empty
+func (t *tokens) get(alias string) (string, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if alias == "" {
+		return t.defaultToken, true
+	}
+	token, ok := t.aliases[alias]
+	return token, ok
+}
+
+// readAliases returns aliases of per-app tokens in order followed by the empty alias of the default token.
+// Per-app ACLs may hide services from the default token, so services are read with all of them.
+func (t *tokens) readAliases() []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	aliases := make([]string, 0, len(t.aliases)+1)
+	for alias := range t.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return append(aliases, "")
+}
+
+// remember records the alias of the token the service with the ID was registered with
+func (t *tokens) remember(id string, alias string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.services[service.ServiceId(id)] = alias
+}
+
+// learn records the alias of the token the service was read with, unless it is known already
+func (t *tokens) learn(id service.ServiceId, alias string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.services[id]; !ok {
+		t.services[id] = alias
+	}
+}
+
+func (t *tokens) forget(id service.ServiceId) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.services, id)
+}
+
+// of returns the token the service was registered with. Without per-app tokens it is the default token,
+// otherwise services neither registered nor read by this process, as well as services of aliases
+// no longer configured, have no token, as ACLs would reject changing them with the default one.
+func (t *tokens) of(id service.ServiceId) (string, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	alias, ok := t.services[id]
+	if !ok {
+		if len(t.aliases) == 0 {
+			return t.defaultToken, nil
+		}
+		return "", fmt.Errorf("Unknown Consul token of service %s", id)
+	}
+	if alias == "" {
+		return t.defaultToken, nil
+	}
+	token, ok := t.aliases[alias]
+	if !ok {
+		return "", fmt.Errorf("Unknown Consul token alias %q of service %s", alias, id)
+	}
+	return token, nil
+}
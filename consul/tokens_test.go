@@ -0,0 +1,215 @@
+package consul
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubAgent stands in for a Consul agent recording the ACL token of every request.
+// Reads are answered with responses for the token of the request, keyed by token and path, or with nothing found in the only datacenter.
+type stubAgent struct {
+	sync.Mutex
+	*httptest.Server
+	tokens    map[string]string
+	responses map[string]string
+}
+
+func newStubAgent() *stubAgent {
+	stub := &stubAgent{tokens: make(map[string]string), responses: make(map[string]string)}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.Lock()
+		defer stub.Unlock()
+		token := r.Header.Get("X-Consul-Token")
+		stub.tokens[r.Method+" "+r.URL.Path] = token
+		if response, ok := stub.responses[token+" "+r.URL.Path]; ok {
+			w.Write([]byte(response))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/catalog/datacenters":
+			w.Write([]byte(`["dc1"]`))
+		case "/v1/catalog/services":
+			w.Write([]byte(`{}`))
+		}
+	}))
+	return stub
+}
+
+// withService makes the service of the stub agent visible to the token
+func (s *stubAgent) withService(token string, id string) {
+	s.Lock()
+	defer s.Unlock()
+	s.responses[token+" /v1/catalog/services"] = `{"` + id + `":["marathon"]}`
+	s.responses[token+" /v1/health/service/"+id] =
+		`[{"Node":{"Address":"127.0.0.1"},"Service":{"ID":"` + id + `","Service":"` + id + `","Tags":["marathon"]}}]`
+}
+
+func (s *stubAgent) tokenOf(call string) string {
+	s.Lock()
+	defer s.Unlock()
+	return s.tokens[call]
+}
+
+func consulAtStubAgent(t *testing.T, stub *stubAgent, config Config) *Consul {
+	address, err := url.Parse(stub.URL)
+	require.NoError(t, err)
+	config.Port = address.Port()
+	return New(config)
+}
+
+func TestRegister_WithTokenOfAlias(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	consul := consulAtStubAgent(t, stub, Config{Tag: "marathon", Token: "default-token",
+		Tokens: map[string]string{"payments": "payments-token"}})
+	app := utils.ConsulApp("serviceA", 1)
+	app.Tasks[0].Host = "127.0.0.1"
+	app.Labels["consul-token-alias"] = "payments"
+
+	// when
+	err := consul.Register(&app.Tasks[0], app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "payments-token", stub.tokenOf("PUT /v1/agent/service/register"))
+}
+
+func TestRegister_WithDefaultToken(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	consul := consulAtStubAgent(t, stub, Config{Tag: "marathon", Token: "default-token"})
+	app := utils.ConsulApp("serviceA", 1)
+	app.Tasks[0].Host = "127.0.0.1"
+
+	// when
+	err := consul.Register(&app.Tasks[0], app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "default-token", stub.tokenOf("PUT /v1/agent/service/register"))
+}
+
+func TestRegister_FailsForUnknownAlias(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	consul := consulAtStubAgent(t, stub, Config{Tag: "marathon", Token: "default-token"})
+	app := utils.ConsulApp("serviceA", 1)
+	app.Tasks[0].Host = "127.0.0.1"
+	app.Labels["consul-token-alias"] = "payments"
+
+	// when
+	err := consul.Register(&app.Tasks[0], app)
+
+	// then
+	assert.Error(t, err)
+	assert.Equal(t, "", stub.tokenOf("PUT /v1/agent/service/register"))
+}
+
+func TestDeregister_WithTokenOfAliasTheServiceWasRegisteredWith(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	consul := consulAtStubAgent(t, stub, Config{Tag: "marathon", Token: "default-token",
+		Tokens: map[string]string{"payments": "payments-token"}})
+	app := utils.ConsulApp("serviceA", 1)
+	app.Tasks[0].Host = "127.0.0.1"
+	app.Labels["consul-token-alias"] = "payments"
+	require.NoError(t, consul.Register(&app.Tasks[0], app))
+	registered, _ := consul.ExpectedServices(&app.Tasks[0], app)
+
+	// when
+	err := consul.Deregister(registered[0])
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "payments-token", stub.tokenOf("PUT /v1/agent/service/deregister/"+registered[0].ID.String()))
+}
+
+func TestDeregister_WithTokenOfAliasTheServiceWasReadWith(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	stub.withService("payments-token", "serviceA")
+	consul := consulAtStubAgent(t, stub, Config{Tag: "marathon", Token: "default-token",
+		Tokens: map[string]string{"payments": "payments-token"}})
+	require.NoError(t, consul.AddAgent("127.0.0.1"))
+
+	// when
+	services, err := consul.GetAllServices()
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.NoError(t, consul.Deregister(services[0]))
+	assert.Equal(t, "payments-token", stub.tokenOf("PUT /v1/agent/service/deregister/serviceA"))
+}
+
+func TestDeregister_FailsForServiceOfUnknownToken(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	consul := consulAtStubAgent(t, stub, Config{Token: "default-token",
+		Tokens: map[string]string{"payments": "payments-token"}})
+	registered := &service.Service{ID: "serviceA", RegisteringAgentAddress: "127.0.0.1", Tags: []string{"marathon"}}
+
+	// when
+	err := consul.Deregister(registered)
+
+	// then
+	assert.Error(t, err)
+	assert.Equal(t, "", stub.tokenOf("PUT /v1/agent/service/deregister/serviceA"))
+}
+
+func TestDeregister_WithDefaultTokenWithoutPerAppTokens(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	consul := consulAtStubAgent(t, stub, Config{Token: "default-token"})
+	registered := &service.Service{ID: "serviceA", RegisteringAgentAddress: "127.0.0.1", Tags: []string{"marathon"}}
+
+	// when
+	err := consul.Deregister(registered)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "default-token", stub.tokenOf("PUT /v1/agent/service/deregister/serviceA"))
+}
+
+func TestEnableMaintenance_WithTokenOfAlias(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubAgent()
+	defer stub.Close()
+	consul := consulAtStubAgent(t, stub, Config{Tag: "marathon", Token: "default-token",
+		Tokens: map[string]string{"payments": "payments-token"}})
+	app := utils.ConsulApp("serviceA", 1)
+	app.Tasks[0].Host = "127.0.0.1"
+	app.Labels["consul-token-alias"] = "payments"
+	require.NoError(t, consul.Register(&app.Tasks[0], app))
+	registered, _ := consul.ExpectedServices(&app.Tasks[0], app)
+
+	// when
+	err := consul.EnableMaintenance(registered[0], "deploy")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "payments-token", stub.tokenOf("PUT /v1/agent/service/maintenance/"+registered[0].ID.String()))
+}
@@ -0,0 +1,10 @@
+package etcd
+
+import "github.com/allegro/marathon-consul/time"
+
+type Config struct {
+	Endpoints string
+	Prefix    string
+	LeaseTTL  time.Interval
+	Timeout   time.Interval
+}
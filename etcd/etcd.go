@@ -0,0 +1,414 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
+)
+
+// Etcd is a service registry keeping service instances in etcd v3 under a configured key prefix.
+// Every instance is stored as a JSON document at <prefix>/<service name>/<service id> and attached
+// to a lease, so registrations of a dead marathon-consul expire instead of lingering forever.
+// etcd is accessed through its gRPC JSON gateway, see https://etcd.io/docs/v3.4/dev-guide/api_grpc_gateway/
+type Etcd struct {
+	config         Config
+	nameSeparator  string
+	tagLabelPrefix string
+	endpoints      []string
+	client         *http.Client
+	lock           sync.Mutex
+	lease          int64
+}
+
+type instance struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Port    int      `json:"port"`
+	Tags    []string `json:"tags"`
+}
+
+func New(config Config, nameSeparator string, tagLabelPrefix string) *Etcd {
+	var endpoints []string
+	for _, endpoint := range strings.Split(config.Endpoints, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/"))
+		}
+	}
+	return &Etcd{
+		config:         config,
+		nameSeparator:  nameSeparator,
+		tagLabelPrefix: tagLabelPrefix,
+		endpoints:      endpoints,
+		client:         &http.Client{Timeout: config.Timeout.Duration},
+	}
+}
+
+func (e *Etcd) GetAllServices() ([]*service.Service, error) {
+	return e.getServicesWithPrefix(e.prefix() + "/")
+}
+
+func (e *Etcd) GetServices(name string) ([]*service.Service, error) {
+	return e.getServicesWithPrefix(e.serviceKeyPrefix(name))
+}
+
+func (e *Etcd) getServicesWithPrefix(prefix string) ([]*service.Service, error) {
+	instances, err := e.rangeInstances(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var services []*service.Service
+	for _, i := range instances {
+		services = append(services, i.toService())
+	}
+	return services, nil
+}
+
+func (e *Etcd) Register(task *apps.Task, app *apps.App) error {
+	var err error
+	metrics.Time("etcd.register", func() { err = e.register(task, app) })
+	if err != nil {
+		metrics.Mark("etcd.register.error")
+	} else {
+		metrics.Mark("etcd.register.success")
+	}
+	return err
+}
+
+func (e *Etcd) register(task *apps.Task, app *apps.App) error {
+	instances, err := e.taskInstances(task, app)
+	if err != nil {
+		return err
+	}
+	lease, err := e.currentLease()
+	if err != nil {
+		return err
+	}
+
+	var registerErrors []error
+	for _, i := range instances {
+		fields := log.Fields{
+			"Name":    i.Name,
+			"Id":      i.ID,
+			"Tags":    i.Tags,
+			"Address": i.Address,
+			"Port":    i.Port,
+		}
+		log.WithFields(fields).Info("Registering")
+		if err := e.put(e.instanceKey(i.Name, i.ID), i, lease); err != nil {
+			log.WithError(err).WithFields(fields).Error("Unable to register")
+			registerErrors = append(registerErrors, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(registerErrors, "registering services")
+}
+
+func (e *Etcd) ExpectedServices(task *apps.Task, app *apps.App) ([]*service.Service, error) {
+	instances, err := e.taskInstances(task, app)
+	if err != nil {
+		return nil, err
+	}
+	var services []*service.Service
+	for _, i := range instances {
+		services = append(services, i.toService())
+	}
+	return services, nil
+}
+
+func (e *Etcd) taskInstances(task *apps.Task, app *apps.App) ([]instance, error) {
+	IP, err := utils.HostToIPv4(task.Host)
+	if err != nil {
+		return nil, err
+	}
+	var instances []instance
+	for _, intent := range app.RegistrationIntents(task, e.nameSeparator, e.tagLabelPrefix) {
+		instances = append(instances, instance{
+			ID:      fmt.Sprintf("%s_%s_%d", task.ID, intent.Name, intent.Port),
+			Name:    intent.Name,
+			Address: IP.String(),
+			Port:    intent.Port,
+			Tags:    append(append([]string{}, intent.Tags...), service.MarathonTaskTag(task.ID)),
+		})
+	}
+	return instances, nil
+}
+
+func (i instance) toService() *service.Service {
+	return &service.Service{
+		ID:                      service.ServiceId(i.ID),
+		Name:                    i.Name,
+		Tags:                    i.Tags,
+		RegisteringAgentAddress: i.Address,
+		Address:                 i.Address,
+		Port:                    i.Port,
+	}
+}
+
+func (e *Etcd) DeregisterByTask(taskID apps.TaskID) error {
+	services, err := e.GetAllServices()
+	if err != nil {
+		return err
+	}
+	var deregisterErrors []error
+	found := false
+	for _, s := range services {
+		if id, err := s.TaskId(); err != nil || id != taskID {
+			continue
+		}
+		found = true
+		if err := e.Deregister(s); err != nil {
+			deregisterErrors = append(deregisterErrors, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("Couldn't find any service matching task id %s", taskID)
+	}
+	return utils.MergeErrorsOrNil(deregisterErrors, fmt.Sprintf("deregistering by task %s", taskID))
+}
+
+func (e *Etcd) Deregister(toDeregister *service.Service) error {
+	var err error
+	metrics.Time("etcd.deregister", func() { err = e.deregister(toDeregister) })
+	if err != nil {
+		metrics.Mark("etcd.deregister.error")
+	} else {
+		metrics.Mark("etcd.deregister.success")
+	}
+	return err
+}
+
+func (e *Etcd) deregister(toDeregister *service.Service) error {
+	log.WithField("Id", toDeregister.ID).WithField("Address", toDeregister.RegisteringAgentAddress).Info("Deregistering")
+	key := e.instanceKey(toDeregister.Name, toDeregister.ID.String())
+	err := e.post("/v3/kv/deleterange", deleteRangeRequest{Key: encode(key)}, nil)
+	if err != nil {
+		log.WithError(err).WithField("Id", toDeregister.ID).WithField("Address", toDeregister.RegisteringAgentAddress).Error("Unable to deregister")
+	}
+	return err
+}
+
+func (e *Etcd) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	return errors.New("Maintenance mode is not supported by etcd registry")
+}
+
+func (e *Etcd) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	return errors.New("Maintenance mode is not supported by etcd registry")
+}
+
+func (e *Etcd) DisableMaintenance(toMaintain *service.Service) error {
+	return errors.New("Maintenance mode is not supported by etcd registry")
+}
+
+func (e *Etcd) prefix() string {
+	return "/" + strings.Trim(e.config.Prefix, "/")
+}
+
+func (e *Etcd) serviceKeyPrefix(name string) string {
+	return fmt.Sprintf("%s/%s/", e.prefix(), name)
+}
+
+func (e *Etcd) instanceKey(name, id string) string {
+	return e.serviceKeyPrefix(name) + id
+}
+
+// currentLease returns id of a lease all registrations are attached to, granting a new one when needed.
+// The lease is kept alive in the background until etcd stops acknowledging it.
+func (e *Etcd) currentLease() (int64, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.lease != 0 {
+		return e.lease, nil
+	}
+	if e.config.LeaseTTL.Duration <= 0 {
+		return 0, errors.New("Lease TTL must be positive")
+	}
+	ttl := int64(math.Ceil(e.config.LeaseTTL.Seconds()))
+	response := &leaseResponse{}
+	if err := e.post("/v3/lease/grant", leaseGrantRequest{TTL: ttl}, response); err != nil {
+		return 0, err
+	}
+	if response.ID == 0 {
+		return 0, errors.New("etcd granted lease without id")
+	}
+	e.lease = int64(response.ID)
+	log.WithField("Lease", e.lease).WithField("TTL", int64(response.TTL)).Info("Granted etcd lease")
+	go e.keepAlive(e.lease)
+	return e.lease, nil
+}
+
+func (e *Etcd) keepAlive(lease int64) {
+	ticker := time.NewTicker(e.config.LeaseTTL.Duration / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		response := &leaseKeepAliveResponse{}
+		err := e.post("/v3/lease/keepalive", leaseKeepAliveRequest{ID: int64String(lease)}, response)
+		if err == nil && response.Result.TTL > 0 {
+			metrics.Mark("etcd.lease.keepalive.success")
+			continue
+		}
+		metrics.Mark("etcd.lease.keepalive.error")
+		if err == nil {
+			err = errors.New("Lease expired")
+		}
+		log.WithError(err).WithField("Lease", lease).
+			Warn("Unable to keep etcd lease alive, registrations will be restored on the next sync")
+		e.lock.Lock()
+		if e.lease == lease {
+			e.lease = 0
+		}
+		e.lock.Unlock()
+		return
+	}
+}
+
+func (e *Etcd) put(key string, i instance, lease int64) error {
+	value, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return e.post("/v3/kv/put", putRequest{Key: encode(key), Value: base64.StdEncoding.EncodeToString(value), Lease: int64String(lease)}, nil)
+}
+
+func (e *Etcd) rangeInstances(prefix string) ([]instance, error) {
+	response := &rangeResponse{}
+	err := e.post("/v3/kv/range", rangeRequest{Key: encode(prefix), RangeEnd: encode(prefixRangeEnd(prefix))}, response)
+	if err != nil {
+		return nil, err
+	}
+	var instances []instance
+	for _, kv := range response.Kvs {
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		i := instance{}
+		if err := json.Unmarshal(value, &i); err != nil {
+			log.WithError(err).WithField("Key", kv.Key).Warn("Skipping malformed service instance")
+			continue
+		}
+		instances = append(instances, i)
+	}
+	return instances, nil
+}
+
+// post sends request to the first endpoint able to handle it
+func (e *Etcd) post(path string, in interface{}, out interface{}) error {
+	if len(e.endpoints) == 0 {
+		return errors.New("No etcd endpoints configured")
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, endpoint := range e.endpoints {
+		err := e.postToEndpoint(endpoint+path, body, out)
+		if err == nil {
+			return nil
+		}
+		log.WithError(err).WithField("Endpoint", endpoint).Debug("etcd request failed")
+		errs = append(errs, err)
+	}
+	return utils.MergeErrorsOrNil(errs, fmt.Sprintf("requesting etcd %s", path))
+}
+
+func (e *Etcd) postToEndpoint(url string, body []byte, out interface{}) error {
+	var response *http.Response
+	var err error
+	metrics.Time("etcd.post", func() { response, err = e.client.Post(url, "application/json", bytes.NewReader(body)) })
+	if err != nil {
+		metrics.Mark("etcd.post.error")
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		metrics.Mark("etcd.post.error")
+		return fmt.Errorf("Expected 200 but got %d for %s", response.StatusCode, url)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+func encode(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+// prefixRangeEnd returns the smallest key greater than all keys having given prefix
+func prefixRangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return "\x00"
+}
+
+// int64String handles int64 values, which the JSON gateway encodes as strings
+type int64String int64
+
+func (i int64String) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(i), 10))
+}
+
+func (i *int64String) UnmarshalJSON(b []byte) error {
+	value, err := strconv.ParseInt(strings.Trim(string(b), "\""), 10, 64)
+	*i = int64String(value)
+	return err
+}
+
+type putRequest struct {
+	Key   string      `json:"key"`
+	Value string      `json:"value"`
+	Lease int64String `json:"lease"`
+}
+
+type rangeRequest struct {
+	Key      string `json:"key"`
+	RangeEnd string `json:"range_end"`
+}
+
+type rangeResponse struct {
+	Kvs []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"kvs"`
+}
+
+type deleteRangeRequest struct {
+	Key string `json:"key"`
+}
+
+type leaseGrantRequest struct {
+	TTL int64 `json:"TTL"`
+}
+
+type leaseResponse struct {
+	ID  int64String `json:"ID"`
+	TTL int64String `json:"TTL"`
+}
+
+type leaseKeepAliveRequest struct {
+	ID int64String `json:"ID"`
+}
+
+type leaseKeepAliveResponse struct {
+	Result leaseResponse `json:"result"`
+}
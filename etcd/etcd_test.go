@@ -0,0 +1,268 @@
+package etcd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/service"
+	timeutil "github.com/allegro/marathon-consul/time"
+	"github.com/allegro/marathon-consul/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newEtcdServer()
+	defer server.Close()
+	etcd := New(Config{Endpoints: server.URL, Prefix: "/services/", LeaseTTL: timeutil.Interval{Duration: time.Minute}}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 2)
+
+	// when
+	for _, task := range app.Tasks {
+		err := etcd.Register(&task, app)
+		assert.NoError(t, err)
+	}
+
+	// then
+	assert.Equal(t, []string{
+		"/services/test.app/test_app.0_test.app_8080",
+		"/services/test.app/test_app.1_test.app_8081",
+	}, server.keys())
+	assert.Equal(t, "1", server.leases["/services/test.app/test_app.0_test.app_8080"])
+
+	// when
+	services, err := etcd.GetServices("test.app")
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, services, 2)
+	assert.Equal(t, "test.app", services[0].Name)
+	assert.Equal(t, "127.0.0.1", services[0].RegisteringAgentAddress)
+	assert.Equal(t, []string{"marathon-task:test_app.0"}, services[0].Tags)
+}
+
+func TestRegister_ForInvalidHost(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newEtcdServer()
+	defer server.Close()
+	etcd := New(Config{Endpoints: server.URL, Prefix: "services", LeaseTTL: timeutil.Interval{Duration: time.Minute}}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	app.Tasks[0].Host = "invalid.host.local"
+
+	// when
+	err := etcd.Register(&app.Tasks[0], app)
+
+	// then
+	assert.Error(t, err)
+	assert.Empty(t, server.keys())
+}
+
+func TestRegister_ShouldFailWithoutLeaseTTL(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newEtcdServer()
+	defer server.Close()
+	etcd := New(Config{Endpoints: server.URL, Prefix: "services"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+
+	// when
+	err := etcd.Register(&app.Tasks[0], app)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestGetAllServices_ShouldFailoverToNextEndpoint(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newEtcdServer()
+	defer server.Close()
+	etcd := New(Config{Endpoints: "http://127.5.5.5:5555, " + server.URL, Prefix: "services",
+		LeaseTTL: timeutil.Interval{Duration: time.Minute}, Timeout: timeutil.Interval{Duration: time.Second}}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	etcd.Register(&app.Tasks[0], app)
+
+	// when
+	services, err := etcd.GetAllServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, services, 1)
+}
+
+func TestGetAllServices_WithoutEndpoints(t *testing.T) {
+	t.Parallel()
+	// given
+	etcd := New(Config{Prefix: "services"}, ".", "consul-tag-")
+
+	// when
+	services, err := etcd.GetAllServices()
+
+	// then
+	assert.Error(t, err)
+	assert.Nil(t, services)
+}
+
+func TestDeregisterByTask(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newEtcdServer()
+	defer server.Close()
+	etcd := New(Config{Endpoints: server.URL, Prefix: "services", LeaseTTL: timeutil.Interval{Duration: time.Minute}}, ".", "consul-tag-")
+	app := utils.ConsulAppMultipleRegistrations("/test/app", 2, 2)
+	for _, task := range app.Tasks {
+		etcd.Register(&task, app)
+	}
+
+	// when
+	err := etcd.DeregisterByTask(app.Tasks[0].ID)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/services/test.app/test_app.1_test.app_8081",
+		"/services/test.app/test_app.1_test.app_8083",
+	}, server.keys())
+
+	// when
+	err = etcd.DeregisterByTask(app.Tasks[0].ID)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestDeregister(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newEtcdServer()
+	defer server.Close()
+	etcd := New(Config{Endpoints: server.URL, Prefix: "services", LeaseTTL: timeutil.Interval{Duration: time.Minute}}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	etcd.Register(&app.Tasks[0], app)
+
+	// when
+	err := etcd.Deregister(&service.Service{ID: "test_app.0_test.app_8080", Name: "test.app"})
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, server.keys())
+}
+
+func TestLease_ShouldBeRenewedAfterExpiry(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newEtcdServer()
+	defer server.Close()
+	etcd := New(Config{Endpoints: server.URL, Prefix: "services", LeaseTTL: timeutil.Interval{Duration: 30 * time.Millisecond}}, ".", "consul-tag-")
+	lease, err := etcd.currentLease()
+	assert.NoError(t, err)
+
+	// when
+	server.expireLeases()
+	<-time.After(50 * time.Millisecond)
+	renewed, err := etcd.currentLease()
+
+	// then
+	assert.NoError(t, err)
+	assert.NotEqual(t, lease, renewed)
+}
+
+func TestPrefixRangeEnd(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "/services0", prefixRangeEnd("/services/"))
+	assert.Equal(t, "b", prefixRangeEnd("a\xff"))
+	assert.Equal(t, "\x00", prefixRangeEnd("\xff"))
+}
+
+// etcdServer is a minimal stand-in for the etcd v3 JSON gateway
+type etcdServer struct {
+	*httptest.Server
+	sync.Mutex
+	kvs       map[string]string
+	leases    map[string]string
+	lastLease int
+	expired   bool
+}
+
+func newEtcdServer() *etcdServer {
+	s := &etcdServer{kvs: make(map[string]string), leases: make(map[string]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *etcdServer) keys() []string {
+	s.Lock()
+	defer s.Unlock()
+	keys := []string{}
+	for key := range s.kvs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *etcdServer) expireLeases() {
+	s.Lock()
+	defer s.Unlock()
+	s.expired = true
+}
+
+func (s *etcdServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	request := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&request)
+	key := decode(request["key"])
+	switch r.URL.Path {
+	case "/v3/kv/put":
+		s.kvs[key] = request["value"].(string)
+		s.leases[key] = request["lease"].(string)
+		w.Write([]byte(`{}`))
+	case "/v3/kv/range":
+		type kv struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		var keys []string
+		for k := range s.kvs {
+			if k >= key && k < decode(request["range_end"]) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		var kvs []kv
+		for _, k := range keys {
+			kvs = append(kvs, kv{Key: base64.StdEncoding.EncodeToString([]byte(k)), Value: s.kvs[k]})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kvs": kvs})
+	case "/v3/kv/deleterange":
+		delete(s.kvs, key)
+		w.Write([]byte(`{}`))
+	case "/v3/lease/grant":
+		s.lastLease++
+		s.expired = false
+		json.NewEncoder(w).Encode(map[string]interface{}{"ID": s.lastLease, "TTL": "1"})
+	case "/v3/lease/keepalive":
+		if s.expired {
+			w.Write([]byte(`{"result":{"ID":"1"}}`))
+		} else {
+			w.Write([]byte(`{"result":{"ID":"1","TTL":"1"}}`))
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func decode(value interface{}) string {
+	encoded, _ := value.(string)
+	decoded, _ := base64.StdEncoding.DecodeString(encoded)
+	return string(decoded)
+}
@@ -0,0 +1,37 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/allegro/marathon-consul/apps"
+)
+
+// AppEvent is an event concerning the whole app, like app_terminated_event or api_post_event
+type AppEvent struct {
+	Type  string     `json:"eventType"`
+	AppID apps.AppID `json:"appId"`
+	// api_post_event carries the posted definition instead of the app id
+	AppDefinition *struct {
+		ID apps.AppID `json:"id"`
+	} `json:"appDefinition"`
+}
+
+func (e AppEvent) App() apps.AppID {
+	if e.AppID == "" && e.AppDefinition != nil {
+		return e.AppDefinition.ID
+	}
+	return e.AppID
+}
+
+func ParseAppEvent(event []byte) (*AppEvent, error) {
+	appEvent := &AppEvent{}
+	if err := json.Unmarshal(event, appEvent); err != nil {
+		return nil, err
+	}
+	// e.g. api_post_event for groups contains groupDefinition
+	if appEvent.App() == "" {
+		return nil, errors.New("Missing app ID")
+	}
+	return appEvent, nil
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAppEvent_AppTerminated(t *testing.T) {
+	t.Parallel()
+
+	// when
+	appEvent, err := ParseAppEvent([]byte(`{"eventType":"app_terminated_event","appId":"/test/app","timestamp":"2017-01-01T12:00:00.000Z"}`))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, apps.AppID("/test/app"), appEvent.App())
+}
+
+func TestParseAppEvent_ApiPost(t *testing.T) {
+	t.Parallel()
+
+	// when
+	appEvent, err := ParseAppEvent([]byte(`{
+	  "eventType":"api_post_event",
+	  "clientIp":"10.0.0.1",
+	  "uri":"/v2/apps/test/app",
+	  "appDefinition":{"id":"/test/app","labels":{"consul":"new-name"}}
+	}`))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, apps.AppID("/test/app"), appEvent.App())
+}
+
+func TestParseAppEvent_MissingAppID(t *testing.T) {
+	t.Parallel()
+
+	// when
+	_, err := ParseAppEvent([]byte(`{"eventType":"api_post_event","groupDefinition":{"id":"/test"}}`))
+
+	// then
+	assert.Error(t, err)
+}
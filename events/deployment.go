@@ -0,0 +1,73 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/allegro/marathon-consul/apps"
+)
+
+// Deployment actions killing tasks of the app
+const (
+	StopApplicationAction   = "StopApplication"
+	KillAllOldTasksOfAction = "KillAllOldTasksOf"
+)
+
+type DeploymentAction struct {
+	Action string `json:"action"`
+	// Marathon before 1.0 uses type instead of action
+	Type string     `json:"type"`
+	App  apps.AppID `json:"app"`
+}
+
+func (a DeploymentAction) Name() string {
+	if a.Action != "" {
+		return a.Action
+	}
+	return a.Type
+}
+
+// IsKill tells whether the action kills tasks that can be known upfront.
+// Scaling down is not included, as Marathon picks tasks to kill when the step starts.
+func (a DeploymentAction) IsKill() bool {
+	return a.Name() == StopApplicationAction || a.Name() == KillAllOldTasksOfAction
+}
+
+type DeploymentStep struct {
+	Actions []DeploymentAction `json:"actions"`
+}
+
+type DeploymentPlan struct {
+	ID    string           `json:"id"`
+	Steps []DeploymentStep `json:"steps"`
+	// Version of the apps the deployment targets
+	Version string `json:"version"`
+}
+
+type DeploymentEvent struct {
+	Type        string         `json:"eventType"`
+	Plan        DeploymentPlan `json:"plan"`
+	CurrentStep DeploymentStep `json:"currentStep"`
+}
+
+func ParseDeploymentEvent(event []byte) (*DeploymentEvent, error) {
+	deployment := &DeploymentEvent{}
+	if err := json.Unmarshal(event, deployment); err != nil {
+		return nil, err
+	}
+	if deployment.Plan.ID == "" {
+		return nil, errors.New("Missing deployment plan ID")
+	}
+	return deployment, nil
+}
+
+// NextStep returns the step following the current one in the plan or nil if there is none
+func (d DeploymentEvent) NextStep() *DeploymentStep {
+	for i, step := range d.Plan.Steps {
+		if reflect.DeepEqual(step, d.CurrentStep) && i+1 < len(d.Plan.Steps) {
+			return &d.Plan.Steps[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeploymentEvent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	body := []byte(`{
+	  "eventType": "deployment_step_success",
+	  "timestamp": "2017-01-01T12:00:00.000Z",
+	  "plan": {
+	    "id": "plan-id",
+	    "steps": [
+	      {"actions": [{"action": "ScaleApplication", "app": "/test/app"}]},
+	      {"actions": [{"action": "KillAllOldTasksOf", "app": "/test/app"}]}
+	    ]
+	  },
+	  "currentStep": {"actions": [{"action": "ScaleApplication", "app": "/test/app"}]}
+	}`)
+
+	// when
+	deployment, err := ParseDeploymentEvent(body)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "deployment_step_success", deployment.Type)
+	assert.False(t, deployment.CurrentStep.Actions[0].IsKill())
+	next := deployment.NextStep()
+	assert.Equal(t, []DeploymentAction{{Action: KillAllOldTasksOfAction, App: apps.AppID("/test/app")}}, next.Actions)
+	assert.True(t, next.Actions[0].IsKill())
+}
+
+func TestParseDeploymentEvent_LastStepHasNoNextStep(t *testing.T) {
+	t.Parallel()
+
+	// given
+	body := []byte(`{
+	  "eventType": "deployment_info",
+	  "plan": {"id": "plan-id", "steps": [{"actions": [{"type": "StopApplication", "app": "/test/app"}]}]},
+	  "currentStep": {"actions": [{"type": "StopApplication", "app": "/test/app"}]}
+	}`)
+
+	// when
+	deployment, err := ParseDeploymentEvent(body)
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, deployment.CurrentStep.Actions[0].IsKill())
+	assert.Nil(t, deployment.NextStep())
+}
+
+func TestParseDeploymentEvent_MissingPlan(t *testing.T) {
+	t.Parallel()
+
+	// when
+	_, err := ParseDeploymentEvent([]byte(`{"eventType": "deployment_info"}`))
+
+	// then
+	assert.Error(t, err)
+}
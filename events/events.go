@@ -5,6 +5,8 @@ import (
 	"errors"
 	"strings"
 	"time"
+
+	"github.com/allegro/marathon-consul/apps"
 )
 
 type Timestamp struct {
@@ -28,6 +30,18 @@ func (t *Timestamp) String() string {
 type Event struct {
 	Type      string    `json:"eventType"`
 	Timestamp Timestamp `json:"timestamp"`
+	// Task related fields are set only for task events
+	ID         apps.TaskID `json:"taskId"`
+	InstanceID string      `json:"instanceId"`
+	TaskStatus string      `json:"taskStatus"`
+}
+
+// TaskID returns id of the task the event is about, empty for events not related to a task
+func (e Event) TaskID() apps.TaskID {
+	if e.ID != "" || e.InstanceID == "" {
+		return e.ID
+	}
+	return taskIDFromInstanceID(e.InstanceID)
 }
 
 func ParseEvent(jsonBlob []byte) (Event, error) {
@@ -3,6 +3,7 @@ package events
 import (
 	"testing"
 
+	"github.com/allegro/marathon-consul/apps"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -42,3 +43,18 @@ func TestEventTypeInvalidJson(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, out, Event{})
 }
+
+func TestEventTaskID(t *testing.T) {
+	t.Parallel()
+
+	// when
+	status, _ := ParseEvent([]byte(`{"eventType":"status_update_event","timestamp":"2014-03-01T23:29:30.158Z","taskId":"app.1","taskStatus":"TASK_KILLED"}`))
+	health, _ := ParseEvent([]byte(`{"eventType":"health_status_changed_event","timestamp":"2014-03-01T23:29:30.158Z","instanceId":"app.marathon-1"}`))
+	app, _ := ParseEvent([]byte(`{"eventType":"app_terminated_event","timestamp":"2014-03-01T23:29:30.158Z","appId":"/app"}`))
+
+	// then
+	assert.Equal(t, apps.TaskID("app.1"), status.TaskID())
+	assert.Equal(t, "TASK_KILLED", status.TaskStatus)
+	assert.Equal(t, apps.TaskID("app.1"), health.TaskID())
+	assert.Equal(t, apps.TaskID(""), app.TaskID())
+}
@@ -26,7 +26,11 @@ func (t TaskHealthChange) TaskID() apps.TaskID {
 	if t.ID != "" {
 		return t.ID
 	}
-	return apps.TaskID(instanceIdRegex.ReplaceAllString(t.InstanceID, "$1.$3"))
+	return taskIDFromInstanceID(t.InstanceID)
+}
+
+func taskIDFromInstanceID(instanceID string) apps.TaskID {
+	return apps.TaskID(instanceIdRegex.ReplaceAllString(instanceID, "$1.$3"))
 }
 
 func ParseTaskHealthChange(event []byte) (*TaskHealthChange, error) {
@@ -0,0 +1,6 @@
+package file
+
+type Config struct {
+	Path   string
+	Format string
+}
@@ -0,0 +1,294 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// File is a service registry writing a snapshot of all registrations to a single file,
+// so static tooling can consume it. The snapshot is rewritten atomically on every change.
+type File struct {
+	config         Config
+	nameSeparator  string
+	tagLabelPrefix string
+	lock           sync.RWMutex
+	instances      map[service.ServiceId]*Instance
+}
+
+type Snapshot struct {
+	Services []*Instance `json:"services" yaml:"services"`
+}
+
+type Instance struct {
+	ID      string   `json:"id" yaml:"id"`
+	Name    string   `json:"name" yaml:"name"`
+	Address string   `json:"address" yaml:"address"`
+	Port    int      `json:"port" yaml:"port"`
+	Tags    []string `json:"tags" yaml:"tags"`
+}
+
+// New creates a file registry, restoring registrations from an already existing snapshot
+func New(config Config, nameSeparator string, tagLabelPrefix string) (*File, error) {
+	format := strings.ToLower(config.Format)
+	if format != "json" && format != "yaml" {
+		return nil, fmt.Errorf("Unknown snapshot format %s", config.Format)
+	}
+	if config.Path == "" {
+		return nil, errors.New("Snapshot path is not configured")
+	}
+	config.Format = format
+	f := &File{
+		config:         config,
+		nameSeparator:  nameSeparator,
+		tagLabelPrefix: tagLabelPrefix,
+		instances:      make(map[service.ServiceId]*Instance),
+	}
+	return f, f.load()
+}
+
+func (f *File) GetAllServices() ([]*service.Service, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	var services []*service.Service
+	for _, i := range sortedInstances(f.instances) {
+		services = append(services, instanceToService(i))
+	}
+	return services, nil
+}
+
+func (f *File) GetServices(name string) ([]*service.Service, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	var services []*service.Service
+	for _, i := range sortedInstances(f.instances) {
+		if i.Name == name {
+			services = append(services, instanceToService(i))
+		}
+	}
+	return services, nil
+}
+
+func (f *File) Register(task *apps.Task, app *apps.App) error {
+	var err error
+	metrics.Time("file.register", func() { err = f.register(task, app) })
+	if err != nil {
+		metrics.Mark("file.register.error")
+	} else {
+		metrics.Mark("file.register.success")
+	}
+	return err
+}
+
+func (f *File) register(task *apps.Task, app *apps.App) error {
+	instances, err := f.taskInstances(task, app)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.update(func(registered map[service.ServiceId]*Instance) {
+		for _, i := range instances {
+			log.WithFields(log.Fields{
+				"Name":    i.Name,
+				"Id":      i.ID,
+				"Tags":    i.Tags,
+				"Address": i.Address,
+				"Port":    i.Port,
+			}).Info("Registering")
+			registered[service.ServiceId(i.ID)] = i
+		}
+	})
+}
+
+func (f *File) ExpectedServices(task *apps.Task, app *apps.App) ([]*service.Service, error) {
+	instances, err := f.taskInstances(task, app)
+	if err != nil {
+		return nil, err
+	}
+	var services []*service.Service
+	for _, i := range instances {
+		services = append(services, instanceToService(i))
+	}
+	return services, nil
+}
+
+func (f *File) taskInstances(task *apps.Task, app *apps.App) ([]*Instance, error) {
+	IP, err := utils.HostToIPv4(task.Host)
+	if err != nil {
+		return nil, err
+	}
+	var instances []*Instance
+	for _, intent := range app.RegistrationIntents(task, f.nameSeparator, f.tagLabelPrefix) {
+		instances = append(instances, &Instance{
+			ID:      fmt.Sprintf("%s_%s_%d", task.ID, intent.Name, intent.Port),
+			Name:    intent.Name,
+			Address: IP.String(),
+			Port:    intent.Port,
+			Tags:    append(append([]string{}, intent.Tags...), service.MarathonTaskTag(task.ID)),
+		})
+	}
+	return instances, nil
+}
+
+func (f *File) DeregisterByTask(taskID apps.TaskID) error {
+	services, _ := f.GetAllServices()
+	var deregisterErrors []error
+	found := false
+	for _, s := range services {
+		if id, err := s.TaskId(); err != nil || id != taskID {
+			continue
+		}
+		found = true
+		if err := f.Deregister(s); err != nil {
+			deregisterErrors = append(deregisterErrors, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("Couldn't find any service matching task id %s", taskID)
+	}
+	return utils.MergeErrorsOrNil(deregisterErrors, fmt.Sprintf("deregistering by task %s", taskID))
+}
+
+func (f *File) Deregister(toDeregister *service.Service) error {
+	var err error
+	metrics.Time("file.deregister", func() { err = f.deregister(toDeregister) })
+	if err != nil {
+		metrics.Mark("file.deregister.error")
+	} else {
+		metrics.Mark("file.deregister.success")
+	}
+	return err
+}
+
+func (f *File) deregister(toDeregister *service.Service) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	log.WithField("Id", toDeregister.ID).WithField("Address", toDeregister.RegisteringAgentAddress).Info("Deregistering")
+	return f.update(func(registered map[service.ServiceId]*Instance) {
+		delete(registered, toDeregister.ID)
+	})
+}
+
+func (f *File) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	return errors.New("Maintenance mode is not supported by file registry")
+}
+
+func (f *File) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	return errors.New("Maintenance mode is not supported by file registry")
+}
+
+func (f *File) DisableMaintenance(toMaintain *service.Service) error {
+	return errors.New("Maintenance mode is not supported by file registry")
+}
+
+func (f *File) load() error {
+	blob, err := ioutil.ReadFile(f.config.Path)
+	if os.IsNotExist(err) {
+		log.WithField("Path", f.config.Path).Info("Snapshot does not exist yet, starting with empty registry")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	snapshot := &Snapshot{}
+	if f.config.Format == "yaml" {
+		err = yaml.Unmarshal(blob, snapshot)
+	} else {
+		err = json.Unmarshal(blob, snapshot)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not parse snapshot %s: %v", f.config.Path, err)
+	}
+	for _, i := range snapshot.Services {
+		f.instances[service.ServiceId(i.ID)] = i
+	}
+	log.WithField("Path", f.config.Path).WithField("Services", len(f.instances)).Info("Restored registrations from snapshot")
+	return nil
+}
+
+// update applies the change to a copy of the registrations, which replaces them only once its snapshot is saved,
+// so the registry never holds registrations missing from the file. It must be called with the lock held.
+func (f *File) update(change func(registered map[service.ServiceId]*Instance)) error {
+	instances := make(map[service.ServiceId]*Instance, len(f.instances))
+	for id, i := range f.instances {
+		instances[id] = i
+	}
+	change(instances)
+	if err := f.save(instances); err != nil {
+		return err
+	}
+	f.instances = instances
+	return nil
+}
+
+// save writes the snapshot to a temporary file and renames it, so readers never see partial content
+func (f *File) save(instances map[service.ServiceId]*Instance) error {
+	snapshot := Snapshot{Services: sortedInstances(instances)}
+	var blob []byte
+	var err error
+	if f.config.Format == "yaml" {
+		blob, err = yaml.Marshal(snapshot)
+	} else {
+		blob, err = json.MarshalIndent(snapshot, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.config.Path), filepath.Base(f.config.Path))
+	if err != nil {
+		log.WithError(err).WithField("Path", f.config.Path).Error("Unable to write snapshot")
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(blob); err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.config.Path)
+	}
+	if err != nil {
+		log.WithError(err).WithField("Path", f.config.Path).Error("Unable to write snapshot")
+	}
+	return err
+}
+
+func sortedInstances(registered map[service.ServiceId]*Instance) []*Instance {
+	ids := make([]string, 0, len(registered))
+	for id := range registered {
+		ids = append(ids, id.String())
+	}
+	sort.Strings(ids)
+	instances := make([]*Instance, 0, len(ids))
+	for _, id := range ids {
+		instances = append(instances, registered[service.ServiceId(id)])
+	}
+	return instances
+}
+
+func instanceToService(i *Instance) *service.Service {
+	return &service.Service{
+		ID:                      service.ServiceId(i.ID),
+		Name:                    i.Name,
+		Tags:                    i.Tags,
+		RegisteringAgentAddress: i.Address,
+		Address:                 i.Address,
+		Port:                    i.Port,
+	}
+}
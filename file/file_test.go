@@ -0,0 +1,197 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_ShouldFailOnUnknownFormat(t *testing.T) {
+	t.Parallel()
+	// when
+	_, err := New(Config{Path: "services.xml", Format: "xml"}, ".", "consul-tag-")
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestNew_ShouldFailOnMissingPath(t *testing.T) {
+	t.Parallel()
+	// when
+	_, err := New(Config{Format: "json"}, ".", "consul-tag-")
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestNew_ShouldFailOnMalformedSnapshot(t *testing.T) {
+	t.Parallel()
+	// given
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.json")
+	ioutil.WriteFile(path, []byte("not a json"), 0644)
+
+	// when
+	_, err := New(Config{Path: path, Format: "json"}, ".", "consul-tag-")
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestRegister_ShouldWriteJsonSnapshot(t *testing.T) {
+	t.Parallel()
+	// given
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.json")
+	registry, err := New(Config{Path: path, Format: "JSON"}, ".", "consul-tag-")
+	assert.NoError(t, err)
+	app := utils.ConsulApp("/test/app", 1)
+	app.Labels["public"] = "tag"
+
+	// when
+	err = registry.Register(&app.Tasks[0], app)
+
+	// then
+	assert.NoError(t, err)
+	blob, _ := ioutil.ReadFile(path)
+	assert.JSONEq(t, `{"services": [{
+		"id": "test_app.0_test.app_8080",
+		"name": "test.app",
+		"address": "127.0.0.1",
+		"port": 8080,
+		"tags": ["public", "marathon-task:test_app.0"]
+	}]}`, string(blob))
+}
+
+func TestRegister_ShouldWriteYamlSnapshot(t *testing.T) {
+	t.Parallel()
+	// given
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.yml")
+	registry, _ := New(Config{Path: path, Format: "yaml"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+
+	// when
+	err := registry.Register(&app.Tasks[0], app)
+
+	// then
+	assert.NoError(t, err)
+	blob, _ := ioutil.ReadFile(path)
+	assert.Equal(t, `services:
+- id: test_app.0_test.app_8080
+  name: test.app
+  address: 127.0.0.1
+  port: 8080
+  tags:
+  - marathon-task:test_app.0
+`, string(blob))
+}
+
+func TestRegister_ForInvalidHost(t *testing.T) {
+	t.Parallel()
+	// given
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	registry, _ := New(Config{Path: filepath.Join(dir, "services.json"), Format: "json"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	app.Tasks[0].Host = "invalid.host.local"
+
+	// when
+	err := registry.Register(&app.Tasks[0], app)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestFile_ShouldRestoreRegistrationsFromSnapshot(t *testing.T) {
+	t.Parallel()
+	// given
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.yml")
+	registry, _ := New(Config{Path: path, Format: "yaml"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 3)
+	for _, task := range app.Tasks {
+		registry.Register(&task, app)
+	}
+
+	// when
+	restored, err := New(Config{Path: path, Format: "yaml"}, ".", "consul-tag-")
+
+	// then
+	assert.NoError(t, err)
+	services, _ := restored.GetServices("test.app")
+	assert.Len(t, services, 3)
+	assert.Equal(t, "127.0.0.1", services[0].RegisteringAgentAddress)
+	taskID, _ := services[2].TaskId()
+	assert.Equal(t, app.Tasks[2].ID, taskID)
+}
+
+func TestDeregister(t *testing.T) {
+	t.Parallel()
+	// given
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	registry, _ := New(Config{Path: filepath.Join(dir, "services.json"), Format: "json"}, ".", "consul-tag-")
+	app := utils.ConsulAppMultipleRegistrations("/test/app", 2, 2)
+	for _, task := range app.Tasks {
+		registry.Register(&task, app)
+	}
+
+	// when
+	err := registry.DeregisterByTask(app.Tasks[0].ID)
+
+	// then
+	assert.NoError(t, err)
+	services, _ := registry.GetAllServices()
+	assert.Len(t, services, 2)
+
+	// when
+	err = registry.DeregisterByTask(app.Tasks[0].ID)
+
+	// then
+	assert.Error(t, err)
+
+	// when
+	err = registry.Deregister(&service.Service{ID: services[0].ID})
+
+	// then
+	assert.NoError(t, err)
+	services, _ = registry.GetAllServices()
+	assert.Len(t, services, 1)
+}
+
+func TestFile_ShouldKeepRegistrationsWhenSnapshotCanNotBeSaved(t *testing.T) {
+	t.Parallel()
+	// given
+	dir := tempDir(t)
+	registry, _ := New(Config{Path: filepath.Join(dir, "services.json"), Format: "json"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 2)
+	registry.Register(&app.Tasks[0], app)
+	registered, _ := registry.GetAllServices()
+	os.RemoveAll(dir)
+
+	// when
+	registerErr := registry.Register(&app.Tasks[1], app)
+	deregisterErr := registry.Deregister(registered[0])
+
+	// then
+	assert.Error(t, registerErr)
+	assert.Error(t, deregisterErr)
+	services, _ := registry.GetAllServices()
+	assert.Equal(t, registered, services)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "marathon-consul")
+	assert.NoError(t, err)
+	return dir
+}
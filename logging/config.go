@@ -0,0 +1,21 @@
+package logging
+
+import "github.com/allegro/marathon-consul/time"
+
+// Rotation configures when a file is rotated and how many rotated files are kept
+type Rotation struct {
+	// MaxSize in megabytes the file is rotated at, 0 disables rotation by size
+	MaxSize int64
+	// Interval the file is rotated every, 0 disables rotation by time
+	Interval time.Interval
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int
+	// MaxAge rotated files are removed after, 0 keeps them until MaxBackups is exceeded
+	MaxAge time.Interval
+}
+
+type Syslog struct {
+	// Address of the syslog server as udp://host:port, tcp://host:port or unix:///path, empty disables syslog
+	Address  string
+	Facility string
+}
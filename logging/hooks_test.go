@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogHook_SendsRFC5424MessagesOverUDP(t *testing.T) {
+	t.Parallel()
+	// given
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	hook, err := NewSyslogHook(Syslog{Address: "udp://" + server.LocalAddr().String(), Facility: "local0"})
+	require.NoError(t, err)
+
+	// when
+	err = hook.Fire(entry(log.WarnLevel, "Message"))
+
+	// then
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	require.NoError(t, err)
+	message := string(buf[:n])
+	assert.True(t, strings.HasPrefix(message, "<132>1 2016-01-02T03:04:05Z "), message)
+	assert.Contains(t, message, " marathon-consul ")
+	assert.True(t, strings.HasSuffix(message, " - - level=warning msg=Message Id=1"), message)
+}
+
+func TestSyslogHook_FramesMessagesOverTCP(t *testing.T) {
+	t.Parallel()
+	// given
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	hook, err := NewSyslogHook(Syslog{Address: "tcp://" + server.Addr().String(), Facility: "daemon"})
+	require.NoError(t, err)
+	conn, err := server.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// when
+	err = hook.Fire(entry(log.ErrorLevel, "Message"))
+
+	// then
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	length, err := reader.ReadString(' ')
+	require.NoError(t, err)
+	message, err := reader.ReadString('1')
+	require.NoError(t, err)
+	assert.NotEqual(t, "0 ", length)
+	assert.Equal(t, "<27>1", message)
+}
+
+func TestNewSyslogHook_FailsForInvalidConfig(t *testing.T) {
+	t.Parallel()
+	for _, config := range []Syslog{
+		{Address: "http://localhost:514", Facility: "local0"},
+		{Address: "udp://", Facility: "local0"},
+		{Address: "unix://", Facility: "local0"},
+		{Address: "udp://localhost:514", Facility: "unknown"},
+	} {
+		// when
+		_, err := NewSyslogHook(config)
+
+		// then
+		assert.Error(t, err, config.Address)
+	}
+}
+
+func TestJournaldHook_SendsFields(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, _ := ioutil.TempDir("", "logging")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "journal.socket")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer server.Close()
+	hook, err := newJournaldHook(socket)
+	require.NoError(t, err)
+	e := entry(log.ErrorLevel, "Message")
+	e.Data["error"] = errors.New("first\nsecond")
+	e.Data["_app.id"] = "/app"
+
+	// when
+	err = hook.Fire(e)
+
+	// then
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	message := string(buf[:n])
+	assert.Contains(t, message, "MESSAGE=Message\n")
+	assert.Contains(t, message, "PRIORITY=3\n")
+	assert.Contains(t, message, "SYSLOG_IDENTIFIER=marathon-consul\n")
+	assert.Contains(t, message, "ID=1\n")
+	assert.Contains(t, message, "APP_ID=/app\n")
+	assert.Contains(t, message, "ERROR\n\x0c\x00\x00\x00\x00\x00\x00\x00first\nsecond\n")
+}
+
+func entry(level log.Level, message string) *log.Entry {
+	logger := log.New()
+	logger.Formatter = &log.TextFormatter{DisableTimestamp: true, DisableColors: true}
+	e := log.NewEntry(logger).WithField("Id", 1)
+	e.Level = level
+	e.Message = message
+	e.Time = time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	return e
+}
@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+// JournaldHook sends log entries to the journal using its native protocol. Fields of the entry are sent
+// as journal fields with names uppercased and characters other than letters, digits and underscores replaced.
+type JournaldHook struct {
+	sync.Mutex
+	socket string
+	conn   net.Conn
+}
+
+func NewJournaldHook() (*JournaldHook, error) {
+	return newJournaldHook(journaldSocket)
+}
+
+func newJournaldHook(socket string) (*JournaldHook, error) {
+	hook := &JournaldHook{socket: socket}
+	if err := hook.connect(); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (h *JournaldHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire sends the entry, connecting again once when sending fails
+func (h *JournaldHook) Fire(entry *log.Entry) error {
+	message := journalMessage(entry)
+
+	h.Lock()
+	defer h.Unlock()
+	if _, err := h.conn.Write(message); err == nil {
+		return nil
+	}
+	if err := h.connect(); err != nil {
+		return err
+	}
+	_, err := h.conn.Write(message)
+	return err
+}
+
+func (h *JournaldHook) connect() error {
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	conn, err := net.Dial("unixgram", h.socket)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+func journalMessage(entry *log.Entry) []byte {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", entry.Message)
+	writeJournalField(&buf, "PRIORITY", fmt.Sprint(severities[entry.Level]))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", appName)
+	for key, value := range entry.Data {
+		name := journalFieldName(key)
+		if name == "" {
+			continue
+		}
+		if err, ok := value.(error); ok {
+			writeJournalField(&buf, name, err.Error())
+		} else {
+			writeJournalField(&buf, name, fmt.Sprint(value))
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeJournalField writes the field as KEY=value, values with new lines are written as KEY, the length
+// of the value as a little endian 64 bit integer and the value
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalFieldName returns the name of the journal field, fields starting with an underscore are trusted
+// fields set by the journal so leading underscores are removed
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, key)
+	return strings.TrimLeft(name, "_")
+}
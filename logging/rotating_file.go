@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/allegro/marathon-consul/utils"
+)
+
+// RotatingFile appends to the file, which is rotated once it would grow beyond the maximum size or the rotation
+// interval passes: the file is renamed to <path>.1, older rotated files are shifted and the ones beyond
+// the maximum number or age are removed. Files open are reopened by Reopen, e.g. after an external tool moved them.
+type RotatingFile struct {
+	sync.Mutex
+	path     string
+	rotation Rotation
+	// maximum size in bytes, 0 disables rotation by size
+	maxSize  int64
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+var openFiles = struct {
+	sync.Mutex
+	files map[*RotatingFile]struct{}
+}{files: make(map[*RotatingFile]struct{})}
+
+func OpenRotatingFile(path string, rotation Rotation) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, rotation: rotation, maxSize: rotation.MaxSize * 1024 * 1024}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	openFiles.Lock()
+	defer openFiles.Unlock()
+	openFiles.files[f] = struct{}{}
+	return f, nil
+}
+
+// Reopen closes and opens again all rotating files open
+func Reopen() error {
+	openFiles.Lock()
+	defer openFiles.Unlock()
+	var errs []error
+	for f := range openFiles.files {
+		if err := f.Reopen(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(errs, "reopening log files")
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens the file at its path again
+func (f *RotatingFile) Reopen() error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Close() error {
+	openFiles.Lock()
+	delete(openFiles.files, f)
+	openFiles.Unlock()
+
+	f.Lock()
+	defer f.Unlock()
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *RotatingFile) shouldRotate(write int) bool {
+	if f.size == 0 {
+		return false
+	}
+	return (f.maxSize > 0 && f.size+int64(write) > f.maxSize) ||
+		(f.rotation.Interval.Duration > 0 && time.Since(f.openedAt) >= f.rotation.Interval.Duration)
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.rotation.MaxBackups > 0 {
+		os.Remove(f.backup(f.rotation.MaxBackups))
+		for i := f.rotation.MaxBackups - 1; i > 0; i-- {
+			os.Rename(f.backup(i), f.backup(i+1))
+		}
+		if err := os.Rename(f.path, f.backup(1)); err != nil {
+			return err
+		}
+		f.removeExpiredBackups()
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) removeExpiredBackups() {
+	if f.rotation.MaxAge.Duration <= 0 {
+		return
+	}
+	for i := 1; i <= f.rotation.MaxBackups; i++ {
+		if info, err := os.Stat(f.backup(i)); err == nil && time.Since(info.ModTime()) > f.rotation.MaxAge.Duration {
+			os.Remove(f.backup(i))
+		}
+	}
+}
+
+func (f *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
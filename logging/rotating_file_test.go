@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	timeutil "github.com/allegro/marathon-consul/time"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile_KeepsBackups(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, _ := ioutil.TempDir("", "logging")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	file, err := OpenRotatingFile(path, Rotation{MaxBackups: 2})
+	require.NoError(t, err)
+	file.maxSize = 10
+
+	// when
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	file.Close()
+
+	// then
+	current, _ := ioutil.ReadFile(path)
+	first, _ := ioutil.ReadFile(path + ".1")
+	second, _ := ioutil.ReadFile(path + ".2")
+	assert.Equal(t, "fourth\n", string(current))
+	assert.Equal(t, "third\n", string(first))
+	assert.Equal(t, "second\n", string(second))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFile_RotatesEveryInterval(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, _ := ioutil.TempDir("", "logging")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	file, err := OpenRotatingFile(path, Rotation{Interval: timeutil.Interval{Duration: time.Hour}, MaxBackups: 1})
+	require.NoError(t, err)
+	defer file.Close()
+	file.Write([]byte("first\n"))
+
+	// when
+	file.openedAt = time.Now().Add(-2 * time.Hour)
+	file.Write([]byte("second\n"))
+
+	// then
+	current, _ := ioutil.ReadFile(path)
+	first, _ := ioutil.ReadFile(path + ".1")
+	assert.Equal(t, "second\n", string(current))
+	assert.Equal(t, "first\n", string(first))
+}
+
+func TestRotatingFile_RemovesExpiredBackups(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, _ := ioutil.TempDir("", "logging")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	file, err := OpenRotatingFile(path, Rotation{MaxBackups: 2, MaxAge: timeutil.Interval{Duration: time.Hour}})
+	require.NoError(t, err)
+	defer file.Close()
+	file.maxSize = 10
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, ioutil.WriteFile(path+".1", []byte("old\n"), 0640))
+	require.NoError(t, os.Chtimes(path+".1", old, old))
+
+	// when
+	file.Write([]byte("first\n"))
+	file.Write([]byte("second\n"))
+
+	// then
+	first, _ := ioutil.ReadFile(path + ".1")
+	assert.Equal(t, "first\n", string(first))
+	_, err = os.Stat(path + ".2")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReopen_ReopensMovedFiles(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, _ := ioutil.TempDir("", "logging")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	file, err := OpenRotatingFile(path, Rotation{})
+	require.NoError(t, err)
+	defer file.Close()
+	file.Write([]byte("first\n"))
+	require.NoError(t, os.Rename(path, path+".moved"))
+
+	// when
+	err = Reopen()
+	file.Write([]byte("second\n"))
+
+	// then
+	require.NoError(t, err)
+	current, _ := ioutil.ReadFile(path)
+	moved, _ := ioutil.ReadFile(path + ".moved")
+	assert.Equal(t, "second\n", string(current))
+	assert.Equal(t, "first\n", string(moved))
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Name the process logs as to syslog and journald
+const appName = "marathon-consul"
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severities maps log levels to syslog severities, journald priorities use the same values
+var severities = map[log.Level]int{
+	log.PanicLevel: 0,
+	log.FatalLevel: 2,
+	log.ErrorLevel: 3,
+	log.WarnLevel:  4,
+	log.InfoLevel:  6,
+	log.DebugLevel: 7,
+}
+
+// SyslogHook sends log entries to a syslog server as RFC 5424 messages formatted with the formatter of the logger.
+// Messages are sent as datagrams over UDP and unix sockets and with octet counting framing over TCP.
+type SyslogHook struct {
+	sync.Mutex
+	network  string
+	address  string
+	facility int
+	hostname string
+	conn     net.Conn
+}
+
+func NewSyslogHook(config Syslog) (*SyslogHook, error) {
+	network, address, err := ParseSyslogAddress(config.Address)
+	if err != nil {
+		return nil, err
+	}
+	facility, ok := facilities[strings.ToLower(config.Facility)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown syslog facility %s", config.Facility)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	hook := &SyslogHook{network: network, address: address, facility: facility, hostname: hostname}
+	if err := hook.connect(); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func IsSyslogFacility(name string) bool {
+	_, ok := facilities[strings.ToLower(name)]
+	return ok
+}
+
+// ParseSyslogAddress returns the network and address of udp://host:port, tcp://host:port or unix:///path
+func ParseSyslogAddress(address string) (network string, addr string, err error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return "", "", err
+	}
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		if parsed.Host == "" {
+			return "", "", fmt.Errorf("Syslog address %s has no host", address)
+		}
+		return parsed.Scheme, parsed.Host, nil
+	case "unix":
+		if parsed.Path == "" {
+			return "", "", fmt.Errorf("Syslog address %s has no path", address)
+		}
+		return "unixgram", parsed.Path, nil
+	default:
+		return "", "", fmt.Errorf("Syslog address %s is not one of udp://host:port, tcp://host:port and unix:///path", address)
+	}
+}
+
+func (h *SyslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire sends the entry, connecting again once when sending fails
+func (h *SyslogHook) Fire(entry *log.Entry) error {
+	formatted, err := entry.Logger.Formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	message := h.message(entry.Level, entry.Time, strings.TrimSpace(string(formatted)))
+
+	h.Lock()
+	defer h.Unlock()
+	if err := h.send(message); err == nil {
+		return nil
+	}
+	if err := h.connect(); err != nil {
+		return err
+	}
+	return h.send(message)
+}
+
+// message formats the RFC 5424 message: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (h *SyslogHook) message(level log.Level, at time.Time, msg string) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s", h.facility*8+severities[level],
+		at.Format(time.RFC3339Nano), h.hostname, appName, os.Getpid(), msg)
+}
+
+func (h *SyslogHook) send(message string) error {
+	if h.conn == nil {
+		return fmt.Errorf("Not connected to syslog at %s", h.address)
+	}
+	if h.network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	_, err := h.conn.Write([]byte(message))
+	return err
+}
+
+func (h *SyslogHook) connect() error {
+	if h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
+	}
+	conn, err := net.DialTimeout(h.network, h.address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/audit"
 	"github.com/allegro/marathon-consul/config"
 	"github.com/allegro/marathon-consul/consul"
+	"github.com/allegro/marathon-consul/etcd"
+	"github.com/allegro/marathon-consul/file"
 	"github.com/allegro/marathon-consul/marathon"
 	"github.com/allegro/marathon-consul/metrics"
 	"github.com/allegro/marathon-consul/sentry"
+	"github.com/allegro/marathon-consul/service"
 	"github.com/allegro/marathon-consul/sync"
 	"github.com/allegro/marathon-consul/web"
+	"github.com/allegro/marathon-consul/zookeeper"
 )
 
 var VERSION string
@@ -22,6 +30,12 @@ func main() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	if config.PrintRequested() {
+		if err := config.Print(os.Stdout); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
 
 	config.Log.Sentry.Release = VERSION
 	if sentryErr := sentry.Init(config.Log.Sentry); sentryErr != nil {
@@ -33,21 +47,118 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	consulInstance := consul.New(config.Consul)
-	remote, err := marathon.New(config.Marathon)
+	secrets, err := newSecretReader(config.Vault)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	if err := config.ResolveSecrets(secrets); err != nil {
+		log.Fatal(err.Error())
+	}
 
-	sync.New(config.Sync, remote, consulInstance, consulInstance.AddAgentsFromApps).StartSyncServicesJob()
+	serviceRegistry, syncStartedListener, err := newServiceRegistry(config)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(config.Clusters) > 0 && config.Registry != "consul" {
+		log.Fatalf("Multiple Marathon clusters are not supported by %s registry", config.Registry)
+	}
+	if config.Web.DrainDelay.Duration > 0 && config.Registry != "consul" {
+		log.Fatalf("Drain delay is not supported by %s registry", config.Registry)
+	}
 
-	handler, stop := web.NewHandler(config.Web, remote, consulInstance)
-	defer stop()
+	consulInstance, _ := serviceRegistry.(*consul.Consul)
+	reloader := newReloader(config, secrets, consulInstance)
+
+	var auditLog *audit.Log
+	if config.Audit.Enabled {
+		if auditLog, err = audit.New(config.Audit); err != nil {
+			log.Fatal(err.Error())
+		}
+		defer auditLog.Close()
+		http.HandleFunc("/audit", web.AuditHandler(auditLog))
+	}
+
+	for _, cluster := range config.MarathonClusters() {
+		handler, stop, err := startCluster(config, cluster, serviceRegistry, syncStartedListener, reloader, auditLog)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer stop()
+		http.HandleFunc(cluster.EventsPath(), handler)
+	}
 
-	// set up routes
 	http.HandleFunc("/health", web.HealthHandler)
-	http.HandleFunc("/events", handler)
+	http.HandleFunc("/status", web.StatusHandler(reloader.statuses))
+	http.HandleFunc("/reload", web.ReloadHandler(reloader.reload))
+	reloader.reloadOnSignal()
+	reopenLogsOnSignal()
+	reloader.refreshSecretsEvery(config.Secrets.RefreshInterval.Duration)
 
 	log.WithField("Port", config.Web.Listen).Info("Listening")
 	log.Fatal(http.ListenAndServe(config.Web.Listen, nil))
 }
+
+func newServiceRegistry(c *config.Config) (service.ServiceRegistry, func(apps []*apps.App), error) {
+	noopSyncStartedListener := func(apps []*apps.App) {}
+	log.WithField("Registry", c.Registry).Info("Using service registry")
+	switch c.Registry {
+	case "consul":
+		consulInstance := consul.New(c.Consul)
+		return consulInstance, consulInstance.AddAgentsFromApps, nil
+	case "etcd":
+		return etcd.New(c.Etcd, c.Consul.ConsulNameSeparator, c.Consul.ConsulTagLabelPrefix), noopSyncStartedListener, nil
+	case "zookeeper":
+		return zookeeper.New(c.Zookeeper, c.Consul.ConsulNameSeparator, c.Consul.ConsulTagLabelPrefix), noopSyncStartedListener, nil
+	case "file":
+		fileInstance, err := file.New(c.File, c.Consul.ConsulNameSeparator, c.Consul.ConsulTagLabelPrefix)
+		return fileInstance, noopSyncStartedListener, err
+	default:
+		return nil, nil, fmt.Errorf("Unknown service registry %s", c.Registry)
+	}
+}
+
+// startCluster starts syncing the Marathon cluster and returns the handler of its events.
+// Services of clusters listed in the configuration are registered with cluster tags, sharing the Consul agents cache.
+// Changes of registered services are recorded in the audit log unless it is nil.
+func startCluster(c *config.Config, cluster config.Cluster, serviceRegistry service.ServiceRegistry,
+	syncStartedListener func(apps []*apps.App), reloader *reloader, auditLog *audit.Log) (web.Handler, web.Stop, error) {
+
+	if consulInstance, ok := serviceRegistry.(*consul.Consul); ok && cluster.Name != "" {
+		consulInstance = consulInstance.WithTag(cluster.Tag)
+		serviceRegistry, syncStartedListener = consulInstance, consulInstance.AddAgentsFromApps
+	}
+	if auditLog != nil {
+		serviceRegistry = audit.NewRegistry(serviceRegistry, auditLog, cluster.Name)
+	}
+	log.WithField("Cluster", cluster.Name).WithField("Tag", cluster.Tag).
+		WithField("EventsPath", cluster.EventsPath()).Info("Starting Marathon cluster")
+
+	remote, err := marathon.New(cluster.Marathon)
+	if err != nil {
+		return nil, nil, err
+	}
+	unhealthyTasks, err := newUnhealthyTaskHandler(c.Registry, cluster.Sync, serviceRegistry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	marathonSync := sync.New(cluster.Sync, remote, serviceRegistry, syncStartedListener, unhealthyTasks)
+	marathonSync.StartSyncServicesJob()
+	reloader.addCluster(cluster.Name, reloadableCluster{
+		reconfigureSync: func(cluster config.Cluster) { marathonSync.Reconfigure(cluster.Sync) },
+		updateCredentials: func(cluster config.Cluster) error {
+			return remote.UpdateCredentials(cluster.Marathon)
+		},
+		updateRules: func(cluster config.Cluster) { remote.UpdateRules(cluster.Marathon) },
+	})
+
+	appCache := marathon.NewAppCache(remote, cluster.Marathon.AppCacheTTL.Duration, cluster.Marathon.MetricsScope)
+	return web.NewHandler(cluster.Web(c.Web), appCache, serviceRegistry, unhealthyTasks, marathonSync)
+}
+
+func newUnhealthyTaskHandler(registry string, c sync.Config, serviceRegistry service.ServiceRegistry) (*service.UnhealthyTaskHandler, error) {
+	if c.UnhealthyTasks == service.UnhealthyMaintenance && registry != "consul" {
+		return nil, fmt.Errorf("Unhealthy tasks action %s is not supported by %s registry", c.UnhealthyTasks, registry)
+	}
+	return service.NewUnhealthyTaskHandler(serviceRegistry, c.UnhealthyTasks, c.UnhealthyGracePeriod.Duration)
+}
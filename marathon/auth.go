@@ -0,0 +1,312 @@
+package marathon
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/metrics"
+)
+
+// ACS tokens are refreshed this long before they expire
+const tokenRefreshMargin = 5 * time.Minute
+
+// Lifetime of service account login tokens exchanged for ACS tokens
+const loginTokenLifetime = 5 * time.Minute
+
+// authenticator adds credentials to requests sent to Marathon
+type authenticator interface {
+	authorize(request *http.Request) error
+	// invalidate drops credentials rejected by Marathon, it returns false when they can not be renewed
+	invalidate() bool
+}
+
+func newAuthenticator(config Config, client *http.Client) (authenticator, error) {
+	configured := 0
+	for _, option := range []string{config.Token, config.TokenFile, config.DCOSServiceAccount} {
+		if option != "" {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, errors.New("Only one of Marathon token, token file and DC/OS service account can be configured")
+	}
+	if configured > 0 && (config.Username != "" || config.Password != "") {
+		return nil, errors.New("Marathon token auth can not be used together with basic auth")
+	}
+
+	switch {
+	case config.Token != "":
+		return staticToken("Bearer " + config.Token), nil
+	case config.TokenFile != "":
+		return &fileToken{path: config.TokenFile}, nil
+	case config.DCOSServiceAccount != "":
+		return newServiceAccount(config, client)
+	default:
+		return nil, nil
+	}
+}
+
+// credentials of Marathon requests, basic auth in URLs or tokens added by the authenticator.
+// They are shared by copies of Marathon and can be updated while running.
+type credentials struct {
+	sync.RWMutex
+	client *http.Client
+	basic  *url.Userinfo
+	token  authenticator
+}
+
+func newCredentials(config Config, client *http.Client) (*credentials, error) {
+	c := &credentials{client: client}
+	return c, c.update(config)
+}
+
+func (c *credentials) update(config Config) error {
+	token, err := newAuthenticator(config, c.client)
+	if err != nil {
+		return err
+	}
+	var basic *url.Userinfo
+	if len(config.Username) != 0 || len(config.Password) != 0 {
+		basic = url.UserPassword(config.Username, config.Password)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.basic = basic
+	c.token = token
+	return nil
+}
+
+func (c *credentials) basicAuth() *url.Userinfo {
+	c.RLock()
+	defer c.RUnlock()
+	return c.basic
+}
+
+func (c *credentials) authorize(request *http.Request) error {
+	c.RLock()
+	token := c.token
+	c.RUnlock()
+	if token == nil {
+		return nil
+	}
+	return token.authorize(request)
+}
+
+func (c *credentials) invalidate() bool {
+	c.RLock()
+	token := c.token
+	c.RUnlock()
+	return token != nil && token.invalidate()
+}
+
+type staticToken string
+
+func (t staticToken) authorize(request *http.Request) error {
+	request.Header.Set("Authorization", string(t))
+	return nil
+}
+
+func (t staticToken) invalidate() bool {
+	return false
+}
+
+// fileToken reads the bearer token from the file and reads it again whenever the file changes
+type fileToken struct {
+	sync.Mutex
+	path     string
+	token    string
+	modified time.Time
+}
+
+func (t *fileToken) authorize(request *http.Request) error {
+	t.Lock()
+	defer t.Unlock()
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return fmt.Errorf("Unable to read Marathon token: %s", err)
+	}
+	if t.token == "" || !info.ModTime().Equal(t.modified) {
+		token, err := ioutil.ReadFile(t.path)
+		if err != nil {
+			return fmt.Errorf("Unable to read Marathon token: %s", err)
+		}
+		log.WithField("File", t.path).Info("Read Marathon token")
+		t.token = strings.TrimSpace(string(token))
+		t.modified = info.ModTime()
+	}
+	request.Header.Set("Authorization", "Bearer "+t.token)
+	return nil
+}
+
+func (t *fileToken) invalidate() bool {
+	t.Lock()
+	defer t.Unlock()
+	// the file might have been replaced within the modification time resolution
+	t.token = ""
+	return true
+}
+
+// serviceAccount logs in to DC/OS with a login token signed with the service account private key
+// and authorizes requests with the ACS token it gets in exchange
+type serviceAccount struct {
+	sync.Mutex
+	uid      string
+	key      *rsa.PrivateKey
+	loginURL string
+	client   *http.Client
+	token    string
+	expires  time.Time
+	metrics  metrics.Scope
+}
+
+func newServiceAccount(config Config, client *http.Client) (*serviceAccount, error) {
+	pemKey, err := ioutil.ReadFile(config.DCOSPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read DC/OS service account private key: %s", err)
+	}
+	key, err := parsePrivateKey(pemKey)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceAccount{
+		uid:      config.DCOSServiceAccount,
+		key:      key,
+		loginURL: config.DCOSLoginURL,
+		client:   client,
+		metrics:  config.MetricsScope,
+	}, nil
+}
+
+func parsePrivateKey(pemKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("DC/OS service account private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse DC/OS service account private key: %s", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("DC/OS service account private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+func (s *serviceAccount) authorize(request *http.Request) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.token == "" || time.Now().Add(tokenRefreshMargin).After(s.expires) {
+		if err := s.login(); err != nil {
+			return err
+		}
+	}
+	request.Header.Set("Authorization", "token="+s.token)
+	return nil
+}
+
+func (s *serviceAccount) invalidate() bool {
+	s.Lock()
+	defer s.Unlock()
+	s.token = ""
+	return true
+}
+
+type loginRequest struct {
+	UID   string `json:"uid"`
+	Token string `json:"token"`
+}
+
+type loginResponse struct {
+	Token string `json:"token"`
+}
+
+func (s *serviceAccount) login() error {
+	log.WithField("Uid", s.uid).WithField("LoginURL", s.loginURL).Info("Logging in to DC/OS")
+	loginToken, err := s.loginToken(time.Now().Add(loginTokenLifetime))
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(loginRequest{UID: s.uid, Token: loginToken})
+
+	var response *http.Response
+	s.metrics.Time("marathon.auth.login", func() {
+		response, err = s.client.Post(s.loginURL, "application/json", bytes.NewReader(body))
+	})
+	if err != nil {
+		s.metrics.Mark("marathon.auth.login.error")
+		return fmt.Errorf("Unable to log in to DC/OS: %s", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		s.metrics.Mark("marathon.auth.login.error")
+		return fmt.Errorf("Unable to log in to DC/OS: expected 200 but got %d", response.StatusCode)
+	}
+	login := &loginResponse{}
+	if err := json.NewDecoder(response.Body).Decode(login); err != nil || login.Token == "" {
+		s.metrics.Mark("marathon.auth.login.error")
+		return fmt.Errorf("Unable to log in to DC/OS: malformed response")
+	}
+
+	s.token = login.Token
+	s.expires = tokenExpiry(login.Token)
+	log.WithField("Uid", s.uid).WithField("Expires", s.expires).Info("Logged in to DC/OS")
+	return nil
+}
+
+type jwtClaims struct {
+	UID string `json:"uid"`
+	Exp int64  `json:"exp"`
+}
+
+// loginToken returns a JWT signed with RS256, as expected by the DC/OS login endpoint
+func (s *serviceAccount) loginToken(expires time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(jwtClaims{UID: s.uid, Exp: expires.Unix()})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("Unable to sign DC/OS login token: %s", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// tokenExpiry returns expiry of the JWT, tokens without expiry are treated as expiring in an hour
+func tokenExpiry(token string) time.Time {
+	defaultExpiry := time.Now().Add(time.Hour)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return defaultExpiry
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return defaultExpiry
+	}
+	claims := &jwtClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil || claims.Exp == 0 {
+		return defaultExpiry
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,261 @@
+package marathon
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuthenticator_NoTokenAuth(t *testing.T) {
+	t.Parallel()
+
+	// when
+	auth, err := newAuthenticator(Config{Username: "peter", Password: "parker"}, http.DefaultClient)
+
+	// then
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+}
+
+func TestNewAuthenticator_RejectsMultipleAuthMethods(t *testing.T) {
+	t.Parallel()
+
+	// when
+	_, tokensErr := newAuthenticator(Config{Token: "token", TokenFile: "/token"}, http.DefaultClient)
+	_, basicErr := newAuthenticator(Config{Token: "token", Username: "peter"}, http.DefaultClient)
+
+	// then
+	assert.Error(t, tokensErr)
+	assert.Error(t, basicErr)
+}
+
+func TestStaticToken(t *testing.T) {
+	t.Parallel()
+
+	// given
+	auth, _ := newAuthenticator(Config{Token: "secret"}, http.DefaultClient)
+	request, _ := http.NewRequest("GET", "http://marathon/v2/apps", nil)
+
+	// when
+	err := auth.authorize(request)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer secret", request.Header.Get("Authorization"))
+	assert.False(t, auth.invalidate())
+}
+
+func TestFileToken_ReadAgainWhenFileChanges(t *testing.T) {
+	t.Parallel()
+
+	// given
+	file, err := ioutil.TempFile("", "marathon-token")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	require.NoError(t, ioutil.WriteFile(file.Name(), []byte("first\n"), 0600))
+	auth, _ := newAuthenticator(Config{TokenFile: file.Name()}, http.DefaultClient)
+	request, _ := http.NewRequest("GET", "http://marathon/v2/apps", nil)
+	auth.authorize(request)
+
+	// when
+	require.NoError(t, ioutil.WriteFile(file.Name(), []byte("second\n"), 0600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file.Name(), later, later))
+	err = auth.authorize(request)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer second", request.Header.Get("Authorization"))
+}
+
+func TestFileToken_MissingFile(t *testing.T) {
+	t.Parallel()
+
+	// given
+	auth, _ := newAuthenticator(Config{TokenFile: "/not/existing/token"}, http.DefaultClient)
+	request, _ := http.NewRequest("GET", "http://marathon/v2/apps", nil)
+
+	// when
+	err := auth.authorize(request)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestServiceAccount_LogsInAndReusesToken(t *testing.T) {
+	t.Parallel()
+
+	// given
+	login := newLoginStub(t, false)
+	defer login.Close()
+	auth, err := newAuthenticator(login.config(), http.DefaultClient)
+	require.NoError(t, err)
+	request, _ := http.NewRequest("GET", "http://marathon/v2/apps", nil)
+
+	// when
+	err1 := auth.authorize(request)
+	err2 := auth.authorize(request)
+
+	// then
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Equal(t, "token="+login.token(1), request.Header.Get("Authorization"))
+	assert.Equal(t, int32(1), login.logins())
+}
+
+func TestServiceAccount_RefreshesTokenBeforeExpiry(t *testing.T) {
+	t.Parallel()
+
+	// given
+	login := newLoginStub(t, true)
+	defer login.Close()
+	auth, _ := newAuthenticator(login.config(), http.DefaultClient)
+	request, _ := http.NewRequest("GET", "http://marathon/v2/apps", nil)
+	auth.authorize(request)
+
+	// when
+	err := auth.authorize(request)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "token="+login.token(2), request.Header.Get("Authorization"))
+}
+
+func TestServiceAccount_FailedLogin(t *testing.T) {
+	t.Parallel()
+
+	// given
+	login := newLoginStub(t, false)
+	defer login.Close()
+	config := login.config()
+	config.DCOSServiceAccount = "unknown"
+	auth, _ := newAuthenticator(config, http.DefaultClient)
+	request, _ := http.NewRequest("GET", "http://marathon/v2/apps", nil)
+
+	// when
+	err := auth.authorize(request)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestMarathon_RenewsRejectedToken(t *testing.T) {
+	t.Parallel()
+
+	// given
+	login := newLoginStub(t, false)
+	defer login.Close()
+	calls := 0
+	marathon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "token="+login.token(2) {
+			w.WriteHeader(401)
+			return
+		}
+		fmt.Fprintln(w, `{"leader": "marathon:8080"}`)
+	}))
+	defer marathon.Close()
+	config := login.config()
+	marathonURL, _ := url.Parse(marathon.URL)
+	config.Location = marathonURL.Host
+	config.Protocol = "http"
+	m, err := New(config)
+	require.NoError(t, err)
+
+	// when
+	leader, err := m.Leader()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "marathon:8080", leader)
+	assert.Equal(t, 2, calls)
+}
+
+// loginStub stands in for the DC/OS login endpoint. It verifies login tokens
+// and returns tokens numbered with subsequent logins.
+type loginStub struct {
+	*httptest.Server
+	keyFile  string
+	expiring bool
+	count    int32
+}
+
+func newLoginStub(t *testing.T, expiring bool) *loginStub {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	keyFile, err := ioutil.TempFile("", "dcos-key")
+	require.NoError(t, err)
+	pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	keyFile.Close()
+
+	stub := &loginStub{keyFile: keyFile.Name(), expiring: expiring}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &loginRequest{}
+		json.NewDecoder(r.Body).Decode(request)
+		if r.URL.Path != "/acs/api/v1/auth/login" || request.UID != "marathon-consul" || !validLoginToken(&key.PublicKey, request.Token) {
+			w.WriteHeader(401)
+			return
+		}
+		count := atomic.AddInt32(&stub.count, 1)
+		json.NewEncoder(w).Encode(loginResponse{Token: stub.token(count)})
+	}))
+	return stub
+}
+
+func (s *loginStub) Close() {
+	s.Server.Close()
+	os.Remove(s.keyFile)
+}
+
+func (s *loginStub) config() Config {
+	return Config{
+		DCOSServiceAccount: "marathon-consul",
+		DCOSPrivateKey:     s.keyFile,
+		DCOSLoginURL:       s.URL + "/acs/api/v1/auth/login",
+	}
+}
+
+func (s *loginStub) logins() int32 {
+	return atomic.LoadInt32(&s.count)
+}
+
+// token returns token issued with n-th login, expiring stub issues already expired tokens
+func (s *loginStub) token(n int32) string {
+	expires := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	if s.expiring {
+		expires = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	claims, _ := json.Marshal(map[string]interface{}{"uid": "marathon-consul", "exp": expires.Unix(), "n": n})
+	return "header." + base64.RawURLEncoding.EncodeToString(claims) + ".signature"
+}
+
+func validLoginToken(key *rsa.PublicKey, token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+}
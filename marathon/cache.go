@@ -0,0 +1,93 @@
+package marathon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/metrics"
+)
+
+// AppCache shares app lookups between concurrent callers. Callers asking for the same app while it is being
+// fetched wait for that fetch instead of sending their own request, and fetched apps are kept for the TTL.
+// Returned apps are shared and must not be modified.
+type AppCache struct {
+	Marathoner
+	sync.Mutex
+	ttl     time.Duration
+	fetches map[apps.AppID]*appFetch
+	metrics metrics.Scope
+}
+
+type appFetch struct {
+	started time.Time
+	done    chan struct{}
+	app     *apps.App
+	err     error
+}
+
+func NewAppCache(marathon Marathoner, ttl time.Duration, scope metrics.Scope) *AppCache {
+	return &AppCache{
+		Marathoner: marathon,
+		ttl:        ttl,
+		fetches:    make(map[apps.AppID]*appFetch),
+		metrics:    scope,
+	}
+}
+
+// App returns the app fetched not earlier than TTL ago
+func (c *AppCache) App(appID apps.AppID) (*apps.App, error) {
+	return c.AppSince(appID, time.Now().Add(-c.ttl))
+}
+
+// AppSince returns the app fetched not earlier than since, so it reflects all changes Marathon reported before.
+// It fetches the app only when there is no such fetch completed or in progress.
+func (c *AppCache) AppSince(appID apps.AppID, since time.Time) (*apps.App, error) {
+	c.Lock()
+	if fetch, ok := c.fetches[appID]; ok && !fetch.started.Before(since) {
+		c.Unlock()
+		select {
+		case <-fetch.done:
+			c.metrics.Mark("marathon.app.cache.hit")
+		default:
+			c.metrics.Mark("marathon.app.cache.coalesced")
+			<-fetch.done
+		}
+		return fetch.app, fetch.err
+	}
+	now := time.Now()
+	c.expire(now)
+	fetch := &appFetch{started: now, done: make(chan struct{})}
+	c.fetches[appID] = fetch
+	c.Unlock()
+
+	c.metrics.Mark("marathon.app.cache.miss")
+	fetch.app, fetch.err = c.Marathoner.App(appID)
+	if fetch.err != nil {
+		// callers waiting for the fetch get the error, later ones retry
+		c.forget(appID, fetch)
+	}
+	close(fetch.done)
+	return fetch.app, fetch.err
+}
+
+func (c *AppCache) forget(appID apps.AppID, fetch *appFetch) {
+	c.Lock()
+	defer c.Unlock()
+	if c.fetches[appID] == fetch {
+		delete(c.fetches, appID)
+	}
+}
+
+// expire removes completed fetches older than TTL, must be called with the lock held
+func (c *AppCache) expire(now time.Time) {
+	for appID, fetch := range c.fetches {
+		select {
+		case <-fetch.done:
+			if now.Sub(fetch.started) > c.ttl {
+				delete(c.fetches, appID)
+			}
+		default:
+		}
+	}
+}
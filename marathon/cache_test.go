@@ -0,0 +1,129 @@
+package marathon
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingMarathoner struct {
+	MarathonerStub
+	calls   int32
+	release chan struct{}
+	err     error
+}
+
+func (m *countingMarathoner) App(id apps.AppID) (*apps.App, error) {
+	atomic.AddInt32(&m.calls, 1)
+	if m.release != nil {
+		<-m.release
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &apps.App{ID: id}, nil
+}
+
+func (m *countingMarathoner) fetches() int {
+	return int(atomic.LoadInt32(&m.calls))
+}
+
+func TestAppCache_CoalescesConcurrentLookups(t *testing.T) {
+	t.Parallel()
+
+	// given
+	marathon := &countingMarathoner{release: make(chan struct{})}
+	cache := NewAppCache(marathon, time.Minute, "")
+	since := time.Now()
+
+	// when
+	var wg sync.WaitGroup
+	results := make([]*apps.App, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cache.AppSince("/app", since)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(marathon.release)
+	wg.Wait()
+
+	// then
+	assert.Equal(t, 1, marathon.fetches())
+	for _, app := range results {
+		assert.Equal(t, apps.AppID("/app"), app.ID)
+	}
+}
+
+func TestAppCache_ServesAppsFetchedWithinTTL(t *testing.T) {
+	t.Parallel()
+
+	// given
+	marathon := &countingMarathoner{}
+	cache := NewAppCache(marathon, time.Minute, "")
+
+	// when
+	cache.App("/app")
+	cache.App("/app")
+	cache.App("/other")
+
+	// then
+	assert.Equal(t, 2, marathon.fetches())
+}
+
+func TestAppCache_RefetchesAppsFetchedBeforeSince(t *testing.T) {
+	t.Parallel()
+
+	// given
+	marathon := &countingMarathoner{}
+	cache := NewAppCache(marathon, time.Minute, "")
+	cache.App("/app")
+
+	// when
+	cache.AppSince("/app", time.Now().Add(time.Millisecond))
+
+	// then
+	assert.Equal(t, 2, marathon.fetches())
+}
+
+func TestAppCache_DoesNotKeepErrors(t *testing.T) {
+	t.Parallel()
+
+	// given
+	marathon := &countingMarathoner{err: errors.New("Marathon unavailable")}
+	cache := NewAppCache(marathon, time.Minute, "")
+
+	// when
+	_, err1 := cache.App("/app")
+	_, err2 := cache.App("/app")
+
+	// then
+	assert.Error(t, err1)
+	assert.Error(t, err2)
+	assert.Equal(t, 2, marathon.fetches())
+}
+
+func TestAppCache_ExpiresApps(t *testing.T) {
+	t.Parallel()
+
+	// given
+	marathon := &countingMarathoner{}
+	cache := NewAppCache(marathon, time.Millisecond, "")
+	cache.App("/app")
+	time.Sleep(5 * time.Millisecond)
+
+	// when
+	cache.App("/other")
+
+	// then
+	cache.Lock()
+	defer cache.Unlock()
+	assert.NotContains(t, cache.fetches, apps.AppID("/app"))
+}
@@ -1,12 +1,33 @@
 package marathon
 
-import "github.com/allegro/marathon-consul/time"
+import (
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/time"
+)
 
 type Config struct {
-	Location  string
-	Protocol  string
-	Username  string
-	Password  string
-	VerifySsl bool
-	Timeout   time.Interval
+	Location           string
+	Protocol           string
+	Username           string
+	Password           string
+	PasswordFile       string
+	PasswordVault      string
+	VerifySsl          bool
+	SslCaCert          string
+	SslCert            string
+	SslKey             string
+	Timeout            time.Interval
+	AppCacheTTL        time.Interval
+	EndpointBackoff    time.Interval
+	Token              string
+	TokenFile          string
+	TokenVault         string
+	DCOSServiceAccount string
+	DCOSPrivateKey     string
+	DCOSLoginURL       string
+	FetchByGroup       bool
+	AppsInclude        string
+	AppsExclude        string
+	// MetricsScope labels metrics of the Marathon cluster, it is not read from the configuration
+	MetricsScope metrics.Scope `json:"-"`
 }
@@ -0,0 +1,110 @@
+package marathon
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/metrics"
+)
+
+// How often the leader is looked up when multiple Marathon endpoints are configured
+const leaderRefreshInterval = 30 * time.Second
+
+// endpoints orders Marathon masters requests are sent to. The current leader goes first, so requests are not
+// proxied by other masters, then the remaining ones in configured order. Endpoints that recently failed go last.
+type endpoints struct {
+	sync.Mutex
+	all         []*endpoint
+	backoff     time.Duration
+	leader      string
+	leaderCheck time.Time
+	metrics     metrics.Scope
+}
+
+type endpoint struct {
+	location    string
+	failedUntil time.Time
+}
+
+func newEndpoints(locations string, backoff time.Duration, scope metrics.Scope) *endpoints {
+	e := &endpoints{backoff: backoff, metrics: scope}
+	for _, location := range strings.Split(locations, ",") {
+		if location = strings.TrimSpace(location); location != "" {
+			e.all = append(e.all, &endpoint{location: location})
+		}
+	}
+	return e
+}
+
+func (e *endpoints) ordered() []string {
+	e.Lock()
+	defer e.Unlock()
+	now := time.Now()
+	var leader, healthy, failed []string
+	for _, endpoint := range e.all {
+		switch {
+		case now.Before(endpoint.failedUntil):
+			failed = append(failed, endpoint.location)
+		case endpoint.location == e.leader:
+			leader = append(leader, endpoint.location)
+		default:
+			healthy = append(healthy, endpoint.location)
+		}
+	}
+	return append(append(leader, healthy...), failed...)
+}
+
+// leaderCheckDue tells whether the leader should be looked up. It returns true once per refresh interval
+// and only when there is more than one endpoint to choose from.
+func (e *endpoints) leaderCheckDue() bool {
+	e.Lock()
+	defer e.Unlock()
+	if len(e.all) < 2 || time.Since(e.leaderCheck) < leaderRefreshInterval {
+		return false
+	}
+	e.leaderCheck = time.Now()
+	return true
+}
+
+// setLeader makes the leader preferred, as long as it is one of configured endpoints
+func (e *endpoints) setLeader(leader string) {
+	e.Lock()
+	defer e.Unlock()
+	if e.leader == leader {
+		return
+	}
+	for _, endpoint := range e.all {
+		if endpoint.location == leader {
+			log.WithField("Location", leader).Info("Preferring Marathon leader")
+			e.leader = leader
+			return
+		}
+	}
+}
+
+func (e *endpoints) failed(location string) {
+	e.Lock()
+	defer e.Unlock()
+	e.metrics.Mark("marathon.endpoint." + metrics.Clean(location) + ".failure")
+	for _, endpoint := range e.all {
+		if endpoint.location == location {
+			endpoint.failedUntil = time.Now().Add(e.backoff)
+		}
+	}
+	if e.leader == location {
+		e.leader = ""
+		e.leaderCheck = time.Time{}
+	}
+}
+
+func (e *endpoints) succeeded(location string) {
+	e.Lock()
+	defer e.Unlock()
+	for _, endpoint := range e.all {
+		if endpoint.location == location {
+			endpoint.failedUntil = time.Time{}
+		}
+	}
+}
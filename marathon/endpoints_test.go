@@ -0,0 +1,75 @@
+package marathon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoints_ParseLocations(t *testing.T) {
+	t.Parallel()
+
+	// when
+	endpoints := newEndpoints(" first:8080, second:8080,,", time.Minute, "")
+
+	// then
+	assert.Equal(t, []string{"first:8080", "second:8080"}, endpoints.ordered())
+}
+
+func TestEndpoints_FailedEndpointsGoLast(t *testing.T) {
+	t.Parallel()
+
+	// given
+	endpoints := newEndpoints("first:8080,second:8080,third:8080", time.Minute, "")
+
+	// when
+	endpoints.failed("first:8080")
+
+	// then
+	assert.Equal(t, []string{"second:8080", "third:8080", "first:8080"}, endpoints.ordered())
+
+	// when
+	endpoints.succeeded("first:8080")
+
+	// then
+	assert.Equal(t, []string{"first:8080", "second:8080", "third:8080"}, endpoints.ordered())
+}
+
+func TestEndpoints_IgnoreUnknownLeader(t *testing.T) {
+	t.Parallel()
+
+	// given
+	endpoints := newEndpoints("first:8080,second:8080", time.Minute, "")
+
+	// when
+	endpoints.setLeader("other:8080")
+
+	// then
+	assert.Equal(t, []string{"first:8080", "second:8080"}, endpoints.ordered())
+}
+
+func TestEndpoints_FailedLeaderIsNotPreferred(t *testing.T) {
+	t.Parallel()
+
+	// given
+	endpoints := newEndpoints("first:8080,second:8080", time.Minute, "")
+	endpoints.setLeader("second:8080")
+
+	// when
+	endpoints.failed("second:8080")
+
+	// then
+	assert.Equal(t, []string{"first:8080", "second:8080"}, endpoints.ordered())
+	assert.True(t, endpoints.leaderCheckDue())
+}
+
+func TestEndpoints_LeaderCheckNotNeededForSingleEndpoint(t *testing.T) {
+	t.Parallel()
+
+	// given
+	endpoints := newEndpoints("first:8080", time.Minute, "")
+
+	// expect
+	assert.False(t, endpoints.leaderCheckDue())
+}
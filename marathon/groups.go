@@ -0,0 +1,53 @@
+package marathon
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+)
+
+type group struct {
+	ID     apps.AppID  `json:"id"`
+	Apps   []*apps.App `json:"apps"`
+	Groups []*group    `json:"groups"`
+}
+
+// Embedded app fields must match appsEmbed
+var groupAppsEmbed = []string{"group.apps", "group.apps.tasks", "group.apps.readiness"}
+
+// consulAppsByGroup walks the tree of groups and fetches apps of one group at a time,
+// so even on clusters with thousands of apps no single response is huge
+func (m Marathon) consulAppsByGroup(root apps.AppID) ([]*apps.App, error) {
+	tree := &group{}
+	if err := m.getJSON(groupPath(root), params{"embed": {"group.groups"}}, tree); err != nil {
+		return nil, err
+	}
+
+	rules := m.rules.get()
+	var consulApps []*apps.App
+	for _, id := range tree.ids() {
+		log.WithField("Group", id).Debug("Asking Marathon for apps of group")
+		m.metrics.Mark("marathon.groups.fetch")
+		fetched := &group{}
+		// only apps directly in the group are embedded, apps of subgroups are fetched with them
+		if err := m.getJSON(groupPath(id), params{"embed": groupAppsEmbed}, fetched); err != nil {
+			return nil, err
+		}
+		consulApps = append(consulApps, selectConsulApps(rules, fetched.Apps)...)
+	}
+	return consulApps, nil
+}
+
+// ids returns ids of the group and all its subgroups
+func (g *group) ids() []apps.AppID {
+	ids := []apps.AppID{g.ID}
+	for _, subgroup := range g.Groups {
+		ids = append(ids, subgroup.ids()...)
+	}
+	return ids
+}
+
+func groupPath(id apps.AppID) string {
+	return "/v2/groups/" + strings.Trim(id.String(), "/")
+}
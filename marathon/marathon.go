@@ -1,13 +1,14 @@
 package marathon
 
 import (
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/allegro/marathon-consul/apps"
@@ -15,17 +16,41 @@ import (
 )
 
 type Marathoner interface {
-	ConsulApps() ([]*apps.App, error)
+	// ConsulApps returns consul apps of given groups, all of them when no group is given
+	ConsulApps(groups ...apps.AppID) ([]*apps.App, error)
 	App(apps.AppID) (*apps.App, error)
 	Tasks(apps.AppID) ([]*apps.Task, error)
 	Leader() (string, error)
 }
 
 type Marathon struct {
-	Location string
-	Protocol string
-	Auth     *url.Userinfo
-	client   *http.Client
+	Protocol    string
+	endpoints   *endpoints
+	client      *http.Client
+	credentials *credentials
+	// fetch apps group by group instead of all at once
+	fetchByGroup bool
+	// rules selecting consul apps besides the consul label
+	rules   *appRules
+	metrics metrics.Scope
+}
+
+// appRules holds rules selecting consul apps, they are replaced when the configuration is reloaded
+type appRules struct {
+	lock  sync.RWMutex
+	rules apps.Rules
+}
+
+func (r *appRules) get() apps.Rules {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.rules
+}
+
+func (r *appRules) set(rules apps.Rules) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rules = rules
 }
 
 type LeaderResponse struct {
@@ -33,142 +58,335 @@ type LeaderResponse struct {
 }
 
 func New(config Config) (*Marathon, error) {
-	var auth *url.Userinfo
-	if len(config.Username) == 0 && len(config.Password) == 0 {
-		auth = nil
-	} else {
-		auth = url.UserPassword(config.Username, config.Password)
-	}
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: !config.VerifySsl,
-		},
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout.Duration,
+	}
+	credentials, err := newCredentials(config, client)
+	if err != nil {
+		return nil, err
 	}
 	return &Marathon{
-		Location: config.Location,
-		Protocol: config.Protocol,
-		Auth:     auth,
-		client: &http.Client{
-			Transport: transport,
-			Timeout:   config.Timeout.Duration,
-		},
+		Protocol:     config.Protocol,
+		endpoints:    newEndpoints(config.Location, config.EndpointBackoff.Duration, config.MetricsScope),
+		client:       client,
+		credentials:  credentials,
+		fetchByGroup: config.FetchByGroup,
+		rules:        &appRules{rules: apps.ParseRules(config.AppsInclude, config.AppsExclude)},
+		metrics:      config.MetricsScope,
 	}, nil
 }
 
+// UpdateCredentials makes Marathon use basic auth credentials or tokens of the configuration,
+// credentials in use are kept when the configuration is invalid
+func (m Marathon) UpdateCredentials(config Config) error {
+	if err := m.credentials.update(config); err != nil {
+		return err
+	}
+	log.WithField("Location", config.Location).Info("Marathon credentials updated")
+	return nil
+}
+
+// UpdateRules makes Marathon select consul apps with include and exclude rules of the configuration
+func (m Marathon) UpdateRules(config Config) {
+	rules := apps.ParseRules(config.AppsInclude, config.AppsExclude)
+	m.rules.set(rules)
+	log.WithField("Location", config.Location).WithField("Include", rules.Include).
+		WithField("Exclude", rules.Exclude).Info("Marathon apps rules updated")
+}
+
 func (m Marathon) App(appID apps.AppID) (*apps.App, error) {
-	log.WithField("Location", m.Location).Debug("Asking Marathon for " + appID)
+	log.Debug("Asking Marathon for " + appID)
 
-	body, err := m.get(m.urlWithQuery(fmt.Sprintf("/v2/apps/%s", appID), params{"embed": "apps.tasks"}))
-	if err != nil {
+	response := &apps.AppResponse{}
+	err := m.getJSON(fmt.Sprintf("/v2/apps/%s", appID), params{"embed": appsEmbed}, response)
+	if _, ok := err.(decodeError); err != nil && !ok {
 		return nil, err
 	}
+	app := &response.App
+	if err == nil {
+		m.rules.get().Apply(app)
+	}
+	return app, err
+}
+
+func (m Marathon) ConsulApps(groups ...apps.AppID) ([]*apps.App, error) {
+	if len(groups) == 0 && !m.fetchByGroup {
+		return m.consulApps("")
+	}
+	if len(groups) == 0 {
+		groups = []apps.AppID{"/"}
+	}
 
-	return apps.ParseApp(body)
+	var consulApps []*apps.App
+	fetched := make(map[apps.AppID]struct{})
+	for _, group := range groups {
+		var groupApps []*apps.App
+		var err error
+		if m.fetchByGroup {
+			groupApps, err = m.consulAppsByGroup(group)
+		} else {
+			groupApps, err = m.consulApps(group)
+		}
+		// partial results would make sync deregister apps that could not be fetched
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range groupApps {
+			if _, ok := fetched[app.ID]; !ok && app.ID.InGroup(group) {
+				fetched[app.ID] = struct{}{}
+				consulApps = append(consulApps, app)
+			}
+		}
+	}
+	return consulApps, nil
 }
 
-func (m Marathon) ConsulApps() ([]*apps.App, error) {
-	log.WithField("Location", m.Location).Debug("Asking Marathon for apps")
-	body, err := m.get(m.urlWithQuery("/v2/apps", params{"embed": "apps.tasks", "label": apps.MarathonConsulLabel}))
+// consulApps fetches consul apps with ids containing the filter, all of them when filter is empty.
+// Apps included by rules may have no label, so groups covered by include rules are fetched without the label filter.
+func (m Marathon) consulApps(filter apps.AppID) ([]*apps.App, error) {
+	rules := m.rules.get()
+	fetched, err := m.fetchApps(filter, true)
 	if err != nil {
 		return nil, err
 	}
+	known := make(map[apps.AppID]struct{})
+	for _, app := range fetched {
+		known[app.ID] = struct{}{}
+	}
+	for _, group := range rules.IncludedGroups() {
+		included, ok := commonGroup(filter, group)
+		if !ok {
+			continue
+		}
+		groupApps, err := m.fetchApps(included, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range groupApps {
+			if _, ok := known[app.ID]; !ok {
+				known[app.ID] = struct{}{}
+				fetched = append(fetched, app)
+			}
+		}
+	}
+	return selectConsulApps(rules, fetched), nil
+}
 
-	return apps.ParseApps(body)
+// fetchApps fetches apps with ids containing the filter, only the ones with the consul label when labeled is set
+func (m Marathon) fetchApps(filter apps.AppID, labeled bool) ([]*apps.App, error) {
+	log.WithField("Filter", filter).WithField("Labeled", labeled).Debug("Asking Marathon for apps")
+	query := params{"embed": appsEmbed}
+	if labeled {
+		query["label"] = []string{apps.MarathonConsulLabel}
+	}
+	if filter != "" {
+		query["id"] = []string{filter.String()}
+	}
+	response := &apps.Apps{}
+	if err := m.getJSON("/v2/apps", query, response); err != nil {
+		return nil, err
+	}
+	return response.Apps, nil
+}
+
+// commonGroup returns the group apps of both the filter and the group belong to, false when there is none.
+// An empty filter does not restrict apps.
+func commonGroup(filter apps.AppID, group apps.AppID) (apps.AppID, bool) {
+	switch {
+	case filter == "" || group.InGroup(filter):
+		return group, true
+	case filter.InGroup(group):
+		return filter, true
+	default:
+		return "", false
+	}
+}
+
+func selectConsulApps(rules apps.Rules, fetched []*apps.App) []*apps.App {
+	consulApps := make([]*apps.App, 0, len(fetched))
+	for _, app := range fetched {
+		rules.Apply(app)
+		if app.IsConsulApp() {
+			consulApps = append(consulApps, app)
+		}
+	}
+	return consulApps
 }
 
 func (m Marathon) Tasks(app apps.AppID) ([]*apps.Task, error) {
-	log.WithFields(log.Fields{
-		"Location": m.Location,
-		"Id":       app,
-	}).Debug("asking Marathon for tasks")
+	log.WithField("Id", app).Debug("asking Marathon for tasks")
 
 	trimmedAppID := strings.Trim(app.String(), "/")
-	body, err := m.get(m.url(fmt.Sprintf("/v2/apps/%s/tasks", trimmedAppID)))
-	if err != nil {
+	response := &apps.TasksResponse{}
+	if err := m.getJSON(fmt.Sprintf("/v2/apps/%s/tasks", trimmedAppID), nil, response); err != nil {
 		return nil, err
 	}
-
-	return apps.ParseTasks(body)
+	return response.Tasks, nil
 }
 
 func (m Marathon) Leader() (string, error) {
-	log.WithField("Location", m.Location).Debug("Asking Marathon for leader")
+	log.Debug("Asking Marathon for leader")
 
-	body, err := m.get(m.url("/v2/leader"))
-	if err != nil {
+	leaderResponse := &LeaderResponse{}
+	if err := m.getJSON("/v2/leader", nil, leaderResponse); err != nil {
 		return "", err
 	}
+	m.endpoints.setLeader(leaderResponse.Leader)
+	return leaderResponse.Leader, nil
+}
 
-	leaderResponse := &LeaderResponse{}
-	err = json.Unmarshal(body, leaderResponse)
+// endpointError means the endpoint is failing and the request may succeed on another one
+type endpointError struct {
+	error
+}
+
+// decodeError means Marathon responded, but the response could not be decoded
+type decodeError struct {
+	error
+}
 
-	return leaderResponse.Leader, err
+// getJSON decodes the response while it is received, so big responses are never kept in memory as a whole
+func (m Marathon) getJSON(path string, params params, v interface{}) error {
+	return m.stream(path, params, func(response io.Reader) error {
+		if err := json.NewDecoder(response).Decode(v); err != nil {
+			return decodeError{err}
+		}
+		return nil
+	})
 }
 
-func (m Marathon) get(url string) ([]byte, error) {
+// stream sends the request to Marathon endpoints in order, until one of them responds, and passes the response to decode
+func (m Marathon) stream(path string, params params, decode func(io.Reader) error) error {
+	if m.endpoints.leaderCheckDue() {
+		m.Leader()
+	}
+
+	err := errors.New("No Marathon location configured")
+	for i, location := range m.endpoints.ordered() {
+		if i > 0 {
+			m.metrics.Mark("marathon.get.failover")
+			log.WithField("Location", location).Warn("Failing over to another Marathon")
+		}
+		err = m.getAuthorized(location, m.urlWithQuery(location, path, params), decode)
+		if err == nil {
+			m.endpoints.succeeded(location)
+			return nil
+		}
+		failure, ok := err.(endpointError)
+		if !ok {
+			return err
+		}
+		m.endpoints.failed(location)
+		err = failure.error
+	}
+	return err
+}
+
+// unauthorizedError means Marathon rejected credentials
+type unauthorizedError struct {
+	error
+}
+
+// getAuthorized retries the request once with renewed credentials when Marathon rejects them
+func (m Marathon) getAuthorized(location string, url string, decode func(io.Reader) error) error {
+	err := m.getFrom(location, url, decode)
+	if _, ok := err.(unauthorizedError); ok && m.credentials.invalidate() {
+		m.metrics.Mark("marathon.auth.renew")
+		log.WithField("Location", location).Info("Marathon rejected credentials, renewing them")
+		return m.getFrom(location, url, decode)
+	}
+	return err
+}
+
+func (m Marathon) getFrom(location string, url string, decode func(io.Reader) error) error {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		log.Error(err.Error())
-		return nil, err
+		return err
 	}
 	request.Header.Add("Accept", "application/json")
 	request.Header.Set("User-Agent", "Marathon-Consul")
+	if err := m.credentials.authorize(request); err != nil {
+		m.metrics.Mark("marathon.auth.error")
+		log.WithField("Location", location).WithError(err).Error("Unable to authorize Marathon request")
+		return err
+	}
 
 	log.WithFields(log.Fields{
 		"Uri":      request.URL.RequestURI(),
-		"Location": m.Location,
+		"Location": location,
 		"Protocol": m.Protocol,
 	}).Debug("Sending GET request to marathon")
 
 	var response *http.Response
-	metrics.Time("marathon.get", func() { response, err = m.client.Do(request) })
+	endpointMetric := "marathon.endpoint." + metrics.Clean(location)
+	m.metrics.Time("marathon.get", func() {
+		m.metrics.Time(endpointMetric+".get", func() { response, err = m.client.Do(request) })
+	})
 	if err != nil {
-		metrics.Mark("marathon.get.error")
-		m.logHTTPError(response, err)
-		return nil, err
+		m.metrics.Mark("marathon.get.error")
+		m.metrics.Mark(endpointMetric + ".error")
+		m.logHTTPError(location, response, err)
+		return endpointError{err}
 	}
 	defer response.Body.Close()
+	// Marathon masters redirect to the leader, prefer it from now on
+	if response.Request.URL.Host != location {
+		m.endpoints.setLeader(response.Request.URL.Host)
+	}
 	if response.StatusCode != 200 {
-		metrics.Mark("marathon.get.error")
-		metrics.Mark(fmt.Sprintf("marathon.get.error.%d", response.StatusCode))
+		m.metrics.Mark("marathon.get.error")
+		m.metrics.Mark(fmt.Sprintf("marathon.get.error.%d", response.StatusCode))
+		m.metrics.Mark(endpointMetric + ".error")
 		err = fmt.Errorf("Expected 200 but got %d for %s", response.StatusCode, response.Request.URL.Path)
-		m.logHTTPError(response, err)
-		return nil, err
+		m.logHTTPError(location, response, err)
+		if response.StatusCode >= 500 {
+			return endpointError{err}
+		}
+		if response.StatusCode == http.StatusUnauthorized {
+			return unauthorizedError{err}
+		}
+		return err
 	}
 
-	return ioutil.ReadAll(response.Body)
+	return decode(response.Body)
 }
 
-func (m Marathon) logHTTPError(resp *http.Response, err error) {
+func (m Marathon) logHTTPError(location string, resp *http.Response, err error) {
 	statusCode := "???"
 	if resp != nil {
 		statusCode = fmt.Sprintf("%d", resp.StatusCode)
 	}
 
 	log.WithFields(log.Fields{
-		"Location":   m.Location,
+		"Location":   location,
 		"Protocol":   m.Protocol,
 		"statusCode": statusCode,
 	}).Error(err)
 }
 
-func (m Marathon) url(path string) string {
-	return m.urlWithQuery(path, nil)
-}
+type params map[string][]string
 
-type params map[string]string
+// Readiness check results are needed by the ready registration policy
+var appsEmbed = []string{"apps.tasks", "apps.readiness"}
 
-func (m Marathon) urlWithQuery(path string, params params) string {
+func (m Marathon) urlWithQuery(location string, path string, params params) string {
 	marathon := url.URL{
 		Scheme: m.Protocol,
-		User:   m.Auth,
-		Host:   m.Location,
+		User:   m.credentials.basicAuth(),
+		Host:   location,
 		Path:   path,
 	}
 	query := marathon.Query()
-	for key, value := range params {
-		query.Add(key, value)
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
 	}
 	marathon.RawQuery = query.Encode()
 	return marathon.String()
@@ -16,9 +16,21 @@ type MarathonerStub struct {
 	interactions   bool
 }
 
-func (m *MarathonerStub) ConsulApps() ([]*apps.App, error) {
+func (m *MarathonerStub) ConsulApps(groups ...apps.AppID) ([]*apps.App, error) {
 	m.noteInteraction()
-	return m.AppsStub, nil
+	if len(groups) == 0 {
+		return m.AppsStub, nil
+	}
+	var groupApps []*apps.App
+	for _, app := range m.AppsStub {
+		for _, group := range groups {
+			if app.ID.InGroup(group) {
+				groupApps = append(groupApps, app)
+				break
+			}
+		}
+	}
+	return groupApps, nil
 }
 
 func (m *MarathonerStub) App(id apps.AppID) (*apps.App, error) {
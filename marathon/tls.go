@@ -0,0 +1,141 @@
+package marathon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How often certificate files are checked for changes
+const certReloadInterval = 10 * time.Second
+
+func newTransport(config Config) (http.RoundTripper, error) {
+	if len(certFiles(config)) == 0 {
+		tlsConfig, _ := newTLSConfig(config)
+		return transportWithTLS(tlsConfig), nil
+	}
+	transport := &reloadingTransport{config: config, interval: certReloadInterval}
+	if err := transport.reload(); err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+// transportWithTLS returns the transport sending requests through the proxy from the environment,
+// as Marathon requests always have been
+func transportWithTLS(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+}
+
+func newTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: !config.VerifySsl,
+	}
+	if config.SslCaCert != "" {
+		pemCerts, err := ioutil.ReadFile(config.SslCaCert)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read Marathon CA certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, errors.New("No certificates found in Marathon CA certificate file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.SslCert != "" {
+		// the key may be kept together with the certificate
+		key := config.SslKey
+		if key == "" {
+			key = config.SslCert
+		}
+		cert, err := tls.LoadX509KeyPair(config.SslCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load Marathon client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func certFiles(config Config) []string {
+	var files []string
+	for _, file := range []string{config.SslCaCert, config.SslCert, config.SslKey} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// reloadingTransport sends requests using certificates from files and loads them again when the files change,
+// so certificates can be rotated without restarting. When changed files can not be loaded, e.g. only some
+// of them have been replaced yet, previously loaded certificates are used.
+type reloadingTransport struct {
+	sync.Mutex
+	config    Config
+	interval  time.Duration
+	checked   time.Time
+	modified  map[string]time.Time
+	transport *http.Transport
+}
+
+func (t *reloadingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	t.Lock()
+	if time.Since(t.checked) >= t.interval {
+		t.checked = time.Now()
+		if t.changed() {
+			if err := t.reload(); err != nil {
+				t.config.MetricsScope.Mark("marathon.tls.reload.error")
+				log.WithError(err).Error("Unable to reload Marathon certificates, using previous ones")
+			}
+		}
+	}
+	transport := t.transport
+	t.Unlock()
+	return transport.RoundTrip(request)
+}
+
+func (t *reloadingTransport) changed() bool {
+	for _, file := range certFiles(t.config) {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(t.modified[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// reload builds the transport from current files, must be called with the lock held
+func (t *reloadingTransport) reload() error {
+	modified := make(map[string]time.Time)
+	for _, file := range certFiles(t.config) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("Unable to read Marathon certificate: %s", err)
+		}
+		modified[file] = info.ModTime()
+	}
+	tlsConfig, err := newTLSConfig(t.config)
+	if err != nil {
+		return err
+	}
+	if t.transport != nil {
+		log.Info("Marathon certificates changed, reloading them")
+		t.config.MetricsScope.Mark("marathon.tls.reload")
+		t.transport.CloseIdleConnections()
+	}
+	t.modified = modified
+	t.transport = transportWithTLS(tlsConfig)
+	return nil
+}
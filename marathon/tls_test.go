@@ -0,0 +1,215 @@
+package marathon
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarathon_ConnectsWithClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	// given
+	pki := newTestPKI(t)
+	defer pki.Close()
+	m, err := New(pki.config(pki.caFile))
+	require.NoError(t, err)
+
+	// when
+	leader, err := m.Leader()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "marathon:8080", leader)
+}
+
+func TestMarathon_RejectedWithoutClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	// given
+	pki := newTestPKI(t)
+	defer pki.Close()
+	config := pki.config(pki.caFile)
+	config.SslCert, config.SslKey = "", ""
+	m, err := New(config)
+	require.NoError(t, err)
+
+	// when
+	_, err = m.Leader()
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestMarathon_ReloadsChangedCertificates(t *testing.T) {
+	t.Parallel()
+
+	// given
+	pki := newTestPKI(t)
+	defer pki.Close()
+	other := newTestPKI(t)
+	defer other.Close()
+	caFile := filepath.Join(pki.dir, "rotated-ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", other.ca.Raw)
+	m, err := New(pki.config(caFile))
+	require.NoError(t, err)
+	m.client.Transport.(*reloadingTransport).interval = 0
+	_, untrustedErr := m.Leader()
+
+	// when
+	caPEM, _ := ioutil.ReadFile(pki.caFile)
+	require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(caFile, later, later))
+	_, err = m.Leader()
+
+	// then
+	assert.Error(t, untrustedErr)
+	assert.NoError(t, err)
+}
+
+func TestMarathon_KeepsCertificatesWhenReloadFails(t *testing.T) {
+	t.Parallel()
+
+	// given
+	pki := newTestPKI(t)
+	defer pki.Close()
+	m, err := New(pki.config(pki.caFile))
+	require.NoError(t, err)
+	m.client.Transport.(*reloadingTransport).interval = 0
+
+	// when
+	require.NoError(t, ioutil.WriteFile(pki.keyFile, []byte("being rotated"), 0600))
+	_, err = m.Leader()
+
+	// then
+	assert.NoError(t, err)
+}
+
+func TestNew_InvalidCertificateFiles(t *testing.T) {
+	t.Parallel()
+
+	// when
+	_, caErr := New(Config{SslCaCert: "/not/existing/ca.pem"})
+	_, certErr := New(Config{SslCert: "/not/existing/cert.pem"})
+
+	// then
+	assert.Error(t, caErr)
+	assert.Error(t, certErr)
+}
+
+// testPKI is a CA with a Marathon stand-in serving a certificate signed by it and requiring client certificates
+type testPKI struct {
+	server   *httptest.Server
+	ca       *x509.Certificate
+	dir      string
+	caFile   string
+	certFile string
+	keyFile  string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "marathon-tls")
+	require.NoError(t, err)
+	caKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	ca := signCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, caKey, caKey)
+
+	serverKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	serverCert := signCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "marathon"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, serverKey, caKey)
+
+	clientKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	clientCert := signCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "marathon-consul"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, clientKey, caKey)
+
+	pki := &testPKI{
+		ca:       ca,
+		dir:      dir,
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+	writePEM(t, pki.caFile, "CERTIFICATE", ca.Raw)
+	writePEM(t, pki.certFile, "CERTIFICATE", clientCert.Raw)
+	writePEM(t, pki.keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(clientKey))
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	pki.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"leader": "marathon:8080"}`)
+	}))
+	pki.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	pki.server.StartTLS()
+	return pki
+}
+
+func (p *testPKI) Close() {
+	p.server.Close()
+	os.RemoveAll(p.dir)
+}
+
+func (p *testPKI) config(caFile string) Config {
+	serverURL, _ := url.Parse(p.server.URL)
+	return Config{
+		Location:  serverURL.Host,
+		Protocol:  "https",
+		VerifySsl: true,
+		SslCaCert: caFile,
+		SslCert:   p.certFile,
+		SslKey:    p.keyFile,
+	}
+}
+
+func signCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, key *rsa.PrivateKey, parentKey *rsa.PrivateKey) *x509.Certificate {
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func writePEM(t *testing.T, file string, blockType string, bytes []byte) {
+	require.NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600))
+}
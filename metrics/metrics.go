@@ -4,12 +4,13 @@ package metrics
 import (
 	"errors"
 	"fmt"
-	logger "log"
+	"io"
 	"net"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -19,6 +20,12 @@ import (
 
 var pfx string
 
+// reporter sends metrics to the target until Init is called again with another one
+var reporter struct {
+	sync.Mutex
+	stop chan struct{}
+}
+
 func Mark(name string) {
 	meter := metrics.GetOrRegisterMeter(name, metrics.DefaultRegistry)
 	meter.Mark(1)
@@ -34,7 +41,10 @@ func UpdateGauge(name string, value int64) {
 	gauge.Update(value)
 }
 
+// Init starts sending metrics to the configured target. It can be called again to change the target,
+// metrics are then no longer sent to the previous one.
 func Init(cfg Config) error {
+	stopReporting()
 	pfx = cfg.Prefix
 	if pfx == "default" {
 		prefix, err := defaultPrefix()
@@ -67,14 +77,15 @@ func Init(cfg Config) error {
 
 func TargetName(service, host, path string, targetURL *url.URL) string {
 	return strings.Join([]string{
-		clean(service),
-		clean(host),
-		clean(path),
-		clean(targetURL.Host),
+		Clean(service),
+		Clean(host),
+		Clean(path),
+		Clean(targetURL.Host),
 	}, ".")
 }
 
-func clean(s string) string {
+// Clean makes s usable as a part of a metric name
+func Clean(s string) string {
 	if s == "" {
 		return "_"
 	}
@@ -86,6 +97,9 @@ func clean(s string) string {
 // stubbed out for testing
 var hostname = os.Hostname
 
+// stubbed out for testing
+var stdout io.Writer = os.Stderr
+
 func defaultPrefix() (string, error) {
 	host, err := hostname()
 	if err != nil {
@@ -93,12 +107,14 @@ func defaultPrefix() (string, error) {
 		return "", err
 	}
 	exe := filepath.Base(os.Args[0])
-	return clean(host) + "." + clean(exe), nil
+	return Clean(host) + "." + Clean(exe), nil
 }
 
 func initStdout(interval time.Duration) error {
-	logger := logger.New(os.Stderr, "localhost: ", logger.Lmicroseconds)
-	go metrics.Log(metrics.DefaultRegistry, interval, logger)
+	out := stdout
+	startReporting(interval, func() {
+		metrics.WriteOnce(metrics.DefaultRegistry, out)
+	})
 	return nil
 }
 
@@ -108,6 +124,56 @@ func initGraphite(addr string, interval time.Duration) error {
 		return fmt.Errorf("metrics: cannot connect to Graphite: %s", err)
 	}
 
-	go graphite.Graphite(metrics.DefaultRegistry, interval, pfx, a)
+	config := graphite.Config{
+		Addr:          a,
+		Registry:      metrics.DefaultRegistry,
+		FlushInterval: interval,
+		DurationUnit:  time.Nanosecond,
+		Prefix:        pfx,
+		Percentiles:   []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+	}
+	startReporting(interval, func() {
+		if err := graphite.Once(config); err != nil {
+			log.WithError(err).Warn("Unable to send metrics to Graphite")
+		}
+	})
 	return nil
 }
+
+// startReporting calls report every interval until reporting is stopped
+func startReporting(interval time.Duration, report func()) {
+	if interval <= 0 {
+		return
+	}
+	stop := newReporting()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// newReporting returns the channel closed once reporting is stopped
+func newReporting() <-chan struct{} {
+	reporter.Lock()
+	defer reporter.Unlock()
+	stop := make(chan struct{})
+	reporter.stop = stop
+	return stop
+}
+
+func stopReporting() {
+	reporter.Lock()
+	defer reporter.Unlock()
+	if reporter.stop != nil {
+		close(reporter.stop)
+		reporter.stop = nil
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"net/url"
 	"os"
 	"testing"
+	"time"
 
+	timeutil "github.com/allegro/marathon-consul/time"
 	"github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/assert"
 )
@@ -102,6 +104,62 @@ func TestMetricsInit(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestMetricsInit_StopsPreviousReporter(t *testing.T) {
+	// given
+	reports := make(chan struct{}, 100)
+	startReporting(time.Millisecond, func() { reports <- struct{}{} })
+	<-reports
+
+	// when
+	err := Init(Config{})
+
+	// then
+	assert.NoError(t, err)
+	<-time.After(10 * time.Millisecond)
+	for len(reports) > 0 {
+		<-reports
+	}
+	<-time.After(10 * time.Millisecond)
+	assert.Empty(t, reports)
+}
+
+func TestMetricsInit_WritesToStdoutUntilStopped(t *testing.T) {
+	// given
+	writes := make(writesCounter, 1000)
+	stdout = writes
+	defer func() { stdout = os.Stderr }()
+	Mark("stdout.test")
+
+	// when
+	err := Init(Config{Target: "stdout", Interval: timeutil.Interval{Duration: time.Millisecond}})
+
+	// then
+	assert.NoError(t, err)
+	<-writes
+
+	// when
+	Init(Config{})
+	<-time.After(10 * time.Millisecond)
+	for len(writes) > 0 {
+		<-writes
+	}
+	<-time.After(10 * time.Millisecond)
+
+	// then
+	assert.Empty(t, writes)
+}
+
+// writesCounter receives a value for every write, writes are dropped when it is full
+type writesCounter chan struct{}
+
+func (w writesCounter) Write(p []byte) (int, error) {
+	select {
+	case w <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
 func TestInit_DefaultPrefix(t *testing.T) {
 	// given
 	hostname = func() (string, error) { return "", fmt.Errorf("Some error") }
@@ -0,0 +1,33 @@
+package metrics
+
+// Scope prefixes names of metrics reported by a component, so metrics of components running side by side,
+// e.g. syncing different Marathon clusters, can be told apart. The empty scope reports metrics as they are named.
+type Scope string
+
+// Cluster returns the scope of metrics reported for the named Marathon cluster
+func Cluster(name string) Scope {
+	if name == "" {
+		return ""
+	}
+	return Scope("cluster." + Clean(name))
+}
+
+// Name returns the name of the metric in the scope
+func (s Scope) Name(name string) string {
+	if s == "" {
+		return name
+	}
+	return string(s) + "." + name
+}
+
+func (s Scope) Mark(name string) {
+	Mark(s.Name(name))
+}
+
+func (s Scope) Time(name string, function func()) {
+	Time(s.Name(name), function)
+}
+
+func (s Scope) UpdateGauge(name string, value int64) {
+	UpdateGauge(s.Name(name), value)
+}
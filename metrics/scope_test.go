@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScope_Name(t *testing.T) {
+	t.Parallel()
+
+	// expect
+	assert.Equal(t, "events.queue.len", Scope("").Name("events.queue.len"))
+	assert.Equal(t, "events.queue.len", Cluster("").Name("events.queue.len"))
+	assert.Equal(t, "cluster.team_a.events.queue.len", Cluster("Team.A").Name("events.queue.len"))
+}
+
+func TestScope_Mark(t *testing.T) {
+	// given
+	Init(Config{Target: "stdout", Prefix: ""})
+	scope := Cluster("scoped")
+
+	// when
+	scope.Mark("marker")
+
+	// then
+	mark, _ := metrics.Get("cluster.scoped.marker").(metrics.Meter)
+	assert.Equal(t, int64(1), mark.Count())
+	assert.Nil(t, metrics.Get("scoped.marker"))
+}
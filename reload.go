@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/config"
+	"github.com/allegro/marathon-consul/consul"
+	"github.com/allegro/marathon-consul/logging"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/utils"
+	"github.com/allegro/marathon-consul/vault"
+	"github.com/allegro/marathon-consul/web"
+)
+
+// reloader reads the configuration again on SIGHUP or /reload requests and applies changes of options
+// that can change while running to running components. Changes of other options are logged and ignored.
+// It also reads secrets from files and Vault again, so rotated credentials are used without a reload.
+type reloader struct {
+	lock     sync.Mutex
+	config   *config.Config
+	secrets  config.SecretReader
+	consul   *consul.Consul
+	clusters map[string]reloadableCluster
+}
+
+// reloadableCluster applies options of a running Marathon cluster
+type reloadableCluster struct {
+	reconfigureSync   func(cluster config.Cluster)
+	updateCredentials func(cluster config.Cluster) error
+	updateRules       func(cluster config.Cluster)
+}
+
+func newReloader(c *config.Config, secrets config.SecretReader, consulInstance *consul.Consul) *reloader {
+	return &reloader{
+		config:   c,
+		secrets:  secrets,
+		consul:   consulInstance,
+		clusters: make(map[string]reloadableCluster),
+	}
+}
+
+// newSecretReader returns Vault secrets are read from, or nil when Vault is not configured
+func newSecretReader(c vault.Config) (config.SecretReader, error) {
+	if c.Address == "" {
+		return nil, nil
+	}
+	return vault.New(c)
+}
+
+func (r *reloader) addCluster(name string, cluster reloadableCluster) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.clusters[name] = cluster
+}
+
+func (r *reloader) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Info("Received SIGHUP, reloading configuration")
+			r.reload()
+		}
+	}()
+}
+
+// reopenLogsOnSignal reopens the log and audit log files on SIGUSR1, so they can be moved by external tools
+func reopenLogsOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			if err := logging.Reopen(); err != nil {
+				log.WithError(err).Error("Unable to reopen log files")
+				continue
+			}
+			log.Info("Received SIGUSR1, reopened log files")
+		}
+	}()
+}
+
+func (r *reloader) refreshSecretsEvery(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			r.refreshSecrets()
+		}
+	}()
+}
+
+func (r *reloader) reload() (applied []string, rejected []string, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	reloaded, err := r.config.Reload()
+	if err == nil {
+		err = r.resolveSecrets(reloaded)
+	}
+	if err != nil {
+		metrics.Mark("config.reload.error")
+		log.WithError(err).Error("Unable to reload configuration, keeping the one in use")
+		return nil, nil, err
+	}
+	applied, rejected, err = r.apply(reloaded)
+	for _, option := range rejected {
+		log.WithField("Option", option).Warn("Changing the option requires a restart, the change is not applied")
+	}
+	if err != nil {
+		metrics.Mark("config.reload.error")
+		log.WithError(err).Error("Unable to apply reloaded configuration")
+		return applied, rejected, err
+	}
+	metrics.Mark("config.reload")
+	log.WithField("Applied", applied).WithField("Rejected", rejected).Info("Configuration reloaded")
+	return applied, rejected, nil
+}
+
+// resolveSecrets reads secrets of the reloaded configuration, from a new Vault client when Vault options changed
+func (r *reloader) resolveSecrets(reloaded *config.Config) error {
+	secrets := r.secrets
+	if reloaded.Vault != r.config.Vault {
+		var err error
+		if secrets, err = newSecretReader(reloaded.Vault); err != nil {
+			return err
+		}
+	}
+	if err := reloaded.ResolveSecrets(secrets); err != nil {
+		return err
+	}
+	r.secrets = secrets
+	return nil
+}
+
+func (r *reloader) refreshSecrets() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	refreshed := *r.config
+	refreshed.Clusters = append([]config.Cluster(nil), r.config.Clusters...)
+	if err := refreshed.ResolveSecrets(r.secrets); err != nil {
+		metrics.Mark("secrets.refresh.error")
+		log.WithError(err).Error("Unable to read secrets, keeping the ones in use")
+		return
+	}
+	applied, _, err := r.apply(&refreshed)
+	if err != nil {
+		metrics.Mark("secrets.refresh.error")
+		log.WithError(err).Error("Unable to apply rotated secrets")
+		return
+	}
+	if len(applied) > 0 {
+		log.WithField("Applied", applied).Info("Rotated secrets applied")
+	}
+}
+
+func (r *reloader) apply(reloaded *config.Config) (applied []string, rejected []string, err error) {
+	applied, rejected = r.config.Update(reloaded)
+
+	var errs []error
+	if changed(applied, "Consul.") && r.consul != nil {
+		if err := r.consul.Reconfigure(r.config.Consul); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if changed(applied, "Metrics.") {
+		if err := metrics.Init(r.config.Metrics); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, cluster := range r.config.MarathonClusters() {
+		running, ok := r.clusters[cluster.Name]
+		if !ok {
+			continue
+		}
+		prefix := ""
+		if cluster.Name != "" {
+			prefix = fmt.Sprintf("Clusters[%s].", cluster.Name)
+		}
+		if changed(applied, prefix+"Sync.") {
+			running.reconfigureSync(cluster)
+		}
+		if changed(applied, prefix+"Marathon.") {
+			if err := running.updateCredentials(cluster); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if changed(applied, prefix+"Marathon.Apps") {
+			running.updateRules(cluster)
+		}
+	}
+	return applied, rejected, utils.MergeErrorsOrNil(errs, "applying reloaded configuration")
+}
+
+// statuses describes running Marathon clusters with registration rules in use
+func (r *reloader) statuses() []web.ClusterStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var statuses []web.ClusterStatus
+	for _, cluster := range r.config.MarathonClusters() {
+		if _, ok := r.clusters[cluster.Name]; !ok {
+			continue
+		}
+		statuses = append(statuses, web.ClusterStatus{
+			Name:       cluster.Name,
+			Tag:        cluster.Tag,
+			EventsPath: cluster.EventsPath(),
+			Rules:      apps.ParseRules(cluster.Marathon.AppsInclude, cluster.Marathon.AppsExclude),
+		})
+	}
+	return statuses
+}
+
+func changed(options []string, prefix string) bool {
+	for _, option := range options {
+		if strings.HasPrefix(option, prefix) {
+			return true
+		}
+	}
+	return false
+}
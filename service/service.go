@@ -19,6 +19,14 @@ type Service struct {
 	Name                    string
 	Tags                    []string
 	RegisteringAgentAddress string
+	Address                 string
+	Port                    int
+	// Number of health checks attached to the service
+	Checks int
+	// Reason of maintenance mode, empty when the service is not in maintenance
+	MaintenanceReason string
+	// EnableTagOverride allows tags to be changed outside of marathon-consul
+	EnableTagOverride bool
 }
 
 func (s *Service) TaskId() (apps.TaskID, error) {
@@ -38,6 +46,13 @@ type ServiceRegistry interface {
 	GetAllServices() ([]*Service, error)
 	GetServices(name string) ([]*Service, error)
 	Register(task *apps.Task, app *apps.App) error
+	// ExpectedServices returns services as they should be registered for the task,
+	// so they can be compared with registered ones to detect drift
+	ExpectedServices(task *apps.Task, app *apps.App) ([]*Service, error)
 	DeregisterByTask(taskId apps.TaskID) error
 	Deregister(toDeregister *Service) error
+	// EnableMaintenance excludes the service from discovery without deregistering it
+	EnableMaintenance(toMaintain *Service, reason string) error
+	EnableMaintenanceByTask(taskId apps.TaskID, reason string) error
+	DisableMaintenance(toMaintain *Service) error
 }
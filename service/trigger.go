@@ -0,0 +1,38 @@
+package service
+
+// Types of triggers of registry changes
+const (
+	TriggerEvent    = "event"
+	TriggerSync     = "sync"
+	TriggerOverflow = "overflow"
+)
+
+// Trigger tells what caused changes of registered services, e.g. a Marathon event or a sync run
+type Trigger struct {
+	Type string `json:"type"`
+	// Event is the type of the Marathon event handled
+	Event string `json:"event,omitempty"`
+	// SyncRun identifies the sync run
+	SyncRun string `json:"syncRun,omitempty"`
+}
+
+// TriggerAware is implemented by registries recording what triggered their changes
+type TriggerAware interface {
+	WithTrigger(trigger Trigger) ServiceRegistry
+}
+
+// WithTrigger returns the registry attributing changes to the trigger, registries that do not record triggers
+// are returned as they are
+func WithTrigger(registry ServiceRegistry, trigger Trigger) ServiceRegistry {
+	if aware, ok := registry.(TriggerAware); ok {
+		return aware.WithTrigger(trigger)
+	}
+	return registry
+}
+
+// WithTrigger returns the handler changing services through the registry attributing changes to the trigger
+func (h *UnhealthyTaskHandler) WithTrigger(trigger Trigger) *UnhealthyTaskHandler {
+	handler := *h
+	handler.registry = WithTrigger(h.registry, trigger)
+	return &handler
+}
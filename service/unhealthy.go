@@ -0,0 +1,122 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/utils"
+)
+
+// Actions taken on services of tasks reported unhealthy by Marathon for longer than the grace period
+const (
+	UnhealthyKeep        = "keep"
+	UnhealthyDeregister  = "deregister"
+	UnhealthyMaintenance = "maintenance"
+)
+
+// Reason of maintenance mode enabled for unhealthy tasks. It tells such maintenance apart from the one enabled
+// by operators, so only the former is disabled when the task becomes healthy again.
+const UnhealthyMaintenanceReason = "marathon-consul: task reported unhealthy by Marathon"
+
+type UnhealthyTaskHandler struct {
+	registry    ServiceRegistry
+	action      string
+	gracePeriod time.Duration
+}
+
+func NewUnhealthyTaskHandler(registry ServiceRegistry, action string, gracePeriod time.Duration) (*UnhealthyTaskHandler, error) {
+	switch action {
+	case "":
+		action = UnhealthyKeep
+	case UnhealthyKeep, UnhealthyDeregister, UnhealthyMaintenance:
+	default:
+		return nil, fmt.Errorf("Unknown unhealthy tasks action %s, expected one of: %s, %s, %s",
+			action, UnhealthyKeep, UnhealthyDeregister, UnhealthyMaintenance)
+	}
+	return &UnhealthyTaskHandler{registry: registry, action: action, gracePeriod: gracePeriod}, nil
+}
+
+func (h *UnhealthyTaskHandler) Enabled() bool {
+	return h.action != UnhealthyKeep
+}
+
+// Handle deregisters services of the task (or puts them into maintenance) when the task has been reported unhealthy
+// for longer than the grace period. Otherwise it returns the time left until the grace period elapses.
+func (h *UnhealthyTaskHandler) Handle(task *apps.Task, services []*Service) (time.Duration, error) {
+	if !h.Enabled() || !task.IsUnhealthy() || len(services) == 0 {
+		return 0, nil
+	}
+	since := task.UnhealthySince()
+	if since.IsZero() {
+		log.WithField("Id", task.ID).Warn("Can't determine since when task is unhealthy, keeping its services")
+		return 0, nil
+	}
+	if left := h.gracePeriod - time.Since(since); left > 0 {
+		log.WithField("Id", task.ID).WithField("GracePeriodLeft", left).Debug("Task is unhealthy, waiting for grace period")
+		return left, nil
+	}
+
+	var errs []error
+	for _, s := range services {
+		var err error
+		if h.action == UnhealthyDeregister {
+			log.WithField("Id", s.ID).WithField("Address", s.RegisteringAgentAddress).Info("Deregistering service of unhealthy task")
+			err = h.registry.Deregister(s)
+		} else if s.MaintenanceReason == "" {
+			log.WithField("Id", s.ID).WithField("Address", s.RegisteringAgentAddress).Info("Enabling maintenance of service of unhealthy task")
+			err = h.registry.EnableMaintenance(s, UnhealthyMaintenanceReason)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	metrics.Mark("unhealthy." + h.action)
+	return 0, utils.MergeErrorsOrNil(errs, fmt.Sprintf("handling unhealthy task %s", task.ID))
+}
+
+// Recover disables maintenance enabled by Handle for services of a task that is healthy again
+func (h *UnhealthyTaskHandler) Recover(task *apps.Task, services []*Service) error {
+	if h.action != UnhealthyMaintenance || !task.IsHealthy() {
+		return nil
+	}
+	var errs []error
+	for _, s := range services {
+		if s.MaintenanceReason != UnhealthyMaintenanceReason {
+			continue
+		}
+		log.WithField("Id", s.ID).WithField("Address", s.RegisteringAgentAddress).Info("Disabling maintenance of service of healthy task")
+		if err := h.registry.DisableMaintenance(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(errs, fmt.Sprintf("recovering healthy task %s", task.ID))
+}
+
+// TaskServices returns registered services of the task, looking them up by names the task is expected to be registered under
+func (h *UnhealthyTaskHandler) TaskServices(task *apps.Task, app *apps.App) ([]*Service, error) {
+	expected, err := h.registry.ExpectedServices(task, app)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]struct{})
+	var services []*Service
+	for _, e := range expected {
+		if _, ok := names[e.Name]; ok {
+			continue
+		}
+		names[e.Name] = struct{}{}
+		registered, err := h.registry.GetServices(e.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range registered {
+			if id, err := s.TaskId(); err == nil && id == task.ID {
+				services = append(services, s)
+			}
+		}
+	}
+	return services, nil
+}
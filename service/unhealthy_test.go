@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/stretchr/testify/assert"
+)
+
+type registryStub struct {
+	ServiceRegistry
+	deregistered []ServiceId
+	maintenance  map[ServiceId]string
+}
+
+func newRegistryStub() *registryStub {
+	return &registryStub{maintenance: make(map[ServiceId]string)}
+}
+
+func (r *registryStub) Deregister(toDeregister *Service) error {
+	r.deregistered = append(r.deregistered, toDeregister.ID)
+	return nil
+}
+
+func (r *registryStub) EnableMaintenance(toMaintain *Service, reason string) error {
+	r.maintenance[toMaintain.ID] = reason
+	return nil
+}
+
+func (r *registryStub) DisableMaintenance(toMaintain *Service) error {
+	if _, ok := r.maintenance[toMaintain.ID]; !ok {
+		return errors.New("Service not in maintenance")
+	}
+	delete(r.maintenance, toMaintain.ID)
+	return nil
+}
+
+func unhealthyTask(since time.Time) *apps.Task {
+	return &apps.Task{
+		ID:                 "my-task",
+		HealthCheckResults: []apps.HealthCheckResult{{Alive: false, LastSuccess: since.Format(time.RFC3339Nano)}},
+	}
+}
+
+func TestNewUnhealthyTaskHandler_ShouldRejectUnknownAction(t *testing.T) {
+	t.Parallel()
+
+	// when
+	_, err := NewUnhealthyTaskHandler(newRegistryStub(), "remove", time.Minute)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestUnhealthyTaskHandler_ShouldWaitForGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	// given
+	registry := newRegistryStub()
+	handler, _ := NewUnhealthyTaskHandler(registry, UnhealthyDeregister, time.Hour)
+
+	// when
+	left, err := handler.Handle(unhealthyTask(time.Now().Add(-10*time.Minute)), []*Service{{ID: "a"}})
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, left > 49*time.Minute && left <= 50*time.Minute)
+	assert.Empty(t, registry.deregistered)
+}
+
+func TestUnhealthyTaskHandler_ShouldDeregisterAfterGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	// given
+	registry := newRegistryStub()
+	handler, _ := NewUnhealthyTaskHandler(registry, UnhealthyDeregister, time.Minute)
+
+	// when
+	left, err := handler.Handle(unhealthyTask(time.Now().Add(-10*time.Minute)), []*Service{{ID: "a"}, {ID: "b"}})
+
+	// then
+	assert.NoError(t, err)
+	assert.Zero(t, left)
+	assert.Equal(t, []ServiceId{"a", "b"}, registry.deregistered)
+}
+
+func TestUnhealthyTaskHandler_ShouldKeepServicesByDefault(t *testing.T) {
+	t.Parallel()
+
+	// given
+	registry := newRegistryStub()
+	handler, _ := NewUnhealthyTaskHandler(registry, "", time.Minute)
+
+	// when
+	left, err := handler.Handle(unhealthyTask(time.Now().Add(-10*time.Minute)), []*Service{{ID: "a"}})
+
+	// then
+	assert.NoError(t, err)
+	assert.Zero(t, left)
+	assert.False(t, handler.Enabled())
+	assert.Empty(t, registry.deregistered)
+}
+
+func TestUnhealthyTaskHandler_ShouldEnableAndDisableMaintenance(t *testing.T) {
+	t.Parallel()
+
+	// given
+	registry := newRegistryStub()
+	handler, _ := NewUnhealthyTaskHandler(registry, UnhealthyMaintenance, time.Minute)
+	services := []*Service{{ID: "a"}, {ID: "b", MaintenanceReason: "set by operator"}}
+
+	// when
+	_, err := handler.Handle(unhealthyTask(time.Now().Add(-10*time.Minute)), services)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, map[ServiceId]string{"a": UnhealthyMaintenanceReason}, registry.maintenance)
+
+	// given
+	registry.maintenance["b"] = "set by operator"
+	services[0].MaintenanceReason = UnhealthyMaintenanceReason
+	healthy := &apps.Task{ID: "my-task", HealthCheckResults: []apps.HealthCheckResult{{Alive: true}}}
+
+	// when
+	err = handler.Recover(healthy, services)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, map[ServiceId]string{"b": "set by operator"}, registry.maintenance)
+}
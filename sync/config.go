@@ -1,10 +1,18 @@
 package sync
 
-import "github.com/allegro/marathon-consul/time"
+import (
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/time"
+)
 
 type Config struct {
-	Enabled  bool
-	Force    bool
-	Interval time.Interval
-	Leader   string
+	Enabled              bool
+	Force                bool
+	Interval             time.Interval
+	Leader               string
+	UnhealthyTasks       string
+	UnhealthyGracePeriod time.Interval
+	Groups               string
+	// MetricsScope labels metrics of the synced Marathon cluster, it is not read from the configuration
+	MetricsScope metrics.Scope `json:"-"`
 }
@@ -9,7 +9,7 @@ import (
 type errorMarathon struct {
 }
 
-func (m errorMarathon) ConsulApps() ([]*apps.App, error) {
+func (m errorMarathon) ConsulApps(groups ...apps.AppID) ([]*apps.App, error) {
 	return nil, errors.New("Error")
 }
 
@@ -29,3 +29,19 @@ func (c errorServiceRegistry) DeregisterByTask(taskID apps.TaskID) error {
 func (c errorServiceRegistry) Deregister(toDeregister *service.Service) error {
 	return errors.New("Error occured")
 }
+
+func (c errorServiceRegistry) ExpectedServices(task *apps.Task, app *apps.App) ([]*service.Service, error) {
+	return nil, errors.New("Error occured")
+}
+
+func (c errorServiceRegistry) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	return errors.New("Error occured")
+}
+
+func (c errorServiceRegistry) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	return errors.New("Error occured")
+}
+
+func (c errorServiceRegistry) DisableMaintenance(toMaintain *service.Service) error {
+	return errors.New("Error occured")
+}
@@ -3,13 +3,17 @@ package sync
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/allegro/marathon-consul/apps"
 	"github.com/allegro/marathon-consul/marathon"
-	"github.com/allegro/marathon-consul/metrics"
 	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
 )
 
 type Sync struct {
@@ -17,12 +21,21 @@ type Sync struct {
 	marathon            marathon.Marathoner
 	serviceRegistry     service.ServiceRegistry
 	syncStartedListener startedListener
+	unhealthyTasks      *service.UnhealthyTaskHandler
+	// options changed while running, they are applied by the sync job between syncs
+	reconfigured chan Config
+	// guards the options changed by the sync job, so copies made by withTrigger on other goroutines
+	// read them consistently. The sync job reads them without the lock, as it is the only writer.
+	configLock *sync.Mutex
+	// trigger of changes made by a sync run or an app sync, see withTrigger
+	trigger service.Trigger
 }
 
 type startedListener func(apps []*apps.App)
 
-func New(config Config, marathon marathon.Marathoner, serviceRegistry service.ServiceRegistry, syncStartedListener startedListener) *Sync {
-	return &Sync{config, marathon, serviceRegistry, syncStartedListener}
+func New(config Config, marathon marathon.Marathoner, serviceRegistry service.ServiceRegistry,
+	syncStartedListener startedListener, unhealthyTasks *service.UnhealthyTaskHandler) *Sync {
+	return &Sync{config, marathon, serviceRegistry, syncStartedListener, unhealthyTasks, make(chan Config, 1), &sync.Mutex{}, service.Trigger{}}
 }
 
 func (s *Sync) StartSyncServicesJob() {
@@ -32,9 +45,10 @@ func (s *Sync) StartSyncServicesJob() {
 	}
 
 	log.WithFields(log.Fields{
-		"Interval": s.config.Interval,
-		"Leader":   s.config.Leader,
-		"Force":    s.config.Force,
+		"Interval":       s.config.Interval,
+		"Leader":         s.config.Leader,
+		"Force":          s.config.Force,
+		"UnhealthyTasks": s.config.UnhealthyTasks,
 	}).Info("Marathon-consul sync job started")
 
 	ticker := time.NewTicker(s.config.Interval.Duration)
@@ -42,18 +56,61 @@ func (s *Sync) StartSyncServicesJob() {
 		if err := s.SyncServices(); err != nil {
 			log.WithError(err).Error("An error occured while performing sync")
 		}
-		for range ticker.C {
-			if err := s.SyncServices(); err != nil {
-				log.WithError(err).Error("An error occured while performing sync")
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.SyncServices(); err != nil {
+					log.WithError(err).Error("An error occured while performing sync")
+				}
+			case config := <-s.reconfigured:
+				ticker = s.reconfigure(config, ticker)
 			}
 		}
 	}()
 	return
 }
 
+// Reconfigure changes the interval, leader and force options of the running sync job.
+// Other options, including enabling the job, require a restart.
+// It does not wait for the job, a config not applied yet is replaced.
+func (s *Sync) Reconfigure(config Config) {
+	if !s.config.Enabled {
+		return
+	}
+	for {
+		select {
+		case s.reconfigured <- config:
+			return
+		default:
+		}
+		select {
+		case <-s.reconfigured:
+		default:
+		}
+	}
+}
+
+func (s *Sync) reconfigure(config Config, ticker *time.Ticker) *time.Ticker {
+	if config.Interval != s.config.Interval {
+		ticker.Stop()
+		ticker = time.NewTicker(config.Interval.Duration)
+	}
+	s.configLock.Lock()
+	s.config.Interval = config.Interval
+	s.config.Leader = config.Leader
+	s.config.Force = config.Force
+	s.configLock.Unlock()
+	log.WithFields(log.Fields{
+		"Interval": s.config.Interval,
+		"Leader":   s.config.Leader,
+		"Force":    s.config.Force,
+	}).Info("Marathon-consul sync job reconfigured")
+	return ticker
+}
+
 func (s *Sync) SyncServices() error {
 	var err error
-	metrics.Time("sync.services", func() { err = s.syncServices() })
+	s.config.MetricsScope.Time("sync.services", func() { err = s.syncServices() })
 	return err
 }
 
@@ -61,9 +118,13 @@ func (s *Sync) syncServices() error {
 	if check, err := s.shouldPerformSync(); !check {
 		return err
 	}
-	log.Info("Syncing services started")
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	log.WithField("Run", run).Info("Syncing services started")
+	return s.withTrigger(service.Trigger{Type: service.TriggerSync, SyncRun: run}).syncRun()
+}
 
-	apps, err := s.marathon.ConsulApps()
+func (s *Sync) syncRun() error {
+	apps, err := s.marathon.ConsulApps(s.groups()...)
 	if err != nil {
 		return fmt.Errorf("Can't get Marathon apps: %v", err)
 	}
@@ -78,10 +139,94 @@ func (s *Sync) syncServices() error {
 	s.registerAppTasksNotFoundInConsul(apps, services)
 	s.deregisterConsulServicesNotFoundInMarathon(apps, services)
 
-	log.Info("Syncing services finished")
+	log.WithField("Run", s.trigger.SyncRun).Info("Syncing services finished")
 	return nil
 }
 
+// SyncApp brings registrations of the app in line with its current definition, e.g. after its labels changed.
+// Like the scheduled sync, only tasks running the current definition are registered again, older tasks
+// only get their missing registrations registered. Services of tasks no longer running are deregistered.
+func (s *Sync) SyncApp(appID apps.AppID, trigger service.Trigger) error {
+	return s.withTrigger(trigger).syncApp(appID)
+}
+
+func (s *Sync) syncApp(appID apps.AppID) error {
+	app, err := s.marathon.App(appID)
+	if err != nil {
+		return fmt.Errorf("Can't get Marathon app %s: %v", appID, err)
+	}
+	services, err := s.appServices(appID)
+	if err != nil {
+		return err
+	}
+	if !app.IsConsulApp() {
+		log.WithField("Id", appID).Info("Not a Consul app, deregistering its services")
+		return s.deregisterServices(services, appID)
+	}
+
+	log.WithField("Id", appID).Info("Syncing app")
+	registered := s.servicesUnderTaskIds(services)
+	runningTasks := s.marathonTaskIdsSet([]*apps.App{app})
+	for _, task := range app.Tasks {
+		s.syncTaskRegistrations(&task, app, registered[task.ID])
+	}
+	var notRunning []*service.Service
+	for taskID, taskServices := range registered {
+		if _, isRunning := runningTasks[taskID]; !isRunning {
+			notRunning = append(notRunning, taskServices...)
+		}
+	}
+	return s.deregisterServices(notRunning, appID)
+}
+
+// DeregisterApp deregisters all services of tasks of the app
+func (s *Sync) DeregisterApp(appID apps.AppID, trigger service.Trigger) error {
+	s = s.withTrigger(trigger)
+	services, err := s.appServices(appID)
+	if err != nil {
+		return err
+	}
+	log.WithField("Id", appID).WithField("Services", len(services)).Info("Deregistering app")
+	return s.deregisterServices(services, appID)
+}
+
+// withTrigger returns the sync changing services through the registry attributing changes to the trigger
+func (s *Sync) withTrigger(trigger service.Trigger) *Sync {
+	s.configLock.Lock()
+	triggered := *s
+	s.configLock.Unlock()
+	triggered.trigger = trigger
+	triggered.serviceRegistry = service.WithTrigger(s.serviceRegistry, trigger)
+	triggered.unhealthyTasks = s.unhealthyTasks.WithTrigger(trigger)
+	return &triggered
+}
+
+func (s *Sync) appServices(appID apps.AppID) ([]*service.Service, error) {
+	services, err := s.serviceRegistry.GetAllServices()
+	if err != nil {
+		return nil, fmt.Errorf("Can't get Consul services: %v", err)
+	}
+	var appServices []*service.Service
+	for _, service := range services {
+		taskID, err := service.TaskId()
+		// task id without a dot can't be mapped to an app id
+		if err == nil && strings.Contains(taskID.String(), ".") && taskID.AppID() == appID {
+			appServices = append(appServices, service)
+		}
+	}
+	return appServices, nil
+}
+
+func (s *Sync) deregisterServices(services []*service.Service, appID apps.AppID) error {
+	var errs []error
+	for _, service := range services {
+		if err := s.serviceRegistry.Deregister(service); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(errs, fmt.Sprintf("deregistering services of app %s", appID))
+}
+
 func (s *Sync) shouldPerformSync() (bool, error) {
 	if s.config.Force {
 		log.Debug("Forcing sync")
@@ -109,16 +254,23 @@ func (s *Sync) resolveHostname() error {
 	if err != nil {
 		return err
 	}
+	s.configLock.Lock()
 	s.config.Leader = fmt.Sprintf("%s:8080", hostname)
+	s.configLock.Unlock()
 	log.WithField("Leader", s.config.Leader).Info("Marathon-consul sync leader mode set to resolved hostname")
 	return nil
 }
 
 func (s *Sync) deregisterConsulServicesNotFoundInMarathon(marathonApps []*apps.App, services []*service.Service) {
 	runningTasks := s.marathonTaskIdsSet(marathonApps)
+	groups := s.groups()
 	for _, service := range services {
 		taskIDInTag, err := service.TaskId()
 		taskIDNotFoundInTag := err != nil
+		if len(groups) > 0 && !s.inGroups(taskIDInTag, taskIDNotFoundInTag, groups) {
+			log.WithField("Id", service.ID).Debug("Service is not in synced groups")
+			continue
+		}
 		if taskIDNotFoundInTag {
 			log.WithField("Id", service.ID).WithError(err).
 				Warn("Couldn't extract marathon task id, deregistering since sync should have reregistered it already")
@@ -138,47 +290,197 @@ func (s *Sync) deregisterConsulServicesNotFoundInMarathon(marathonApps []*apps.A
 	}
 }
 
+// groups returns Marathon groups the sync is restricted to, none means the whole cluster
+func (s *Sync) groups() []apps.AppID {
+	return apps.ParseGroups(s.config.Groups)
+}
+
+// inGroups tells whether the service belongs to a task of an app in one of the groups.
+// Services that can't be mapped to an app are left to syncs of the whole cluster.
+func (s *Sync) inGroups(taskID apps.TaskID, taskIDNotFound bool, groups []apps.AppID) bool {
+	if taskIDNotFound || !strings.Contains(taskID.String(), ".") {
+		return false
+	}
+	for _, group := range groups {
+		if taskID.AppID().InGroup(group) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Sync) registerAppTasksNotFoundInConsul(marathonApps []*apps.App, services []*service.Service) {
-	registrationsUnderTaskIds := s.taskIdsInConsulServices(services)
+	registrationsUnderTaskIds := s.servicesUnderTaskIds(services)
 	for _, app := range marathonApps {
 		if !app.IsConsulApp() {
 			log.WithField("Id", app.ID).Debug("Not a Consul app, skipping registration")
 			continue
 		}
-		expectedRegistrations := app.RegistrationIntentsNumber()
 		for _, task := range app.Tasks {
-			registrations := registrationsUnderTaskIds[task.ID]
-			if registrations < expectedRegistrations {
-				if registrations != 0 {
-					log.WithField("Id", task.ID).WithField("HasRegistrations", registrations).
-						WithField("ExpectedRegistrations", expectedRegistrations).Info("Registering missing service registrations")
-				}
-				if task.IsHealthy() {
-					err := s.serviceRegistry.Register(&task, app)
-					if err != nil {
-						log.WithError(err).WithField("Id", task.ID).Error("Can't register task")
-					}
-				} else {
-					log.WithField("Id", task.ID).Debug("Task is not healthy. Not Registering")
-				}
-			} else if registrations > expectedRegistrations {
-				log.WithField("Id", task.ID).WithField("HasRegistrations", registrations).
-					WithField("ExpectedRegistrations", expectedRegistrations).Warn("Skipping task with excess registrations")
-			} else {
-				log.WithField("Id", task.ID).Debug("Task already registered in Consul")
+			registered := registrationsUnderTaskIds[task.ID]
+			if task.IsUnhealthy() && s.unhealthyTasks.Enabled() {
+				s.handleUnhealthyTask(&task, registered)
+				continue
+			}
+			if err := s.unhealthyTasks.Recover(&task, registered); err != nil {
+				log.WithError(err).WithField("Id", task.ID).Error("Can't recover services of healthy task")
 			}
+			s.syncTaskRegistrations(&task, app, registered)
+		}
+	}
+}
+
+// syncTaskRegistrations repairs registrations of tasks running the current app configuration,
+// older tasks only get their missing registrations registered
+func (s *Sync) syncTaskRegistrations(task *apps.Task, app *apps.App, registered []*service.Service) {
+	if app.RunsCurrentConfig(task) {
+		s.repairTaskRegistrations(task, app, registered)
+		return
+	}
+	expectedRegistrations := app.RegistrationIntentsNumber()
+	registrations := len(registered)
+	if registrations < expectedRegistrations {
+		if registrations != 0 {
+			log.WithField("Id", task.ID).WithField("HasRegistrations", registrations).
+				WithField("ExpectedRegistrations", expectedRegistrations).Info("Registering missing service registrations")
+		}
+		s.registerTask(task, app)
+	} else if registrations > expectedRegistrations {
+		log.WithField("Id", task.ID).WithField("HasRegistrations", registrations).
+			WithField("ExpectedRegistrations", expectedRegistrations).Warn("Skipping task with excess registrations")
+	} else {
+		log.WithField("Id", task.ID).Debug("Task already registered in Consul")
+	}
+}
+
+// repairTaskRegistrations compares registrations of the task with the expected ones. Drifted or missing
+// registrations are registered again and registrations that are no longer expected are deregistered.
+// It must be used only for tasks running the current app configuration, as there is no way to get
+// the configuration older tasks were started with.
+func (s *Sync) repairTaskRegistrations(task *apps.Task, app *apps.App, registered []*service.Service) {
+	if policy := app.RegistrationPolicy(); !policy.CanRegister(task) {
+		log.WithField("Id", task.ID).WithField("Policy", policy.Name).Debug("Task does not satisfy registration policy. Not Registering")
+		return
+	}
+	expected, err := s.serviceRegistry.ExpectedServices(task, app)
+	if err != nil {
+		log.WithError(err).WithField("Id", task.ID).Error("Can't determine expected registrations of task")
+		return
+	}
+
+	registeredByID := make(map[service.ServiceId]*service.Service, len(registered))
+	for _, r := range registered {
+		registeredByID[r.ID] = r
+	}
+	expectedIDs := make(map[service.ServiceId]struct{}, len(expected))
+	drifted := false
+	for _, e := range expected {
+		expectedIDs[e.ID] = struct{}{}
+		r, ok := registeredByID[e.ID]
+		if !ok {
+			s.config.MetricsScope.Mark("sync.drift.missing")
+			drifted = true
+			continue
+		}
+		if fields := driftedFields(e, r); len(fields) > 0 {
+			for _, field := range fields {
+				s.config.MetricsScope.Mark("sync.drift." + field)
+			}
+			log.WithField("Id", e.ID).WithField("Drift", fields).Info("Registration differs from expected")
+			drifted = true
+		}
+	}
+
+	if drifted {
+		if len(registered) != 0 {
+			log.WithField("Id", task.ID).WithField("HasRegistrations", len(registered)).
+				WithField("ExpectedRegistrations", len(expected)).Info("Repairing service registrations")
+		}
+		s.registerTask(task, app)
+	} else {
+		log.WithField("Id", task.ID).Debug("Task already registered in Consul")
+	}
+
+	for _, r := range registered {
+		if _, ok := expectedIDs[r.ID]; ok {
+			continue
+		}
+		s.config.MetricsScope.Mark("sync.drift.stale")
+		log.WithField("Id", r.ID).WithField("Address", r.RegisteringAgentAddress).Info("Deregistering stale registration")
+		if err := s.serviceRegistry.Deregister(r); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"Id":      r.ID,
+				"Address": r.RegisteringAgentAddress,
+			}).Error("Can't deregister service")
+		}
+	}
+}
+
+func (s *Sync) handleUnhealthyTask(task *apps.Task, registered []*service.Service) {
+	left, err := s.unhealthyTasks.Handle(task, registered)
+	if err != nil {
+		log.WithError(err).WithField("Id", task.ID).Error("Can't handle services of unhealthy task")
+	} else if left > 0 {
+		log.WithField("Id", task.ID).WithField("GracePeriodLeft", left).Debug("Task is unhealthy, keeping its services until grace period elapses")
+	}
+}
+
+func (s *Sync) registerTask(task *apps.Task, app *apps.App) {
+	if policy := app.RegistrationPolicy(); !policy.CanRegister(task) {
+		log.WithField("Id", task.ID).WithField("Policy", policy.Name).Debug("Task does not satisfy registration policy. Not Registering")
+		return
+	}
+	if err := s.serviceRegistry.Register(task, app); err != nil {
+		log.WithError(err).WithField("Id", task.ID).Error("Can't register task")
+	}
+}
+
+// driftedFields returns names of fields that differ between expected and registered service.
+// Tags of services with tag override enabled may be changed externally, so they are not compared.
+func driftedFields(expected *service.Service, registered *service.Service) []string {
+	var fields []string
+	if expected.Name != registered.Name {
+		fields = append(fields, "name")
+	}
+	if expected.Port != registered.Port {
+		fields = append(fields, "port")
+	}
+	if expected.Address != registered.Address {
+		fields = append(fields, "address")
+	}
+	if !expected.EnableTagOverride && !sameTags(expected.Tags, registered.Tags) {
+		fields = append(fields, "tags")
+	}
+	if expected.Checks != registered.Checks {
+		fields = append(fields, "checks")
+	}
+	return fields
+}
+
+func sameTags(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
 		}
 	}
+	return true
 }
 
-func (s *Sync) taskIdsInConsulServices(services []*service.Service) map[apps.TaskID]int {
-	serviceCounters := make(map[apps.TaskID]int)
+func (s *Sync) servicesUnderTaskIds(services []*service.Service) map[apps.TaskID][]*service.Service {
+	servicesUnderTaskIds := make(map[apps.TaskID][]*service.Service)
 	for _, service := range services {
 		if taskID, err := service.TaskId(); err == nil {
-			serviceCounters[taskID]++
+			servicesUnderTaskIds[taskID] = append(servicesUnderTaskIds[taskID], service)
 		}
 	}
-	return serviceCounters
+	return servicesUnderTaskIds
 }
 
 func (s *Sync) marathonTaskIdsSet(marathonApps []*apps.App) map[apps.TaskID]struct{} {
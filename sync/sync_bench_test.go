@@ -40,7 +40,7 @@ func BenchmarkDeregisterConsulServicesThatAreNotInMarathonApps100x100(b *testing
 func bench(b *testing.B, appsCount, instancesCount int) {
 	apps := marathonApps(appsCount, instancesCount)
 	instances := instances(appsCount, instancesCount)
-	sync := New(Config{}, nil, consul.NewConsulStub(), noopSyncStartedListener)
+	sync := New(Config{}, nil, consul.NewConsulStub(), noopSyncStartedListener, keepUnhealthyTasks)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -62,9 +62,9 @@ func instances(appsCount, instancesCount int) []*service.Service {
 		app := ConsulApp(fmt.Sprintf("consul/service/no_%d", i), instancesCount)
 		for _, task := range app.Tasks {
 			createdInstances[i] = &service.Service{
-				ID:   service.ServiceId(task.ID.String()),
-				Name: app.ID.String(),
-				Tags: []string{"marathon"},
+				ID:                      service.ServiceId(task.ID.String()),
+				Name:                    app.ID.String(),
+				Tags:                    []string{"marathon"},
 				RegisteringAgentAddress: task.Host,
 			}
 		}
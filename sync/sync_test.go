@@ -19,6 +19,8 @@ import (
 
 var noopSyncStartedListener = func(apps []*apps.App) {}
 
+var keepUnhealthyTasks, _ = service.NewUnhealthyTaskHandler(nil, service.UnhealthyKeep, 0)
+
 func TestSyncJob_ShouldSyncOnLeadership(t *testing.T) {
 	t.Parallel()
 	// given
@@ -29,7 +31,7 @@ func TestSyncJob_ShouldSyncOnLeadership(t *testing.T) {
 		Enabled:  true,
 		Interval: timeutil.Interval{Duration: 10 * time.Millisecond},
 		Leader:   "current.leader:8080",
-	}, marathon, services, noopSyncStartedListener)
+	}, marathon, services, noopSyncStartedListener, keepUnhealthyTasks)
 
 	// when
 	sync.StartSyncServicesJob()
@@ -39,6 +41,45 @@ func TestSyncJob_ShouldSyncOnLeadership(t *testing.T) {
 	assert.Equal(t, 2, services.RegistrationsCount(app.Tasks[0].ID.String()))
 }
 
+func TestSyncJob_ShouldSyncWithReconfiguredLeaderAndInterval(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("app1", 1)
+	marathon := marathon.MarathonerStubWithLeaderForApps("current.leader:8080", app)
+	services := newConsulServicesMock()
+	sync := New(Config{
+		Enabled:  true,
+		Interval: timeutil.Interval{Duration: time.Hour},
+		Leader:   "other.node:8080",
+	}, marathon, services, noopSyncStartedListener, keepUnhealthyTasks)
+	sync.StartSyncServicesJob()
+
+	// when
+	sync.Reconfigure(Config{
+		Enabled:  true,
+		Interval: timeutil.Interval{Duration: 10 * time.Millisecond},
+		Leader:   "current.leader:8080",
+	})
+
+	// then
+	<-time.After(50 * time.Millisecond)
+	assert.True(t, services.RegistrationsCount(app.Tasks[0].ID.String()) > 0)
+}
+
+func TestSync_ReconfigureShouldReplaceConfigNotAppliedYet(t *testing.T) {
+	t.Parallel()
+	// given
+	sync := New(Config{Enabled: true}, marathon.MarathonerStubForApps(), newConsulServicesMock(), noopSyncStartedListener, keepUnhealthyTasks)
+
+	// when
+	sync.Reconfigure(Config{Enabled: true, Leader: "first.node:8080"})
+	sync.Reconfigure(Config{Enabled: true, Leader: "second.node:8080"})
+
+	// then
+	assert.Equal(t, "second.node:8080", (<-sync.reconfigured).Leader)
+	assert.Empty(t, sync.reconfigured)
+}
+
 func TestSyncJob_ShouldNotSyncWhenDisabled(t *testing.T) {
 	t.Parallel()
 	// given
@@ -49,7 +90,7 @@ func TestSyncJob_ShouldNotSyncWhenDisabled(t *testing.T) {
 		Enabled:  false,
 		Interval: timeutil.Interval{Duration: 10 * time.Millisecond},
 		Leader:   "current.leader:8080",
-	}, marathon, services, noopSyncStartedListener)
+	}, marathon, services, noopSyncStartedListener, keepUnhealthyTasks)
 
 	// when
 	sync.StartSyncServicesJob()
@@ -69,7 +110,7 @@ func TestSyncJob_ShouldDefaultLeaderConfigurationToResolvedHostname(t *testing.T
 	sync := New(Config{
 		Enabled:  true,
 		Interval: timeutil.Interval{Duration: 10 * time.Millisecond},
-	}, marathon, services, noopSyncStartedListener)
+	}, marathon, services, noopSyncStartedListener, keepUnhealthyTasks)
 
 	// when
 	sync.StartSyncServicesJob()
@@ -85,7 +126,7 @@ func TestSyncServices_ShouldNotSyncOnNoForceNorLeaderSpecified(t *testing.T) {
 	app := ConsulApp("app1", 1)
 	marathon := marathon.MarathonerStubWithLeaderForApps("localhost:8080", app)
 	services := newConsulServicesMock()
-	sync := New(Config{}, marathon, services, noopSyncStartedListener)
+	sync := New(Config{}, marathon, services, noopSyncStartedListener, keepUnhealthyTasks)
 
 	// when
 	sync.StartSyncServicesJob()
@@ -100,7 +141,7 @@ func TestSyncServices_ShouldNotSyncOnNoLeadership(t *testing.T) {
 	app := ConsulApp("app1", 1)
 	marathon := marathon.MarathonerStubWithLeaderForApps("leader:8080", app)
 	services := newConsulServicesMock()
-	sync := New(Config{Leader: "different.node:8090"}, marathon, services, noopSyncStartedListener)
+	sync := New(Config{Leader: "different.node:8090"}, marathon, services, noopSyncStartedListener, keepUnhealthyTasks)
 
 	// when
 	err := sync.SyncServices()
@@ -116,7 +157,7 @@ func TestSyncServices_ShouldSyncOnForceWithoutLeadership(t *testing.T) {
 	app := ConsulApp("app1", 1)
 	marathon := marathon.MarathonerStubWithLeaderForApps("leader:8080", app)
 	services := newConsulServicesMock()
-	sync := New(Config{Leader: "different.node:8090", Force: true}, marathon, services, noopSyncStartedListener)
+	sync := New(Config{Leader: "different.node:8090", Force: true}, marathon, services, noopSyncStartedListener, keepUnhealthyTasks)
 
 	// when
 	err := sync.SyncServices()
@@ -152,6 +193,10 @@ func (c *ConsulServicesMock) Register(task *apps.Task, app *apps.App) error {
 	return nil
 }
 
+func (c *ConsulServicesMock) ExpectedServices(task *apps.Task, app *apps.App) ([]*service.Service, error) {
+	return []*service.Service{{ID: service.ServiceId(task.ID)}}, nil
+}
+
 func (c *ConsulServicesMock) RegistrationsCount(instanceID string) int {
 	c.RLock()
 	defer c.RUnlock()
@@ -166,6 +211,18 @@ func (c *ConsulServicesMock) Deregister(toDeregister *service.Service) error {
 	return nil
 }
 
+func (c *ConsulServicesMock) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	return nil
+}
+
+func (c *ConsulServicesMock) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	return nil
+}
+
+func (c *ConsulServicesMock) DisableMaintenance(toMaintain *service.Service) error {
+	return nil
+}
+
 func TestSyncAppsFromMarathonToConsul(t *testing.T) {
 	t.Parallel()
 	// given
@@ -353,6 +410,8 @@ func TestSync_SkipServiceHavingMoreRegistrationsThanExpectedInMultiregistrationS
 	t.Parallel()
 	// given
 	app := ConsulAppMultipleRegistrations("/test/app", 1, 2)
+	app.Tasks[0].Version = "2017-01-01T10:00:00.000Z"
+	app.VersionInfo.LastConfigChangeAt = "2017-01-02T10:00:00.000Z"
 	marathon := marathon.MarathonerStubForApps(app)
 	consul := consul.NewConsulStub()
 
@@ -419,7 +478,7 @@ func TestSync_WithConsulProblems(t *testing.T) {
 }
 
 func newSyncWithDefaultConfig(marathon marathon.Marathoner, serviceRegistry service.ServiceRegistry) *Sync {
-	return New(Config{Enabled: true, Leader: "localhost:8080"}, marathon, serviceRegistry, noopSyncStartedListener)
+	return New(Config{Enabled: true, Leader: "localhost:8080"}, marathon, serviceRegistry, noopSyncStartedListener, keepUnhealthyTasks)
 }
 
 func TestSync_AddingAgentsFromMarathonTasks(t *testing.T) {
@@ -436,7 +495,7 @@ func TestSync_AddingAgentsFromMarathonTasks(t *testing.T) {
 	app.Tasks[0].Host = consulServer.Config.Bind
 	app.Tasks[1].Host = consulServer.Config.Bind
 	marathon := marathon.MarathonerStubWithLeaderForApps("localhost:8080", app)
-	sync := New(Config{Leader: "localhost:8080"}, marathon, consulInstance, consulInstance.AddAgentsFromApps)
+	sync := New(Config{Leader: "localhost:8080"}, marathon, consulInstance, consulInstance.AddAgentsFromApps, keepUnhealthyTasks)
 
 	// when
 	err := sync.SyncServices()
@@ -458,3 +517,327 @@ func TestSync_AddingAgentsFromMarathonTasks(t *testing.T) {
 	assert.Len(t, serviceNames, 1)
 	assert.Contains(t, serviceNames, "serviceA")
 }
+
+func TestSync_ShouldRepairDriftedRegistrations(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 3)
+	app.Labels["public"] = "tag"
+	app.HealthChecks = []apps.HealthCheck{{Protocol: "TCP", IntervalSeconds: 10, TimeoutSeconds: 5}}
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		consulStub.Register(&task, app)
+	}
+	staleTags := *consulStub.Registration("test_app.0_test.app_8080")
+	staleTags.Tags = []string{"marathon", "private", "marathon-task:test_app.0"}
+	wrongPort := *consulStub.Registration("test_app.1_test.app_8081")
+	wrongPort.Port = 9999
+	missingChecks := *consulStub.Registration("test_app.2_test.app_8082")
+	missingChecks.Checks = consul.ServiceChecks{}
+	consulStub.Overwrite(&staleTags, &wrongPort, &missingChecks)
+	sync := newSyncWithDefaultConfig(marathon, consulStub)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"marathon", "public", "marathon-task:test_app.0"}, consulStub.Registration("test_app.0_test.app_8080").Tags)
+	assert.Equal(t, 8081, consulStub.Registration("test_app.1_test.app_8081").Port)
+	assert.Len(t, consulStub.Registration("test_app.2_test.app_8082").Checks, 1)
+}
+
+func TestSync_ShouldDeregisterStaleRegistrationsOfTasksRunningCurrentConfig(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulAppMultipleRegistrations("/test/app", 1, 2)
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	consulStub.Register(&app.Tasks[0], app)
+	sync := newSyncWithDefaultConfig(marathon, consulStub)
+
+	// when
+	app.PortDefinitions[0].Labels = map[string]string{"consul": "new-name"}
+	app.PortDefinitions[1].Labels = map[string]string{}
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	services, _ := consulStub.GetAllServices()
+	assert.Len(t, services, 1)
+	assert.Equal(t, "new-name", services[0].Name)
+}
+
+func TestSync_ShouldNotRepairRegistrationsOfUnhealthyTasks(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulAppWithUnhealthyInstances("/test/app", 1, 1)
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	consulStub.Register(&app.Tasks[0], app)
+	drifted := *consulStub.Registration("test_app.0_test.app_8080")
+	drifted.Port = 9999
+	consulStub.Overwrite(&drifted)
+	sync := newSyncWithDefaultConfig(marathon, consulStub)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 9999, consulStub.Registration("test_app.0_test.app_8080").Port)
+}
+
+func TestDriftedFields(t *testing.T) {
+	t.Parallel()
+	// given
+	expected := &service.Service{Name: "name", Port: 1, Address: "127.0.0.1", Tags: []string{"a", "b"}, Checks: 1}
+
+	// expect
+	assert.Empty(t, driftedFields(expected, &service.Service{Name: "name", Port: 1, Address: "127.0.0.1", Tags: []string{"b", "a"}, Checks: 1}))
+	assert.Equal(t, []string{"name", "port", "address", "tags", "checks"},
+		driftedFields(expected, &service.Service{Name: "other", Port: 2, Address: "127.0.0.2", Tags: []string{"a"}, Checks: 0}))
+	assert.Equal(t, []string{"tags"},
+		driftedFields(expected, &service.Service{Name: "name", Port: 1, Address: "127.0.0.1", Tags: []string{"a", "c"}, Checks: 1}))
+
+	// given
+	expected.EnableTagOverride = true
+
+	// expect
+	assert.Empty(t, driftedFields(expected, &service.Service{Name: "name", Port: 1, Address: "127.0.0.1", Tags: []string{"a", "c"}, Checks: 1}))
+}
+
+func TestSync_ShouldKeepOverriddenTagsOfServicesWithTagOverride(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 1)
+	app.Labels[apps.EnableTagOverrideLabel] = "true"
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	consulStub.Register(&app.Tasks[0], app)
+	overridden := *consulStub.Registration("test_app.0_test.app_8080")
+	overridden.Tags = []string{"marathon", "overridden", "marathon-task:test_app.0"}
+	consulStub.Overwrite(&overridden)
+	sync := newSyncWithDefaultConfig(marathon, consulStub)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"marathon", "overridden", "marathon-task:test_app.0"}, consulStub.Registration("test_app.0_test.app_8080").Tags)
+}
+
+func TestSync_ShouldDeregisterServicesOfTasksUnhealthyBeyondGracePeriod(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 2)
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		consulStub.Register(&task, app)
+	}
+	app.Tasks[0].HealthCheckResults = []apps.HealthCheckResult{{
+		Alive:       false,
+		LastSuccess: time.Now().Add(-10 * time.Minute).Format(time.RFC3339Nano),
+	}}
+	unhealthyTasks, _ := service.NewUnhealthyTaskHandler(consulStub, service.UnhealthyDeregister, 5*time.Minute)
+	sync := New(Config{Enabled: true, Leader: "localhost:8080"}, marathon, consulStub, noopSyncStartedListener, unhealthyTasks)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []apps.TaskID{"test_app.1"}, consulStub.RegisteredTaskIDs("test.app"))
+}
+
+func TestSync_ShouldKeepServicesOfTasksUnhealthyWithinGracePeriod(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 1)
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	consulStub.Register(&app.Tasks[0], app)
+	app.Tasks[0].HealthCheckResults = []apps.HealthCheckResult{{
+		Alive:       false,
+		LastSuccess: time.Now().Add(-time.Minute).Format(time.RFC3339Nano),
+	}}
+	unhealthyTasks, _ := service.NewUnhealthyTaskHandler(consulStub, service.UnhealthyDeregister, 5*time.Minute)
+	sync := New(Config{Enabled: true, Leader: "localhost:8080"}, marathon, consulStub, noopSyncStartedListener, unhealthyTasks)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []apps.TaskID{"test_app.0"}, consulStub.RegisteredTaskIDs("test.app"))
+}
+
+func TestSync_ShouldPutServicesOfUnhealthyTasksIntoMaintenanceUntilRecovered(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 1)
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	consulStub.Register(&app.Tasks[0], app)
+	app.Tasks[0].HealthCheckResults = []apps.HealthCheckResult{{
+		Alive:       false,
+		LastSuccess: time.Now().Add(-10 * time.Minute).Format(time.RFC3339Nano),
+	}}
+	unhealthyTasks, _ := service.NewUnhealthyTaskHandler(consulStub, service.UnhealthyMaintenance, 5*time.Minute)
+	sync := New(Config{Enabled: true, Leader: "localhost:8080"}, marathon, consulStub, noopSyncStartedListener, unhealthyTasks)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, service.UnhealthyMaintenanceReason, consulStub.MaintenanceReason("test_app.0_test.app_8080"))
+
+	// when
+	app.Tasks[0].HealthCheckResults = []apps.HealthCheckResult{{Alive: true}}
+	err = sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, consulStub.MaintenanceReason("test_app.0_test.app_8080"))
+	assert.Equal(t, []apps.TaskID{"test_app.0"}, consulStub.RegisteredTaskIDs("test.app"))
+}
+
+func TestSync_ShouldRegisterRunningTasksOfAppsWithRunningRegistrationPolicy(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulAppWithUnhealthyInstances("/test/app", 2, 2)
+	app.Labels[apps.RegistrationPolicyLabel] = apps.RunningPolicy
+	app.Tasks[0].State = "TASK_RUNNING"
+	app.Tasks[1].State = "TASK_STAGING"
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	sync := newSyncWithDefaultConfig(marathon, consulStub)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []apps.TaskID{"test_app.0"}, consulStub.RegisteredTaskIDs("test.app"))
+}
+
+func TestSync_ShouldNotRegisterAgainServicesOfDrainingTasks(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 2)
+	app.Tasks[0].State = "TASK_RUNNING"
+	app.Tasks[1].State = "TASK_KILLING"
+	marathon := marathon.MarathonerStubForApps(app)
+	consulStub := consul.NewConsulStub()
+	consulStub.Register(&app.Tasks[0], app)
+	sync := newSyncWithDefaultConfig(marathon, consulStub)
+
+	// when
+	err := sync.SyncServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []apps.TaskID{"test_app.0"}, consulStub.RegisteredTaskIDs("test.app"))
+}
+
+func TestSyncApp_ShouldDeregisterServicesOfTasksNoLongerRunning(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 2)
+	consulStub := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		consulStub.Register(&task, app)
+	}
+	app.Tasks = app.Tasks[:1]
+	sync := newSyncWithDefaultConfig(marathon.MarathonerStubForApps(app), consulStub)
+
+	// when
+	err := sync.SyncApp("/test/app", service.Trigger{Type: service.TriggerEvent})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []apps.TaskID{"test_app.0"}, consulStub.RegisteredTaskIDs("test.app"))
+}
+
+func TestSyncApp_ShouldNotRepairRegistrationsOfTasksRunningOlderConfig(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 1)
+	app.Tasks[0].Version = "2017-01-01T10:00:00.000Z"
+	app.VersionInfo.LastConfigChangeAt = "2017-01-02T10:00:00.000Z"
+	consulStub := consul.NewConsulStub()
+	consulStub.Register(&app.Tasks[0], app)
+	sync := newSyncWithDefaultConfig(marathon.MarathonerStubForApps(app), consulStub)
+
+	// when
+	app.Labels["consul"] = "new-name"
+	err := sync.SyncApp("/test/app", service.Trigger{Type: service.TriggerEvent})
+
+	// then
+	assert.NoError(t, err)
+	services, _ := consulStub.GetAllServices()
+	assert.Len(t, services, 1)
+	assert.Equal(t, "test.app", services[0].Name)
+}
+
+func TestSyncApp_ShouldDeregisterServicesOfAppWithoutConsulLabel(t *testing.T) {
+	t.Parallel()
+	// given
+	app := ConsulApp("/test/app", 2)
+	consulStub := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		consulStub.Register(&task, app)
+	}
+	delete(app.Labels, apps.MarathonConsulLabel)
+	sync := newSyncWithDefaultConfig(marathon.MarathonerStubForApps(app), consulStub)
+
+	// when
+	err := sync.SyncApp("/test/app", service.Trigger{Type: service.TriggerEvent})
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, consulStub.RegisteredTaskIDs("test.app"))
+}
+
+func TestSyncApp_ShouldFailWhenAppCanNotBeFetched(t *testing.T) {
+	t.Parallel()
+	// given
+	sync := newSyncWithDefaultConfig(marathon.MarathonerStubForApps(), consul.NewConsulStub())
+
+	// when
+	err := sync.SyncApp("/test/app", service.Trigger{Type: service.TriggerEvent})
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestSync_RestrictedToGroups(t *testing.T) {
+	t.Parallel()
+	// given
+	prodApp := ConsulApp("/prod/app", 1)
+	devApp := ConsulApp("/dev/app", 1)
+	goneProdApp := ConsulApp("/prod/gone", 1)
+	goneDevApp := ConsulApp("/dev/gone", 1)
+	consul := consul.NewConsulStub()
+	consul.Register(&goneProdApp.Tasks[0], goneProdApp)
+	consul.Register(&goneDevApp.Tasks[0], goneDevApp)
+	marathonSync := New(Config{Enabled: true, Force: true, Groups: "/prod"}, marathon.MarathonerStubForApps(prodApp, devApp),
+		consul, noopSyncStartedListener, keepUnhealthyTasks)
+
+	// when
+	marathonSync.SyncServices()
+
+	// then
+	services, _ := consul.GetAllServices()
+	var names []string
+	for _, s := range services {
+		names = append(names, s.Name)
+	}
+	assert.Len(t, names, 2)
+	assert.Contains(t, names, "prod.app")
+	assert.Contains(t, names, "dev.gone")
+}
@@ -0,0 +1,14 @@
+package vault
+
+import "github.com/allegro/marathon-consul/time"
+
+type Config struct {
+	Address             string
+	Token               string
+	TokenFile           string
+	AppRoleMount        string
+	AppRoleID           string
+	AppRoleSecretID     string
+	AppRoleSecretIDFile string
+	Timeout             time.Interval
+}
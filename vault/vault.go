@@ -0,0 +1,301 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/metrics"
+)
+
+// Vault reads secrets from the KV version 2 secrets engine. It authenticates with a token or AppRole
+// and renews its token once half of its lease has passed, logging in again when it can not be renewed.
+type Vault struct {
+	sync.Mutex
+	config  Config
+	client  *http.Client
+	token   string
+	renewAt time.Time
+	// renewable tokens are renewed, others are replaced by logging in again
+	renewable bool
+}
+
+type auth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+type authResponse struct {
+	Auth *auth `json:"auth"`
+}
+
+type lookupResponse struct {
+	Data struct {
+		TTL       int64 `json:"ttl"`
+		Renewable bool  `json:"renewable"`
+	} `json:"data"`
+}
+
+type kvResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// forbiddenError means Vault rejected the token
+type forbiddenError struct {
+	error
+}
+
+func New(config Config) (*Vault, error) {
+	if config.Address == "" {
+		return nil, errors.New("Vault address is not configured")
+	}
+	if config.Token == "" && config.TokenFile == "" && config.AppRoleID == "" {
+		return nil, errors.New("Vault token, token file or AppRole ID must be configured")
+	}
+	if config.AppRoleMount == "" {
+		config.AppRoleMount = "approle"
+	}
+	return &Vault{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout.Duration},
+	}, nil
+}
+
+// Read returns the value of the key of a KV version 2 secret referenced as <mount>/<path>#<key>,
+// e.g. secret/marathon-consul#consul-token
+func (v *Vault) Read(reference string) (string, error) {
+	mount, path, key, err := parseReference(reference)
+	if err != nil {
+		return "", err
+	}
+	secret, err := v.read(mount, path)
+	if err != nil {
+		return "", err
+	}
+	raw, ok := secret[key]
+	if !ok {
+		metrics.Mark("vault.read.error")
+		return "", fmt.Errorf("Vault secret %s has no %s key", mount+"/"+path, key)
+	}
+	value, ok := raw.(string)
+	if !ok {
+		metrics.Mark("vault.read.error")
+		return "", fmt.Errorf("Key %s of Vault secret %s is not a string", key, mount+"/"+path)
+	}
+	return value, nil
+}
+
+// ReadAll returns all keys of a KV version 2 secret at <mount>/<path>, e.g. secret/marathon-consul/tokens
+func (v *Vault) ReadAll(secretPath string) (map[string]string, error) {
+	mount, path, err := parsePath(secretPath)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := v.read(mount, path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(secret))
+	for key, value := range secret {
+		text, ok := value.(string)
+		if !ok {
+			metrics.Mark("vault.read.error")
+			return nil, fmt.Errorf("Key %s of Vault secret %s is not a string", key, secretPath)
+		}
+		values[key] = text
+	}
+	return values, nil
+}
+
+func (v *Vault) read(mount string, path string) (map[string]interface{}, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	secret := &kvResponse{}
+	err := v.authorized(func() error {
+		return v.request("GET", fmt.Sprintf("/v1/%s/data/%s", mount, path), nil, secret)
+	})
+	if err != nil {
+		metrics.Mark("vault.read.error")
+		return nil, fmt.Errorf("Unable to read Vault secret %s: %s", mount+"/"+path, err)
+	}
+	return secret.Data.Data, nil
+}
+
+func parseReference(reference string) (mount string, path string, key string, err error) {
+	hash := strings.LastIndex(reference, "#")
+	if hash < 0 {
+		return "", "", "", fmt.Errorf("Invalid Vault secret reference %q, expected <mount>/<path>#<key>", reference)
+	}
+	mount, path, err = parsePath(reference[:hash])
+	key = reference[hash+1:]
+	if err != nil || key == "" {
+		return "", "", "", fmt.Errorf("Invalid Vault secret reference %q, expected <mount>/<path>#<key>", reference)
+	}
+	return mount, path, key, nil
+}
+
+func parsePath(secretPath string) (mount string, path string, err error) {
+	slash := strings.Index(secretPath, "/")
+	if slash <= 0 {
+		return "", "", fmt.Errorf("Invalid Vault secret path %q, expected <mount>/<path>", secretPath)
+	}
+	mount, path = secretPath[:slash], strings.Trim(secretPath[slash+1:], "/")
+	if path == "" {
+		return "", "", fmt.Errorf("Invalid Vault secret path %q, expected <mount>/<path>", secretPath)
+	}
+	return mount, path, nil
+}
+
+// authorized calls the request with a valid token, it is called again with a new token when Vault rejects the token
+func (v *Vault) authorized(request func() error) error {
+	if err := v.ensureToken(); err != nil {
+		return err
+	}
+	err := request()
+	if _, ok := err.(forbiddenError); ok {
+		log.WithError(err).Info("Vault rejected the token, authenticating again")
+		v.token = ""
+		if err := v.ensureToken(); err != nil {
+			return err
+		}
+		return request()
+	}
+	return err
+}
+
+func (v *Vault) ensureToken() error {
+	if v.token == "" {
+		return v.login()
+	}
+	if v.renewAt.IsZero() || time.Now().Before(v.renewAt) {
+		return nil
+	}
+	if v.renewable {
+		err := v.renew()
+		if err == nil {
+			return nil
+		}
+		log.WithError(err).Warn("Unable to renew Vault token")
+	}
+	if v.config.AppRoleID == "" {
+		// static tokens can not be replaced, they are used until Vault rejects them
+		return nil
+	}
+	return v.login()
+}
+
+func (v *Vault) login() error {
+	if v.config.AppRoleID != "" {
+		return v.loginWithAppRole()
+	}
+	token := v.config.Token
+	if v.config.TokenFile != "" {
+		content, err := ioutil.ReadFile(v.config.TokenFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read Vault token: %s", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	v.token = token
+	lookup := &lookupResponse{}
+	if err := v.request("GET", "/v1/auth/token/lookup-self", nil, lookup); err != nil {
+		v.token = ""
+		return fmt.Errorf("Unable to look up Vault token: %s", err)
+	}
+	v.setLease(lookup.Data.TTL, lookup.Data.Renewable)
+	log.WithField("TTL", lookup.Data.TTL).Info("Using Vault token")
+	return nil
+}
+
+func (v *Vault) loginWithAppRole() error {
+	secretID := v.config.AppRoleSecretID
+	if v.config.AppRoleSecretIDFile != "" {
+		content, err := ioutil.ReadFile(v.config.AppRoleSecretIDFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read Vault AppRole secret ID: %s", err)
+		}
+		secretID = strings.TrimSpace(string(content))
+	}
+	body, _ := json.Marshal(map[string]string{"role_id": v.config.AppRoleID, "secret_id": secretID})
+
+	v.token = ""
+	response := &authResponse{}
+	var err error
+	metrics.Time("vault.login", func() {
+		err = v.request("POST", fmt.Sprintf("/v1/auth/%s/login", v.config.AppRoleMount), body, response)
+	})
+	if err != nil || response.Auth == nil || response.Auth.ClientToken == "" {
+		metrics.Mark("vault.login.error")
+		if err == nil {
+			err = errors.New("malformed response")
+		}
+		return fmt.Errorf("Unable to log in to Vault with AppRole: %s", err)
+	}
+	v.token = response.Auth.ClientToken
+	v.setLease(response.Auth.LeaseDuration, response.Auth.Renewable)
+	log.WithField("RoleID", v.config.AppRoleID).WithField("LeaseDuration", response.Auth.LeaseDuration).
+		Info("Logged in to Vault with AppRole")
+	return nil
+}
+
+func (v *Vault) renew() error {
+	response := &authResponse{}
+	if err := v.request("POST", "/v1/auth/token/renew-self", []byte("{}"), response); err != nil {
+		metrics.Mark("vault.renew.error")
+		return err
+	}
+	if response.Auth == nil {
+		metrics.Mark("vault.renew.error")
+		return errors.New("malformed response")
+	}
+	metrics.Mark("vault.renew")
+	v.setLease(response.Auth.LeaseDuration, response.Auth.Renewable)
+	log.WithField("LeaseDuration", response.Auth.LeaseDuration).Debug("Renewed Vault token")
+	return nil
+}
+
+// setLease schedules token renewal after half of its lease, tokens without lease are never renewed
+func (v *Vault) setLease(seconds int64, renewable bool) {
+	v.renewable = renewable
+	if seconds <= 0 {
+		v.renewAt = time.Time{}
+		return
+	}
+	v.renewAt = time.Now().Add(time.Duration(seconds) * time.Second / 2)
+}
+
+func (v *Vault) request(method string, path string, body []byte, decoded interface{}) error {
+	request, err := http.NewRequest(method, strings.TrimRight(v.config.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		request.Header.Set("X-Vault-Token", v.token)
+	}
+	response, err := v.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusForbidden:
+		return forbiddenError{fmt.Errorf("%s %s: permission denied", method, path)}
+	case response.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s %s: expected 200 but got %d", method, path, response.StatusCode)
+	}
+	if err := json.NewDecoder(response.Body).Decode(decoded); err != nil {
+		return fmt.Errorf("%s %s: malformed response: %s", method, path, err)
+	}
+	return nil
+}
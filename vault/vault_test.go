@@ -0,0 +1,284 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubVault stands in for Vault serving token and AppRole auth and a single KV version 2 secret
+type stubVault struct {
+	sync.Mutex
+	*httptest.Server
+	tokens    map[string]bool
+	secret    map[string]interface{}
+	lease     int64
+	renewable bool
+	calls     map[string]int
+}
+
+func newStubVault(secret map[string]interface{}) *stubVault {
+	stub := &stubVault{
+		tokens:    map[string]bool{"static-token": true},
+		secret:    secret,
+		lease:     3600,
+		renewable: true,
+		calls:     make(map[string]int),
+	}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.handle))
+	return stub
+}
+
+func (s *stubVault) handle(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.calls[r.Method+" "+r.URL.Path]++
+
+	if r.Method == "POST" && r.URL.Path == "/v1/auth/approle/login" {
+		credentials := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials["role_id"] != "role" || credentials["secret_id"] != "secret-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.writeAuth(w, fmt.Sprintf("approle-token-%d", len(s.tokens)))
+		return
+	}
+	token := r.Header.Get("X-Vault-Token")
+	if !s.tokens[token] {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/auth/token/lookup-self":
+		fmt.Fprintf(w, `{"data": {"ttl": %d, "renewable": %t}}`, s.lease, s.renewable)
+	case r.Method == "POST" && r.URL.Path == "/v1/auth/token/renew-self":
+		s.writeAuth(w, token)
+	case r.Method == "GET" && r.URL.Path == "/v1/secret/data/marathon-consul":
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": s.secret}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *stubVault) writeAuth(w http.ResponseWriter, token string) {
+	s.tokens[token] = true
+	json.NewEncoder(w).Encode(authResponse{Auth: &auth{ClientToken: token, LeaseDuration: s.lease, Renewable: s.renewable}})
+}
+
+func (s *stubVault) callsOf(call string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.calls[call]
+}
+
+func (s *stubVault) revoke(token string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.tokens, token)
+}
+
+func TestRead_WithToken(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"consul-token": "secret"})
+	defer stub.Close()
+	vault, err := New(Config{Address: stub.URL, Token: "static-token"})
+	require.NoError(t, err)
+
+	// when
+	value, err := vault.Read("secret/marathon-consul#consul-token")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "secret", value)
+	assert.Equal(t, 1, stub.callsOf("GET /v1/auth/token/lookup-self"))
+}
+
+func TestRead_WithAppRole(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"password": "secret"})
+	defer stub.Close()
+	dir, _ := ioutil.TempDir("", "vault")
+	defer os.RemoveAll(dir)
+	secretIDFile := filepath.Join(dir, "secret-id")
+	require.NoError(t, ioutil.WriteFile(secretIDFile, []byte("secret-id\n"), 0600))
+	vault, err := New(Config{Address: stub.URL, AppRoleID: "role", AppRoleSecretIDFile: secretIDFile})
+	require.NoError(t, err)
+
+	// when
+	first, err := vault.Read("secret/marathon-consul#password")
+	require.NoError(t, err)
+	second, err := vault.Read("secret/marathon-consul#password")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "secret", first)
+	assert.Equal(t, "secret", second)
+	assert.Equal(t, 1, stub.callsOf("POST /v1/auth/approle/login"))
+}
+
+func TestRead_RenewsTokenAfterHalfOfLease(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"password": "secret"})
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, AppRoleID: "role", AppRoleSecretID: "secret-id"})
+	_, err := vault.Read("secret/marathon-consul#password")
+	require.NoError(t, err)
+	vault.renewAt = time.Now().Add(-time.Second)
+
+	// when
+	_, err = vault.Read("secret/marathon-consul#password")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 1, stub.callsOf("POST /v1/auth/token/renew-self"))
+	assert.Equal(t, 1, stub.callsOf("POST /v1/auth/approle/login"))
+	assert.True(t, vault.renewAt.After(time.Now()))
+}
+
+func TestRead_LogsInAgainWhenTokenIsNotRenewable(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"password": "secret"})
+	stub.renewable = false
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, AppRoleID: "role", AppRoleSecretID: "secret-id"})
+	_, err := vault.Read("secret/marathon-consul#password")
+	require.NoError(t, err)
+	vault.renewAt = time.Now().Add(-time.Second)
+
+	// when
+	_, err = vault.Read("secret/marathon-consul#password")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 0, stub.callsOf("POST /v1/auth/token/renew-self"))
+	assert.Equal(t, 2, stub.callsOf("POST /v1/auth/approle/login"))
+}
+
+func TestRead_LogsInAgainWhenTokenIsRejected(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"password": "secret"})
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, AppRoleID: "role", AppRoleSecretID: "secret-id"})
+	_, err := vault.Read("secret/marathon-consul#password")
+	require.NoError(t, err)
+	stub.revoke(vault.token)
+
+	// when
+	value, err := vault.Read("secret/marathon-consul#password")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "secret", value)
+	assert.Equal(t, 2, stub.callsOf("POST /v1/auth/approle/login"))
+}
+
+func TestRead_FailsForRejectedStaticToken(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"password": "secret"})
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, Token: "unknown-token"})
+
+	// when
+	_, err := vault.Read("secret/marathon-consul#password")
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestRead_FailsForMissingKey(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"password": "secret"})
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, Token: "static-token"})
+
+	// when
+	_, err := vault.Read("secret/marathon-consul#token")
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestReadAll(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"payments": "payments-token", "search": "search-token"})
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, Token: "static-token"})
+
+	// when
+	values, err := vault.ReadAll("secret/marathon-consul")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"payments": "payments-token", "search": "search-token"}, values)
+}
+
+func TestReadAll_FailsForInvalidPath(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"payments": "payments-token"})
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, Token: "static-token"})
+
+	// when
+	_, err := vault.ReadAll("secret")
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestReadAll_FailsForNonStringValue(t *testing.T) {
+	t.Parallel()
+	// given
+	stub := newStubVault(map[string]interface{}{"payments": 42})
+	defer stub.Close()
+	vault, _ := New(Config{Address: stub.URL, Token: "static-token"})
+
+	// when
+	_, err := vault.ReadAll("secret/marathon-consul")
+
+	// then
+	require.Error(t, err)
+	assert.Equal(t, "Key payments of Vault secret secret/marathon-consul is not a string", err.Error())
+}
+
+func TestNew_RequiresAddressAndAuth(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(Config{Token: "token"})
+	assert.Error(t, err)
+
+	_, err = New(Config{Address: "http://localhost:8200"})
+	assert.Error(t, err)
+}
+
+func TestParseReference(t *testing.T) {
+	t.Parallel()
+
+	mount, path, key, err := parseReference("secret/team/marathon-consul#consul-token")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret", "team/marathon-consul", "consul-token"}, []string{mount, path, key})
+
+	for _, invalid := range []string{"secret/marathon-consul", "secret#key", "/path#key", "secret/#key", "secret/path#"} {
+		_, _, _, err := parseReference(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/audit"
+)
+
+// Number of entries returned by the audit handler when the request does not limit them
+const defaultAuditLimit = 100
+
+// AuditHandler returns recent audit log entries, the newest first, filtered by app, task and action parameters
+func AuditHandler(auditLog *audit.Log) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		query := audit.Query{
+			AppID:  apps.AppID(params.Get("app")),
+			TaskID: apps.TaskID(params.Get("task")),
+			Action: params.Get("action"),
+			Limit:  defaultAuditLimit,
+		}
+		if limit := params.Get("limit"); limit != "" {
+			parsed, err := strconv.Atoi(limit)
+			if err != nil || parsed < 0 {
+				http.Error(w, "limit must be a non-negative number", http.StatusBadRequest)
+				return
+			}
+			query.Limit = parsed
+		}
+		body, _ := json.Marshal(auditLog.Recent(query))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
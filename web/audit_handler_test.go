@@ -0,0 +1,51 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/allegro/marathon-consul/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditHandler(t *testing.T) {
+	t.Parallel()
+
+	// given
+	auditLog, _ := audit.New(audit.Config{Recent: 10})
+	auditLog.Record(audit.Entry{Action: audit.ActionRegister, AppID: "/a", TaskID: "a.1", Result: audit.ResultSuccess})
+	auditLog.Record(audit.Entry{Action: audit.ActionDeregister, AppID: "/a", TaskID: "a.1", Result: audit.ResultSuccess})
+	auditLog.Record(audit.Entry{Action: audit.ActionRegister, AppID: "/b", TaskID: "b.1", Result: audit.ResultSuccess})
+	handler := AuditHandler(auditLog)
+	req, _ := http.NewRequest("GET", "http://example.com/audit?app=/a&limit=1", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler(recorder, req)
+
+	// then
+	assert.Equal(t, 200, recorder.Code)
+	var entries []audit.Entry
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, audit.ActionDeregister, entries[0].Action)
+}
+
+func TestAuditHandler_InvalidLimit(t *testing.T) {
+	t.Parallel()
+
+	// given
+	auditLog, _ := audit.New(audit.Config{Recent: 10})
+	handler := AuditHandler(auditLog)
+	req, _ := http.NewRequest("GET", "http://example.com/audit?limit=all", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler(recorder, req)
+
+	// then
+	assert.Equal(t, 400, recorder.Code)
+}
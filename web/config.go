@@ -1,8 +1,22 @@
 package web
 
+import (
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/time"
+)
+
 type Config struct {
-	Listen       string
-	QueueSize    int
-	WorkersCount int
-	MaxEventSize int64
+	Listen            string
+	QueueSize         int
+	WorkersCount      int
+	MaxEventSize      int64
+	DrainDelay        time.Interval
+	DrainDeployments  bool
+	MaxEventAge       time.Interval
+	DedupWindow       int
+	QueueOverflow     string
+	QueueBlockTimeout time.Interval
+	QueueSpillDir     string
+	// MetricsScope labels metrics of events of the Marathon cluster, it is not read from the configuration
+	MetricsScope metrics.Scope `json:"-"`
 }
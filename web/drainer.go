@@ -0,0 +1,88 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/service"
+)
+
+// Reason of maintenance mode enabled for services of tasks being killed
+const DrainMaintenanceReason = "marathon-consul: task is being killed"
+
+// drainer takes services of tasks being killed out of discovery and deregisters them after a delay,
+// so in-flight requests can complete. It is shared by all event handlers.
+type drainer struct {
+	sync.Mutex
+	serviceRegistry service.ServiceRegistry
+	delay           time.Duration
+	deployments     bool
+	draining        map[apps.TaskID]struct{}
+	metrics         metrics.Scope
+}
+
+func newDrainer(serviceRegistry service.ServiceRegistry, delay time.Duration, deployments bool, scope metrics.Scope) *drainer {
+	return &drainer{
+		serviceRegistry: serviceRegistry,
+		delay:           delay,
+		deployments:     deployments,
+		draining:        make(map[apps.TaskID]struct{}),
+		metrics:         scope,
+	}
+}
+
+// drain enables maintenance of the task services and schedules their deregistration.
+// Without the delay services are deregistered immediately. Both changes are attributed to the trigger.
+func (d *drainer) drain(taskID apps.TaskID, trigger service.Trigger) error {
+	registry := service.WithTrigger(d.serviceRegistry, trigger)
+	if d.delay <= 0 {
+		return d.deregister(taskID, registry)
+	}
+
+	d.Lock()
+	if _, ok := d.draining[taskID]; ok {
+		d.Unlock()
+		log.WithField("Id", taskID).Debug("Task is already draining")
+		return nil
+	}
+	d.draining[taskID] = struct{}{}
+	d.Unlock()
+
+	log.WithField("Id", taskID).WithField("DrainDelay", d.delay).Info("Draining task")
+	d.metrics.Mark("events.drain")
+	time.AfterFunc(d.delay, func() { d.finish(taskID, registry) })
+
+	err := registry.EnableMaintenanceByTask(taskID, DrainMaintenanceReason)
+	if err != nil {
+		log.WithField("Id", taskID).WithError(err).Error("There was a problem enabling maintenance of task")
+	}
+	return err
+}
+
+// forget cancels pending deregistration of the task, e.g. when it has already been deregistered
+func (d *drainer) forget(taskID apps.TaskID) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.draining, taskID)
+}
+
+func (d *drainer) finish(taskID apps.TaskID, registry service.ServiceRegistry) {
+	d.Lock()
+	_, ok := d.draining[taskID]
+	delete(d.draining, taskID)
+	d.Unlock()
+	if ok {
+		d.deregister(taskID, registry)
+	}
+}
+
+func (d *drainer) deregister(taskID apps.TaskID, registry service.ServiceRegistry) error {
+	err := registry.DeregisterByTask(taskID)
+	if err != nil {
+		log.WithField("Id", taskID).WithError(err).Error("There was a problem deregistering task")
+	}
+	return err
+}
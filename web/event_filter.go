@@ -0,0 +1,90 @@
+package web
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/events"
+	"github.com/hashicorp/golang-lru"
+)
+
+// Reasons events are dropped for, used as metric names suffixes
+const (
+	staleEventDrop     = "stale"
+	duplicateEventDrop = "duplicate"
+	terminalEventDrop  = "terminal"
+)
+
+// Task statuses after which the task will never run again
+var terminalTaskStatuses = map[string]struct{}{
+	"TASK_FINISHED": {},
+	"TASK_FAILED":   {},
+	"TASK_KILLED":   {},
+	"TASK_LOST":     {},
+	"TASK_ERROR":    {},
+	"TASK_DROPPED":  {},
+	"TASK_GONE":     {},
+}
+
+// eventFilter drops events not worth processing: events older than max age, events redelivered by Marathon
+// (e.g. on reconnect) and events that could register tasks already observed in a terminal state.
+// Seen events and terminal tasks are remembered in bounded LRU windows.
+type eventFilter struct {
+	maxAge        time.Duration
+	seen          *lru.Cache
+	terminalTasks *lru.Cache
+}
+
+// newEventFilter creates a filter, zero max age or window disables the corresponding checks
+func newEventFilter(maxAge time.Duration, window int) *eventFilter {
+	filter := &eventFilter{maxAge: maxAge}
+	if window > 0 {
+		// lru.New fails only for non positive size
+		filter.seen, _ = lru.New(window)
+		filter.terminalTasks, _ = lru.New(window)
+	}
+	return filter
+}
+
+// dropReason returns the reason the event should be dropped for, or an empty string when it should be processed
+func (f *eventFilter) dropReason(e events.Event, now time.Time) string {
+	if f.maxAge > 0 && now.Sub(e.Timestamp.Time) > f.maxAge {
+		return staleEventDrop
+	}
+	taskID := e.TaskID()
+	if f.seen == nil || taskID == "" {
+		return ""
+	}
+	if f.seen.Contains(eventKey(e, taskID)) {
+		return duplicateEventDrop
+	}
+
+	if _, terminal := terminalTaskStatuses[e.TaskStatus]; terminal {
+		f.terminalTasks.Add(taskID, struct{}{})
+		return ""
+	}
+	if f.terminalTasks.Contains(taskID) && f.mayRegister(e) {
+		return terminalEventDrop
+	}
+	return ""
+}
+
+// accepted remembers the event as seen. It is called once the event is queued, so events dropped
+// afterwards, e.g. because the queue is full, are not rejected as duplicates when Marathon redelivers them.
+func (f *eventFilter) accepted(e events.Event) {
+	taskID := e.TaskID()
+	if f.seen == nil || taskID == "" {
+		return
+	}
+	f.seen.Add(eventKey(e, taskID), struct{}{})
+}
+
+// mayRegister tells whether processing the event could register the task
+func (f *eventFilter) mayRegister(e events.Event) bool {
+	return e.Type == healthStatusChangedEventType || (e.Type == statusUpdateEventType && e.TaskStatus == "TASK_RUNNING")
+}
+
+func eventKey(e events.Event, taskID apps.TaskID) string {
+	return fmt.Sprintf("%s|%s|%s", taskID, e.Type, e.Timestamp.String())
+}
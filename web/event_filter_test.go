@@ -0,0 +1,80 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func parsedEvent(t *testing.T, body string) events.Event {
+	e, err := events.ParseEvent([]byte(body))
+	assert.NoError(t, err)
+	return e
+}
+
+func TestEventFilter_DropStaleEvents(t *testing.T) {
+	t.Parallel()
+
+	// given
+	filter := newEventFilter(time.Minute, 0)
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// expect
+	assert.Equal(t, staleEventDrop, filter.dropReason(parsedEvent(t, `{"eventType":"status_update_event","timestamp":"2017-01-01T11:58:00.000Z"}`), now))
+	assert.Empty(t, filter.dropReason(parsedEvent(t, `{"eventType":"status_update_event","timestamp":"2017-01-01T11:59:30.000Z"}`), now))
+}
+
+func TestEventFilter_DropDuplicatedTaskEvents(t *testing.T) {
+	t.Parallel()
+
+	// given
+	filter := newEventFilter(0, 10)
+	now := time.Now()
+	first := parsedEvent(t, `{"eventType":"health_status_changed_event","timestamp":"2017-01-01T12:00:00.000Z","taskId":"app.1","alive":true}`)
+	other := parsedEvent(t, `{"eventType":"health_status_changed_event","timestamp":"2017-01-01T12:00:01.000Z","taskId":"app.1","alive":false}`)
+	app := parsedEvent(t, `{"eventType":"app_terminated_event","timestamp":"2017-01-01T12:00:00.000Z","appId":"/app"}`)
+
+	// expect
+	assert.Empty(t, filter.dropReason(first, now))
+	assert.Empty(t, filter.dropReason(first, now), "event not accepted yet")
+	filter.accepted(first)
+	assert.Equal(t, duplicateEventDrop, filter.dropReason(first, now))
+	assert.Empty(t, filter.dropReason(other, now))
+	filter.accepted(app)
+	assert.Empty(t, filter.dropReason(app, now))
+}
+
+func TestEventFilter_DropEventsRegisteringTerminatedTasks(t *testing.T) {
+	t.Parallel()
+
+	// given
+	filter := newEventFilter(0, 10)
+	now := time.Now()
+	killed := parsedEvent(t, `{"eventType":"status_update_event","timestamp":"2017-01-01T12:00:00.000Z","taskId":"app.1","taskStatus":"TASK_KILLED"}`)
+	healthy := parsedEvent(t, `{"eventType":"health_status_changed_event","timestamp":"2017-01-01T11:59:00.000Z","taskId":"app.1","alive":true}`)
+	running := parsedEvent(t, `{"eventType":"status_update_event","timestamp":"2017-01-01T11:58:00.000Z","taskId":"app.1","taskStatus":"TASK_RUNNING"}`)
+	otherTask := parsedEvent(t, `{"eventType":"health_status_changed_event","timestamp":"2017-01-01T11:59:00.000Z","taskId":"app.2","alive":true}`)
+
+	// when
+	reason := filter.dropReason(killed, now)
+
+	// then
+	assert.Empty(t, reason)
+	assert.Equal(t, terminalEventDrop, filter.dropReason(healthy, now))
+	assert.Equal(t, terminalEventDrop, filter.dropReason(running, now))
+	assert.Empty(t, filter.dropReason(otherTask, now))
+}
+
+func TestEventFilter_DisabledFilterAcceptsEverything(t *testing.T) {
+	t.Parallel()
+
+	// given
+	filter := newEventFilter(0, 0)
+	e := parsedEvent(t, `{"eventType":"status_update_event","timestamp":"2000-01-01T12:00:00.000Z","taskId":"app.1","taskStatus":"TASK_KILLED"}`)
+
+	// expect
+	assert.Empty(t, filter.dropReason(e, time.Now()))
+	assert.Empty(t, filter.dropReason(e, time.Now()))
+}
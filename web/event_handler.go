@@ -11,6 +11,7 @@ import (
 	"github.com/allegro/marathon-consul/marathon"
 	"github.com/allegro/marathon-consul/metrics"
 	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
 )
 
 type event struct {
@@ -22,29 +23,40 @@ type event struct {
 type eventHandler struct {
 	id              int
 	serviceRegistry service.ServiceRegistry
+	unhealthyTasks  *service.UnhealthyTaskHandler
+	drainer         *drainer
+	appSyncer       AppSyncer
 	marathon        marathon.Marathoner
 	eventQueue      <-chan event
+	metrics         metrics.Scope
+	// trigger of changes made while handling an event, see withTrigger
+	trigger service.Trigger
 }
 
 type stopEvent struct{}
 
-func newEventHandler(id int, serviceRegistry service.ServiceRegistry, marathon marathon.Marathoner, eventQueue <-chan event) *eventHandler {
+func newEventHandler(id int, serviceRegistry service.ServiceRegistry, unhealthyTasks *service.UnhealthyTaskHandler,
+	drainer *drainer, appSyncer AppSyncer, marathon marathon.Marathoner, eventQueue <-chan event, scope metrics.Scope) *eventHandler {
 	return &eventHandler{
 		id:              id,
 		serviceRegistry: serviceRegistry,
+		unhealthyTasks:  unhealthyTasks,
+		drainer:         drainer,
+		appSyncer:       appSyncer,
 		marathon:        marathon,
 		eventQueue:      eventQueue,
+		metrics:         scope,
 	}
 }
 
 func (fh *eventHandler) start() chan<- stopEvent {
 	var e event
 	process := func() {
-		err := fh.handleEvent(e.eventType, e.body)
+		err := fh.handleEvent(e)
 		if err != nil {
-			metrics.Mark("events.processing.error")
+			fh.metrics.Mark("events.processing.error")
 		} else {
-			metrics.Mark("events.processing.succes")
+			fh.metrics.Mark("events.processing.succes")
 		}
 	}
 
@@ -54,20 +66,20 @@ func (fh *eventHandler) start() chan<- stopEvent {
 		for {
 			select {
 			case e = <-fh.eventQueue:
-				metrics.Mark(fmt.Sprintf("events.handler.%d", fh.id))
+				fh.metrics.Mark(fmt.Sprintf("events.handler.%d", fh.id))
 
 				queueLength := int64(len(fh.eventQueue))
-				metrics.UpdateGauge("events.queue.len", queueLength)
+				fh.metrics.UpdateGauge("events.queue.len", queueLength)
 				queueCapacity := int64(cap(fh.eventQueue))
 
 				utilization := int64(0)
 				if queueCapacity > 0 {
 					utilization = 100 * (queueLength / queueCapacity)
 				}
-				metrics.UpdateGauge("events.queue.util", utilization)
+				fh.metrics.UpdateGauge("events.queue.util", utilization)
 
-				metrics.UpdateGauge("events.queue.delay_ns", time.Since(e.timestamp).Nanoseconds())
-				metrics.Time("events.processing."+e.eventType, process)
+				fh.metrics.UpdateGauge("events.queue.delay_ns", time.Since(e.timestamp).Nanoseconds())
+				fh.metrics.Time("events.processing."+e.eventType, process)
 			case <-quitChan:
 				log.WithField("Id", fh.id).Info("Stopping worker")
 			}
@@ -76,15 +88,21 @@ func (fh *eventHandler) start() chan<- stopEvent {
 	return quitChan
 }
 
-func (fh *eventHandler) handleEvent(eventType string, body []byte) error {
+func (fh *eventHandler) handleEvent(e event) error {
 
-	body = replaceTaskIDWithID(body)
+	eventType := e.eventType
+	body := replaceTaskIDWithID(e.body)
+	fh = fh.withTrigger(service.Trigger{Type: service.TriggerEvent, Event: eventType})
 
 	switch eventType {
 	case statusUpdateEventType:
-		return fh.handleStatusEvent(body)
+		return fh.handleStatusEvent(body, e.timestamp)
 	case healthStatusChangedEventType:
-		return fh.handleHealthyTask(body)
+		return fh.handleHealthyTask(body, e.timestamp)
+	case deploymentInfoEventType, deploymentStepSuccessEventType:
+		return fh.handleDeploymentEvent(body, e.timestamp)
+	case appTerminatedEventType, apiPostEventType:
+		return fh.handleAppEvent(eventType, body)
 	default:
 		err := fmt.Errorf("Unsuported event type: %s", eventType)
 		log.WithError(err).WithField("EventType", eventType).Error("This should never happen. Not handled event type")
@@ -92,7 +110,16 @@ func (fh *eventHandler) handleEvent(eventType string, body []byte) error {
 	}
 }
 
-func (fh *eventHandler) handleHealthyTask(body []byte) error {
+// withTrigger returns the handler changing services through the registry attributing changes to the trigger
+func (fh *eventHandler) withTrigger(trigger service.Trigger) *eventHandler {
+	handler := *fh
+	handler.trigger = trigger
+	handler.serviceRegistry = service.WithTrigger(fh.serviceRegistry, trigger)
+	handler.unhealthyTasks = fh.unhealthyTasks.WithTrigger(trigger)
+	return &handler
+}
+
+func (fh *eventHandler) handleHealthyTask(body []byte, receivedAt time.Time) error {
 	taskHealthChange, err := events.ParseTaskHealthChange(body)
 	if err != nil {
 		log.WithError(err).Error("Body generated error")
@@ -103,12 +130,12 @@ func (fh *eventHandler) handleHealthyTask(body []byte) error {
 	taskID := taskHealthChange.TaskID()
 	log.WithField("Id", taskID).Info("Got HealthStatusEvent")
 
-	if !taskHealthChange.Alive {
+	if !taskHealthChange.Alive && !fh.unhealthyTasks.Enabled() {
 		log.WithField("Id", taskID).Debug("Task is not alive. Not registering")
 		return nil
 	}
 
-	app, err := fh.marathon.App(appID)
+	app, err := fh.app(appID, receivedAt)
 	if err != nil {
 		log.WithField("Id", taskID).WithError(err).Error("There was a problem obtaining app info")
 		return err
@@ -128,19 +155,77 @@ func (fh *eventHandler) handleHealthyTask(body []byte) error {
 		return err
 	}
 
-	if task.IsHealthy() {
-		err := fh.serviceRegistry.Register(&task, app)
-		if err != nil {
-			log.WithField("Id", task.ID).WithError(err).Error("There was a problem registering task")
-			return err
-		}
+	if !taskHealthChange.Alive {
+		return fh.handleUnhealthyTask(&task, app)
+	}
+
+	return fh.register(&task, app)
+}
+
+func (fh *eventHandler) register(task *apps.Task, app *apps.App) error {
+	if policy := app.RegistrationPolicy(); !policy.CanRegister(task) {
+		log.WithField("Id", task.ID).WithField("Policy", policy.Name).Debug("Task does not satisfy registration policy. Not registering")
 		return nil
 	}
-	log.WithField("Id", task.ID).Debug("Task is not healthy. Not registering")
+	err := fh.serviceRegistry.Register(task, app)
+	if err != nil {
+		log.WithField("Id", task.ID).WithError(err).Error("There was a problem registering task")
+		return err
+	}
+	return fh.recoverHealthyTask(task, app)
+}
+
+func (fh *eventHandler) handleUnhealthyTask(task *apps.Task, app *apps.App) error {
+	services, err := fh.unhealthyTasks.TaskServices(task, app)
+	if err != nil {
+		log.WithField("Id", task.ID).WithError(err).Error("There was a problem obtaining services of unhealthy task")
+		return err
+	}
+	left, err := fh.unhealthyTasks.Handle(task, services)
+	if err != nil {
+		log.WithField("Id", task.ID).WithError(err).Error("There was a problem handling unhealthy task")
+		return err
+	}
+	if left > 0 {
+		log.WithField("Id", task.ID).WithField("GracePeriodLeft", left).Info("Task is unhealthy, checking it again after grace period")
+		appID, taskID := app.ID, task.ID
+		time.AfterFunc(left, func() { fh.recheckUnhealthyTask(appID, taskID) })
+	}
 	return nil
 }
 
-func (fh *eventHandler) handleStatusEvent(body []byte) error {
+// recheckUnhealthyTask handles the task again with its current state, unless it has recovered or is gone
+func (fh *eventHandler) recheckUnhealthyTask(appID apps.AppID, taskID apps.TaskID) {
+	app, err := fh.app(appID, time.Now())
+	if err != nil {
+		log.WithField("Id", taskID).WithError(err).Error("There was a problem obtaining app info")
+		return
+	}
+	task, err := findTaskByID(taskID, app.Tasks)
+	if err != nil {
+		log.WithField("Id", taskID).Debug("Unhealthy task is gone")
+		return
+	}
+	if task.IsUnhealthy() {
+		fh.handleUnhealthyTask(&task, app)
+	}
+}
+
+func (fh *eventHandler) recoverHealthyTask(task *apps.Task, app *apps.App) error {
+	if !fh.unhealthyTasks.Enabled() {
+		return nil
+	}
+	services, err := fh.unhealthyTasks.TaskServices(task, app)
+	if err == nil {
+		err = fh.unhealthyTasks.Recover(task, services)
+	}
+	if err != nil {
+		log.WithField("Id", task.ID).WithError(err).Error("There was a problem recovering healthy task")
+	}
+	return err
+}
+
+func (fh *eventHandler) handleStatusEvent(body []byte, receivedAt time.Time) error {
 	task, err := apps.ParseTask(body)
 
 	if err != nil {
@@ -154,7 +239,12 @@ func (fh *eventHandler) handleStatusEvent(body []byte) error {
 	}).Info("Got StatusEvent")
 
 	switch task.TaskStatus {
-	case "TASK_FINISHED", "TASK_FAILED", "TASK_KILLING", "TASK_KILLED", "TASK_LOST":
+	case "TASK_RUNNING":
+		return fh.registerRunningTask(task, receivedAt)
+	case "TASK_KILLING":
+		return fh.drainer.drain(task.ID, fh.trigger)
+	case "TASK_FINISHED", "TASK_FAILED", "TASK_KILLED", "TASK_LOST":
+		fh.drainer.forget(task.ID)
 		return fh.deregister(task.ID)
 	default:
 		log.WithFields(log.Fields{
@@ -165,6 +255,130 @@ func (fh *eventHandler) handleStatusEvent(body []byte) error {
 	}
 }
 
+// registerRunningTask registers the task when the registration policy of its app does not require health checks to pass,
+// otherwise the task is registered on health status change
+func (fh *eventHandler) registerRunningTask(runningTask *apps.Task, receivedAt time.Time) error {
+	app, err := fh.app(runningTask.AppID, receivedAt)
+	if err != nil {
+		log.WithField("Id", runningTask.ID).WithError(err).Error("There was a problem obtaining app info")
+		return err
+	}
+	if !app.IsConsulApp() || app.RegistrationPolicy().Name == apps.HealthyPolicy {
+		log.WithField("Id", runningTask.ID).Debug("Waiting for health status change to register task")
+		return nil
+	}
+	task, err := findTaskByID(runningTask.ID, app.Tasks)
+	if err != nil {
+		log.WithField("Id", runningTask.ID).WithError(err).Error("Task not found")
+		return err
+	}
+	return fh.register(&task, app)
+}
+
+func (fh *eventHandler) handleAppEvent(eventType string, body []byte) error {
+	appEvent, err := events.ParseAppEvent(body)
+	if err != nil {
+		log.WithError(err).WithField("EventType", eventType).Debug("Not an app event")
+		return nil
+	}
+	appID := appEvent.App()
+	log.WithField("Id", appID).WithField("EventType", eventType).Info("Got AppEvent")
+
+	if eventType == appTerminatedEventType {
+		err = fh.appSyncer.DeregisterApp(appID, fh.trigger)
+	} else {
+		err = fh.appSyncer.SyncApp(appID, fh.trigger)
+	}
+	if err != nil {
+		log.WithField("Id", appID).WithError(err).Error("There was a problem syncing app")
+	}
+	return err
+}
+
+// handleDeploymentEvent drains tasks that are going to be killed by the deployment step that starts,
+// i.e. the current step of deployment_info or the step following the succeeded one.
+// Without the drain delay the tasks are deregistered right away.
+func (fh *eventHandler) handleDeploymentEvent(body []byte, receivedAt time.Time) error {
+	if !fh.drainer.deployments {
+		log.Debug("Draining deployments is not enabled. Not handling deployment")
+		return nil
+	}
+	deployment, err := events.ParseDeploymentEvent(body)
+	if err != nil {
+		log.WithError(err).Error("Body generated error")
+		return err
+	}
+
+	step := &deployment.CurrentStep
+	if deployment.Type == deploymentStepSuccessEventType {
+		step = deployment.NextStep()
+	}
+	if step == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, action := range step.Actions {
+		if !action.IsKill() {
+			continue
+		}
+		log.WithField("Id", action.App).WithField("Action", action.Name()).WithField("Deployment", deployment.Plan.ID).
+			Info("Deployment is going to kill tasks")
+		if err := fh.drainApp(action, deployment.Plan.Version, receivedAt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(errs, fmt.Sprintf("draining tasks of deployment %s", deployment.Plan.ID))
+}
+
+func (fh *eventHandler) drainApp(action events.DeploymentAction, targetVersion string, receivedAt time.Time) error {
+	app, err := fh.app(action.App, receivedAt)
+	if err != nil {
+		log.WithField("Id", action.App).WithError(err).Error("There was a problem obtaining app info")
+		return err
+	}
+	if !app.IsConsulApp() {
+		return nil
+	}
+	var errs []error
+	for _, task := range app.Tasks {
+		if action.Name() == events.KillAllOldTasksOfAction && !isOldTask(&task, app, targetVersion) {
+			continue
+		}
+		if err := fh.drainer.drain(task.ID, fh.trigger); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(errs, fmt.Sprintf("draining tasks of app %s", app.ID))
+}
+
+// isOldTask tells whether KillAllOldTasksOf kills the task. Restarts and scaling leave the last configuration
+// change of the app untouched, so tasks are compared with the version the deployment targets, falling back
+// to the last scaling of the app when the event does not carry it.
+func isOldTask(task *apps.Task, app *apps.App, targetVersion string) bool {
+	if targetVersion == "" {
+		targetVersion = app.VersionInfo.LastScalingAt
+	}
+	if targetVersion == "" {
+		return !app.RunsCurrentConfig(task)
+	}
+	return task.Version < targetVersion
+}
+
+// appCache is implemented by Marathon clients sharing app lookups, see marathon.AppCache
+type appCache interface {
+	AppSince(appID apps.AppID, since time.Time) (*apps.App, error)
+}
+
+// app returns the app fetched after the event was received, so it reflects the change the event reports.
+// Such fetch can be shared by all workers handling events of the app.
+func (fh *eventHandler) app(appID apps.AppID, receivedAt time.Time) (*apps.App, error) {
+	if cache, ok := fh.marathon.(appCache); ok {
+		return cache.AppSince(appID, receivedAt)
+	}
+	return fh.marathon.App(appID)
+}
+
 func (fh *eventHandler) deregister(taskID apps.TaskID) error {
 	err := fh.serviceRegistry.DeregisterByTask(taskID)
 	if err != nil {
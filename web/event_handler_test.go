@@ -10,12 +10,17 @@ import (
 	"github.com/allegro/marathon-consul/consul"
 	"github.com/allegro/marathon-consul/marathon"
 	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/sync"
 	. "github.com/allegro/marathon-consul/utils"
 	"github.com/stretchr/testify/assert"
 )
 
 type handlerStubs struct {
 	serviceRegistry service.ServiceRegistry
+	unhealthyTasks  *service.UnhealthyTaskHandler
+	drainDelay      time.Duration
+	drainDeploys    bool
+	appSyncer       AppSyncer
 	marathon        marathon.Marathoner
 }
 
@@ -24,7 +29,14 @@ type handlerStubs struct {
 // Under the hood synchronization function simply sends a stop signal to the handlers stopChan.
 func testEventHandler(stubs handlerStubs) (chan<- event, func()) {
 	queue := make(chan event)
-	awaitChan := newEventHandler(0, stubs.serviceRegistry, stubs.marathon, queue).start()
+	if stubs.unhealthyTasks == nil {
+		stubs.unhealthyTasks, _ = service.NewUnhealthyTaskHandler(stubs.serviceRegistry, service.UnhealthyKeep, 0)
+	}
+	if stubs.appSyncer == nil {
+		stubs.appSyncer = sync.New(sync.Config{}, stubs.marathon, stubs.serviceRegistry, func(apps []*apps.App) {}, stubs.unhealthyTasks)
+	}
+	drainer := newDrainer(stubs.serviceRegistry, stubs.drainDelay, stubs.drainDeploys, "")
+	awaitChan := newEventHandler(0, stubs.serviceRegistry, stubs.unhealthyTasks, drainer, stubs.appSyncer, stubs.marathon, queue, "").start()
 
 	return queue, func() { awaitChan <- stopEvent{} }
 }
@@ -64,7 +76,7 @@ func TestEventHandler_NotHandleStatusEventAboutStartingTask(t *testing.T) {
 	serviceRegistry := consul.NewConsulStub()
 	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry})
 
-	ignoredTaskStatuses := []string{"TASK_STAGING", "TASK_STARTING", "TASK_RUNNING", "unknown"}
+	ignoredTaskStatuses := []string{"TASK_STAGING", "TASK_STARTING", "unknown"}
 	for _, taskStatus := range ignoredTaskStatuses {
 		body := []byte(`{
 		  "slaveId":"85e59460-a99e-4f16-b91f-145e0ea595bd-S0",
@@ -90,6 +102,41 @@ func TestEventHandler_NotHandleStatusEventAboutStartingTask(t *testing.T) {
 	}
 }
 
+func TestEventHandler_HandleStatusEventAboutRunningTaskAccordingToRegistrationPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{"", "healthy", "running"} {
+		// given
+		app := ConsulAppWithUnhealthyInstances("/test/app", 1, 1)
+		app.Labels[apps.RegistrationPolicyLabel] = policy
+		app.Tasks[0].State = "TASK_RUNNING"
+		marathon := marathon.MarathonerStubForApps(app)
+		serviceRegistry := consul.NewConsulStub()
+		queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon})
+
+		body := []byte(`{
+		  "taskId":"test_app.0",
+		  "taskStatus":"TASK_RUNNING",
+		  "appId":"/test/app",
+		  "host":"localhost",
+		  "ports":[8080],
+		  "eventType":"status_update_event"
+		}`)
+
+		// when
+		queue <- event{eventType: "status_update_event", timestamp: time.Now(), body: body}
+		awaitFunc()
+
+		// then
+		assert.True(t, marathon.Interactions())
+		if policy == "running" {
+			assert.Equal(t, []apps.TaskID{"test_app.0"}, serviceRegistry.RegisteredTaskIDs("test.app"))
+		} else {
+			assert.Empty(t, serviceRegistry.RegisteredTaskIDs("test.app"))
+		}
+	}
+}
+
 func TestEventHandler_HandleStatusEventAboutDeadTask(t *testing.T) {
 	t.Parallel()
 
@@ -137,6 +184,312 @@ func TestEventHandler_HandleStatusEventAboutDeadTask(t *testing.T) {
 	}
 }
 
+func killingTaskEvent(appID string, taskID apps.TaskID) event {
+	body := []byte(`{
+	  "taskId":"` + taskID.String() + `",
+	  "taskStatus":"TASK_KILLING",
+	  "appId":"` + appID + `",
+	  "host":"localhost",
+	  "ports":[31372],
+	  "eventType":"status_update_event",
+	  "timestamp":"2015-12-07T09:33:40.898Z"
+	}`)
+	return event{eventType: "status_update_event", timestamp: time.Now(), body: body}
+}
+
+func TestEventHandler_DeregisterKillingTaskWithoutDrainDelay(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry})
+
+	// when
+	queue <- killingTaskEvent("/test/app", app.Tasks[1].ID)
+	awaitFunc()
+
+	// then
+	assert.Equal(t, []apps.TaskID{app.Tasks[0].ID}, serviceRegistry.RegisteredTaskIDs("test.app"))
+}
+
+func TestEventHandler_DrainKillingTask(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, drainDelay: 50 * time.Millisecond})
+
+	// when
+	queue <- killingTaskEvent("/test/app", app.Tasks[1].ID)
+	awaitFunc()
+
+	// then
+	assert.Len(t, serviceRegistry.RegisteredTaskIDs("test.app"), 2)
+	assert.Equal(t, DrainMaintenanceReason, serviceRegistry.MaintenanceReason("test_app.1_test.app_8081"))
+	assert.Empty(t, serviceRegistry.MaintenanceReason("test_app.0_test.app_8080"))
+
+	// when
+	<-time.After(100 * time.Millisecond)
+
+	// then
+	assert.Equal(t, []apps.TaskID{app.Tasks[0].ID}, serviceRegistry.RegisteredTaskIDs("test.app"))
+}
+
+func TestEventHandler_DrainTasksOfStoppedApplicationOnDeploymentInfo(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	marathon := marathon.MarathonerStubForApps(app)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon, drainDelay: time.Minute, drainDeploys: true})
+	body := []byte(`{
+	  "eventType":"deployment_info",
+	  "timestamp":"2015-12-07T09:33:40.898Z",
+	  "plan":{"id":"plan","steps":[{"actions":[{"action":"StopApplication","app":"/test/app"}]}]},
+	  "currentStep":{"actions":[{"action":"StopApplication","app":"/test/app"}]}
+	}`)
+
+	// when
+	queue <- event{eventType: "deployment_info", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Equal(t, DrainMaintenanceReason, serviceRegistry.MaintenanceReason("test_app.0_test.app_8080"))
+	assert.Equal(t, DrainMaintenanceReason, serviceRegistry.MaintenanceReason("test_app.1_test.app_8081"))
+}
+
+func TestEventHandler_DeregisterTasksOfStoppedApplicationOnDeploymentInfoWithoutDrainDelay(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	marathon := marathon.MarathonerStubForApps(app)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon, drainDeploys: true})
+	body := []byte(`{
+	  "eventType":"deployment_info",
+	  "timestamp":"2015-12-07T09:33:40.898Z",
+	  "plan":{"id":"plan","steps":[{"actions":[{"action":"StopApplication","app":"/test/app"}]}]},
+	  "currentStep":{"actions":[{"action":"StopApplication","app":"/test/app"}]}
+	}`)
+
+	// when
+	queue <- event{eventType: "deployment_info", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Empty(t, serviceRegistry.RegisteredTaskIDs("test.app"))
+}
+
+func TestEventHandler_NotHandleDeploymentInfoWhenDrainingDeploymentsIsDisabled(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	marathon := marathon.MarathonerStubForApps(app)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon, drainDelay: time.Minute})
+	body := []byte(`{
+	  "eventType":"deployment_info",
+	  "timestamp":"2015-12-07T09:33:40.898Z",
+	  "plan":{"id":"plan","steps":[{"actions":[{"action":"StopApplication","app":"/test/app"}]}]},
+	  "currentStep":{"actions":[{"action":"StopApplication","app":"/test/app"}]}
+	}`)
+
+	// when
+	queue <- event{eventType: "deployment_info", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Empty(t, serviceRegistry.MaintenanceReason("test_app.0_test.app_8080"))
+	assert.Len(t, serviceRegistry.RegisteredTaskIDs("test.app"), 2)
+}
+
+func TestEventHandler_DrainOldTasksBeforeTheyAreKilledOnDeploymentStepSuccess(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	app.VersionInfo.LastConfigChangeAt = "2017-01-01T12:00:00.000Z"
+	app.Tasks[0].Version = "2016-01-01T12:00:00.000Z"
+	app.Tasks[1].Version = "2017-01-01T12:00:00.000Z"
+	marathon := marathon.MarathonerStubForApps(app)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon, drainDelay: time.Minute, drainDeploys: true})
+	body := []byte(`{
+	  "eventType":"deployment_step_success",
+	  "timestamp":"2015-12-07T09:33:40.898Z",
+	  "plan":{"id":"plan","steps":[
+	    {"actions":[{"action":"ScaleApplication","app":"/test/app"}]},
+	    {"actions":[{"action":"KillAllOldTasksOf","app":"/test/app"}]}
+	  ]},
+	  "currentStep":{"actions":[{"action":"ScaleApplication","app":"/test/app"}]}
+	}`)
+
+	// when
+	queue <- event{eventType: "deployment_step_success", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Equal(t, DrainMaintenanceReason, serviceRegistry.MaintenanceReason("test_app.0_test.app_8080"))
+	assert.Empty(t, serviceRegistry.MaintenanceReason("test_app.1_test.app_8081"))
+	assert.Len(t, serviceRegistry.RegisteredTaskIDs("test.app"), 2)
+}
+
+func TestEventHandler_DrainOldTasksOfRestartedAppOnDeploymentInfo(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	app.VersionInfo.LastConfigChangeAt = "2016-01-01T12:00:00.000Z"
+	app.VersionInfo.LastScalingAt = "2017-01-01T12:00:00.000Z"
+	app.Tasks[0].Version = "2016-01-01T12:00:00.000Z"
+	app.Tasks[1].Version = "2017-01-01T12:00:00.000Z"
+	marathon := marathon.MarathonerStubForApps(app)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon, drainDelay: time.Minute, drainDeploys: true})
+	body := []byte(`{
+	  "eventType":"deployment_info",
+	  "timestamp":"2017-01-01T12:00:01.000Z",
+	  "plan":{"id":"plan","steps":[{"actions":[{"action":"KillAllOldTasksOf","app":"/test/app"}]}]},
+	  "currentStep":{"actions":[{"action":"KillAllOldTasksOf","app":"/test/app"}]}
+	}`)
+
+	// when
+	queue <- event{eventType: "deployment_info", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Equal(t, DrainMaintenanceReason, serviceRegistry.MaintenanceReason("test_app.0_test.app_8080"))
+	assert.Empty(t, serviceRegistry.MaintenanceReason("test_app.1_test.app_8081"))
+}
+
+func TestEventHandler_DrainOldTasksOfRestartedAppOnDeploymentInfoWithTargetVersion(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	app.VersionInfo.LastConfigChangeAt = "2016-01-01T12:00:00.000Z"
+	app.VersionInfo.LastScalingAt = "2016-01-01T12:00:00.000Z"
+	app.Tasks[0].Version = "2016-01-01T12:00:00.000Z"
+	app.Tasks[1].Version = "2017-01-01T12:00:00.000Z"
+	marathon := marathon.MarathonerStubForApps(app)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon, drainDelay: time.Minute, drainDeploys: true})
+	body := []byte(`{
+	  "eventType":"deployment_info",
+	  "timestamp":"2017-01-01T12:00:01.000Z",
+	  "plan":{"id":"plan","version":"2017-01-01T12:00:00.000Z","steps":[{"actions":[{"action":"KillAllOldTasksOf","app":"/test/app"}]}]},
+	  "currentStep":{"actions":[{"action":"KillAllOldTasksOf","app":"/test/app"}]}
+	}`)
+
+	// when
+	queue <- event{eventType: "deployment_info", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Equal(t, DrainMaintenanceReason, serviceRegistry.MaintenanceReason("test_app.0_test.app_8080"))
+	assert.Empty(t, serviceRegistry.MaintenanceReason("test_app.1_test.app_8081"))
+}
+
+func TestEventHandler_DeregisterAppOnAppTerminatedEvent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	other := ConsulApp("/test/other", 1)
+	serviceRegistry := consul.NewConsulStub()
+	for _, a := range []*apps.App{app, other} {
+		for _, task := range a.Tasks {
+			serviceRegistry.Register(&task, a)
+		}
+	}
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon.MarathonerStubForApps()})
+	body := []byte(`{"eventType":"app_terminated_event","appId":"/test/app","timestamp":"2017-01-01T12:00:00.000Z"}`)
+
+	// when
+	queue <- event{eventType: "app_terminated_event", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Empty(t, serviceRegistry.RegisteredTaskIDs("test.app"))
+	assert.Len(t, serviceRegistry.RegisteredTaskIDs("test.other"), 1)
+}
+
+func TestEventHandler_ReregisterAppOnApiPostEvent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	app.Labels["consul"] = "new-name"
+	app.Labels["public"] = "tag"
+	marathon := marathon.MarathonerStubForApps(app)
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, marathon: marathon})
+	body := []byte(`{
+	  "eventType":"api_post_event",
+	  "timestamp":"2017-01-01T12:00:00.000Z",
+	  "uri":"/v2/apps/test/app",
+	  "appDefinition":{"id":"/test/app","labels":{"consul":"new-name","public":"tag"}}
+	}`)
+
+	// when
+	queue <- event{eventType: "api_post_event", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Empty(t, serviceRegistry.RegisteredTaskIDs("test.app"))
+	assert.Len(t, serviceRegistry.RegisteredTaskIDs("new-name"), 2)
+	services, _ := serviceRegistry.GetServices("new-name")
+	assert.Contains(t, services[0].Tags, "public")
+}
+
+func TestEventHandler_IgnoreApiPostEventForGroups(t *testing.T) {
+	t.Parallel()
+
+	// given
+	marathon := marathon.MarathonerStubForApps()
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: consul.NewConsulStub(), marathon: marathon})
+	body := []byte(`{"eventType":"api_post_event","uri":"/v2/groups/test","groupDefinition":{"id":"/test"}}`)
+
+	// when
+	queue <- event{eventType: "api_post_event", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.False(t, marathon.Interactions())
+}
+
 func TestEventHandler_HandleStatusEventAboutDeadTaskErrOnDeregistration(t *testing.T) {
 	t.Parallel()
 
@@ -334,6 +687,41 @@ func TestEventHandler_NotHandleHealthStatusEventWhenTaskIsNotAlive(t *testing.T)
 	assert.False(t, marathon.Interactions())
 }
 
+func TestEventHandler_DeregisterTaskUnhealthyBeyondGracePeriodOnHealthStatusEvent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	app := ConsulApp("/test/app", 2)
+	marathon := marathon.MarathonerStubForApps(app)
+	serviceRegistry := consul.NewConsulStub()
+	for _, task := range app.Tasks {
+		serviceRegistry.Register(&task, app)
+	}
+	app.Tasks[1].HealthCheckResults = []apps.HealthCheckResult{{
+		Alive:       false,
+		LastSuccess: time.Now().Add(-10 * time.Minute).Format(time.RFC3339Nano),
+	}}
+	unhealthyTasks, _ := service.NewUnhealthyTaskHandler(serviceRegistry, service.UnhealthyDeregister, 5*time.Minute)
+
+	queue, awaitFunc := testEventHandler(handlerStubs{serviceRegistry: serviceRegistry, unhealthyTasks: unhealthyTasks, marathon: marathon})
+
+	body := []byte(`{
+	  "appId":"/test/app",
+	  "taskId":"test_app.1",
+	  "version":"2015-12-07T09:02:48.981Z",
+	  "alive":false,
+	  "eventType":"health_status_changed_event",
+	  "timestamp":"2015-12-07T09:33:50.069Z"
+	}`)
+
+	// when
+	queue <- event{eventType: "health_status_changed_event", timestamp: time.Now(), body: body}
+	awaitFunc()
+
+	// then
+	assert.Equal(t, []apps.TaskID{"test_app.0"}, serviceRegistry.RegisteredTaskIDs("test.app"))
+}
+
 func TestEventHandler_NotHandleHealthStatusEventWhenBodyIsInvalid(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,303 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/events"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/service"
+)
+
+// Strategies applied to events that do not fit into the full events queue
+const (
+	OverflowDrop   = "drop"
+	OverflowBlock  = "block"
+	OverflowSpill  = "spill"
+	OverflowResync = "resync"
+)
+
+const spillFileSuffix = ".event"
+
+var errQueueFull = errors.New("Event queue full")
+
+// overflowQueue puts events into the events queue applying the overflow strategy when it is full.
+// It returns an error when the event is lost.
+type overflowQueue interface {
+	put(e event) error
+}
+
+func newOverflowQueue(config Config, queue chan event, appSyncer AppSyncer) (overflowQueue, error) {
+	switch config.QueueOverflow {
+	case "", OverflowDrop:
+		return dropOnOverflow(queue), nil
+	case OverflowBlock:
+		return blockOnOverflow(queue, config.QueueBlockTimeout.Duration, config.MetricsScope), nil
+	case OverflowSpill:
+		return newSpillingQueue(queue, config.QueueSpillDir, config.MetricsScope)
+	case OverflowResync:
+		return resyncOnOverflow(queue, appSyncer, config.MetricsScope), nil
+	default:
+		return nil, fmt.Errorf("Unknown events queue overflow strategy %s, expected one of: %s, %s, %s, %s",
+			config.QueueOverflow, OverflowDrop, OverflowBlock, OverflowSpill, OverflowResync)
+	}
+}
+
+// dropOnOverflow loses events when the queue is full, they are fixed by the next sync
+type dropOnOverflow chan event
+
+func (q dropOnOverflow) put(e event) error {
+	select {
+	case q <- e:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// blockingQueue waits for room in the queue up to the timeout. Marathon waits for the response meanwhile,
+// so the timeout should be kept well below Marathon's event subscriber timeout.
+type blockingQueue struct {
+	queue   chan event
+	timeout time.Duration
+	metrics metrics.Scope
+}
+
+func blockOnOverflow(queue chan event, timeout time.Duration, scope metrics.Scope) *blockingQueue {
+	return &blockingQueue{queue: queue, timeout: timeout, metrics: scope}
+}
+
+func (q *blockingQueue) put(e event) error {
+	select {
+	case q.queue <- e:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	start := time.Now()
+	select {
+	case q.queue <- e:
+		q.metrics.UpdateGauge("events.queue.block", int64(time.Since(start)/time.Millisecond))
+		return nil
+	case <-timer.C:
+		return errQueueFull
+	}
+}
+
+// spillingQueue writes events that do not fit into the queue to files in the spill directory and
+// feeds them back once workers catch up. While any event is spilled, new ones are spilled too,
+// so events are processed in the order they were received.
+// Events left in the directory by a previous run are replayed on start.
+type spillingQueue struct {
+	sync.Mutex
+	queue   chan event
+	dir     string
+	spilled int
+	seq     uint64
+	notify  chan struct{}
+	metrics metrics.Scope
+}
+
+func newSpillingQueue(queue chan event, dir string, scope metrics.Scope) (*spillingQueue, error) {
+	if dir == "" {
+		return nil, errors.New("Events queue spill directory is required by spill overflow strategy")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create events queue spill directory: %s", err)
+	}
+	files, err := spillFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		log.WithField("Events", len(files)).Info("Replaying events spilled by previous run")
+	}
+	q := &spillingQueue{
+		queue:   queue,
+		dir:     dir,
+		spilled: len(files),
+		notify:  make(chan struct{}, 1),
+		metrics: scope,
+	}
+	go q.replay()
+	q.wakeUp()
+	return q, nil
+}
+
+func (q *spillingQueue) put(e event) error {
+	q.Lock()
+	defer q.Unlock()
+	if q.spilled == 0 {
+		select {
+		case q.queue <- e:
+			return nil
+		default:
+		}
+	}
+
+	q.seq++
+	name := filepath.Join(q.dir, fmt.Sprintf("%020d-%010d%s", e.timestamp.UnixNano(), q.seq, spillFileSuffix))
+	// write to a temporary file first, so replay never reads partially written events
+	if err := ioutil.WriteFile(name+".tmp", e.body, 0600); err != nil {
+		return fmt.Errorf("Unable to spill event: %s", err)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return fmt.Errorf("Unable to spill event: %s", err)
+	}
+	q.spilled++
+	q.metrics.Mark("events.queue.spill")
+	q.wakeUp()
+	return nil
+}
+
+func (q *spillingQueue) wakeUp() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *spillingQueue) replay() {
+	for range q.notify {
+		files, err := spillFiles(q.dir)
+		if err != nil {
+			log.WithError(err).Error("Unable to list spilled events")
+			continue
+		}
+		for _, file := range files {
+			q.replayFile(file)
+		}
+	}
+}
+
+func (q *spillingQueue) replayFile(file string) {
+	body, err := ioutil.ReadFile(file)
+	if err == nil {
+		var e events.Event
+		if e, err = events.ParseEvent(body); err == nil {
+			// blocks until workers make room in the queue
+			q.queue <- event{eventType: e.Type, body: body, timestamp: time.Now()}
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("File", file).Error("Unable to replay spilled event, skipping it")
+	}
+	if err := os.Remove(file); err != nil {
+		log.WithError(err).WithField("File", file).Error("Unable to remove spilled event")
+	}
+
+	q.Lock()
+	q.spilled--
+	q.Unlock()
+}
+
+// spillFiles returns spilled events in the order they were received
+func spillFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), spillFileSuffix) {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	return files, nil
+}
+
+// resyncingQueue compensates for events that do not fit into the queue with syncing apps they concern.
+// Resyncs of the same app are coalesced: an app dropped again while it is being synced is synced once more afterwards.
+type resyncingQueue struct {
+	sync.Mutex
+	queue     chan event
+	appSyncer AppSyncer
+	// apps being synced, true when they need to be synced again
+	pending map[apps.AppID]bool
+	metrics metrics.Scope
+}
+
+func resyncOnOverflow(queue chan event, appSyncer AppSyncer, scope metrics.Scope) *resyncingQueue {
+	return &resyncingQueue{queue: queue, appSyncer: appSyncer, pending: make(map[apps.AppID]bool), metrics: scope}
+}
+
+func (q *resyncingQueue) put(e event) error {
+	select {
+	case q.queue <- e:
+		return nil
+	default:
+	}
+
+	appIDs := eventApps(e)
+	if len(appIDs) == 0 {
+		return errQueueFull
+	}
+	for _, appID := range appIDs {
+		q.resync(appID)
+	}
+	return nil
+}
+
+func (q *resyncingQueue) resync(appID apps.AppID) {
+	q.Lock()
+	defer q.Unlock()
+	q.metrics.Mark("events.queue.resync")
+	if _, ok := q.pending[appID]; ok {
+		q.pending[appID] = true
+		return
+	}
+	q.pending[appID] = false
+	go q.sync(appID)
+}
+
+func (q *resyncingQueue) sync(appID apps.AppID) {
+	for {
+		log.WithField("AppId", appID).Info("Events queue full, syncing app")
+		if err := q.appSyncer.SyncApp(appID, service.Trigger{Type: service.TriggerOverflow}); err != nil {
+			log.WithError(err).WithField("AppId", appID).Error("There was a problem syncing app")
+		}
+
+		q.Lock()
+		again := q.pending[appID]
+		if !again {
+			delete(q.pending, appID)
+			q.Unlock()
+			return
+		}
+		q.pending[appID] = false
+		q.Unlock()
+	}
+}
+
+// eventApps returns apps the event concerns
+func eventApps(e event) []apps.AppID {
+	switch e.eventType {
+	case statusUpdateEventType, healthStatusChangedEventType:
+		if parsed, err := events.ParseEvent(e.body); err == nil && parsed.TaskID() != "" {
+			return []apps.AppID{parsed.TaskID().AppID()}
+		}
+	case appTerminatedEventType, apiPostEventType:
+		if appEvent, err := events.ParseAppEvent(e.body); err == nil {
+			return []apps.AppID{appEvent.App()}
+		}
+	case deploymentInfoEventType, deploymentStepSuccessEventType:
+		if deployment, err := events.ParseDeploymentEvent(e.body); err == nil {
+			var appIDs []apps.AppID
+			for _, action := range deployment.CurrentStep.Actions {
+				appIDs = append(appIDs, action.App)
+			}
+			return appIDs
+		}
+	}
+	return nil
+}
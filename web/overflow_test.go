@@ -0,0 +1,194 @@
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/service"
+	timeutil "github.com/allegro/marathon-consul/time"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type appSyncerStub struct {
+	sync.Mutex
+	synced []apps.AppID
+	done   chan struct{}
+}
+
+func (s *appSyncerStub) SyncApp(appID apps.AppID, trigger service.Trigger) error {
+	s.Lock()
+	s.synced = append(s.synced, appID)
+	s.Unlock()
+	s.done <- struct{}{}
+	return nil
+}
+
+func (s *appSyncerStub) DeregisterApp(appID apps.AppID, trigger service.Trigger) error {
+	return nil
+}
+
+func statusEvent(taskID string) event {
+	return event{
+		eventType: statusUpdateEventType,
+		body:      []byte(`{"eventType":"status_update_event","timestamp":"2017-01-01T12:00:00.000Z","taskId":"` + taskID + `","taskStatus":"TASK_RUNNING"}`),
+		timestamp: time.Now(),
+	}
+}
+
+func TestNewOverflowQueue_UnknownStrategy(t *testing.T) {
+	t.Parallel()
+
+	// when
+	_, err := newOverflowQueue(Config{QueueOverflow: "unknown"}, make(chan event), nil)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestNewOverflowQueue_SpillRequiresDirectory(t *testing.T) {
+	t.Parallel()
+
+	// when
+	_, err := newOverflowQueue(Config{QueueOverflow: OverflowSpill}, make(chan event), nil)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestDropOnOverflow(t *testing.T) {
+	t.Parallel()
+
+	// given
+	queue := dropOnOverflow(make(chan event, 1))
+
+	// expect
+	assert.NoError(t, queue.put(statusEvent("app.1")))
+	assert.Equal(t, errQueueFull, queue.put(statusEvent("app.2")))
+}
+
+func TestBlockOnOverflow_WaitsForRoomInQueue(t *testing.T) {
+	t.Parallel()
+
+	// given
+	queue := make(chan event, 1)
+	overflow, _ := newOverflowQueue(Config{QueueOverflow: OverflowBlock, QueueBlockTimeout: timeutil.Interval{Duration: time.Second}}, queue, nil)
+	overflow.put(statusEvent("app.1"))
+	time.AfterFunc(10*time.Millisecond, func() { <-queue })
+
+	// when
+	err := overflow.put(statusEvent("app.2"))
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, queue, 1)
+}
+
+func TestBlockOnOverflow_DropsEventAfterTimeout(t *testing.T) {
+	t.Parallel()
+
+	// given
+	queue := make(chan event, 1)
+	overflow := blockOnOverflow(queue, 10*time.Millisecond, "")
+	overflow.put(statusEvent("app.1"))
+
+	// when
+	err := overflow.put(statusEvent("app.2"))
+
+	// then
+	assert.Equal(t, errQueueFull, err)
+}
+
+func TestSpillingQueue_ReplaysSpilledEventsInOrder(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dir, err := ioutil.TempDir("", "marathon-consul-spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	queue := make(chan event, 1)
+	overflow, err := newSpillingQueue(queue, dir, "")
+	require.NoError(t, err)
+
+	// when
+	for _, taskID := range []string{"app.1", "app.2", "app.3"} {
+		assert.NoError(t, overflow.put(statusEvent(taskID)))
+	}
+
+	// then
+	for _, taskID := range []string{"app.1", "app.2", "app.3"} {
+		select {
+		case e := <-queue:
+			assert.Contains(t, string(e.body), `"taskId":"`+taskID+`"`)
+			assert.Equal(t, statusUpdateEventType, e.eventType)
+		case <-time.After(time.Second):
+			t.Fatalf("Event of %s was not replayed", taskID)
+		}
+	}
+}
+
+func TestSpillingQueue_ReplaysEventsLeftByPreviousRun(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dir, err := ioutil.TempDir("", "marathon-consul-spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	previous, err := newSpillingQueue(make(chan event), dir, "")
+	require.NoError(t, err)
+	previous.put(statusEvent("app.1"))
+
+	// when
+	queue := make(chan event, 1)
+	_, err = newSpillingQueue(queue, dir, "")
+	require.NoError(t, err)
+
+	// then
+	select {
+	case e := <-queue:
+		assert.Contains(t, string(e.body), `"taskId":"app.1"`)
+	case <-time.After(time.Second):
+		t.Fatal("Spilled event was not replayed")
+	}
+}
+
+func TestResyncOnOverflow_SyncsAppOfDroppedEvent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	syncer := &appSyncerStub{done: make(chan struct{}, 1)}
+	queue := make(chan event, 1)
+	overflow := resyncOnOverflow(queue, syncer, "")
+	overflow.put(statusEvent("app.1"))
+
+	// when
+	err := overflow.put(statusEvent("other_app.1"))
+
+	// then
+	assert.NoError(t, err)
+	select {
+	case <-syncer.done:
+	case <-time.After(time.Second):
+		t.Fatal("App was not synced")
+	}
+	assert.Equal(t, []apps.AppID{"/other/app"}, syncer.synced)
+}
+
+func TestResyncOnOverflow_DropsEventsNotConcerningApps(t *testing.T) {
+	t.Parallel()
+
+	// given
+	queue := make(chan event, 1)
+	overflow := resyncOnOverflow(queue, &appSyncerStub{}, "")
+	overflow.put(statusEvent("app.1"))
+
+	// when
+	err := overflow.put(event{eventType: statusUpdateEventType, body: []byte(`{"eventType":"status_update_event"}`)})
+
+	// then
+	assert.Equal(t, errQueueFull, err)
+}
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Reload reads the configuration again and applies it, returning changed options
+// applied while running and the ones requiring a restart
+type Reload func() (applied []string, rejected []string, err error)
+
+type reloadResult struct {
+	Applied  []string `json:"applied"`
+	Rejected []string `json:"rejected"`
+}
+
+// ReloadHandler reloads the configuration on POST requests
+func ReloadHandler(reload Reload) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		applied, rejected, err := reload()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, _ := json.Marshal(reloadResult{Applied: applied, Rejected: rejected})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadHandler(t *testing.T) {
+	t.Parallel()
+
+	// given
+	handler := ReloadHandler(func() ([]string, []string, error) {
+		return []string{"Sync.Interval"}, []string{"Consul.Tag"}, nil
+	})
+	req, _ := http.NewRequest("POST", "http://example.com/reload", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler(recorder, req)
+
+	// then
+	assert.Equal(t, 200, recorder.Code)
+	assert.JSONEq(t, `{"applied": ["Sync.Interval"], "rejected": ["Consul.Tag"]}`, recorder.Body.String())
+}
+
+func TestReloadHandler_Error(t *testing.T) {
+	t.Parallel()
+
+	// given
+	handler := ReloadHandler(func() ([]string, []string, error) {
+		return nil, nil, errors.New("invalid configuration")
+	})
+	req, _ := http.NewRequest("POST", "http://example.com/reload", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler(recorder, req)
+
+	// then
+	assert.Equal(t, 500, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "invalid configuration")
+}
+
+func TestReloadHandler_RejectsGet(t *testing.T) {
+	t.Parallel()
+
+	// given
+	reloaded := false
+	handler := ReloadHandler(func() ([]string, []string, error) {
+		reloaded = true
+		return nil, nil, nil
+	})
+	req, _ := http.NewRequest("GET", "http://example.com/reload", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler(recorder, req)
+
+	// then
+	assert.Equal(t, 405, recorder.Code)
+	assert.False(t, reloaded)
+}
@@ -0,0 +1,30 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/allegro/marathon-consul/apps"
+)
+
+// ClusterStatus describes how a Marathon cluster is synced
+type ClusterStatus struct {
+	Name       string     `json:"name,omitempty"`
+	Tag        string     `json:"tag"`
+	EventsPath string     `json:"eventsPath"`
+	Rules      apps.Rules `json:"rules"`
+}
+
+type status struct {
+	Clusters []ClusterStatus `json:"clusters"`
+}
+
+// StatusHandler reports Marathon clusters synced by the process with rules selecting their apps,
+// clusters are described on every request, so reloaded rules are reported
+func StatusHandler(clusters func() []ClusterStatus) Handler {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		body, _ := json.Marshal(status{Clusters: clusters()})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
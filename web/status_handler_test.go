@@ -0,0 +1,35 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusHandler(t *testing.T) {
+	t.Parallel()
+
+	// given
+	handler := StatusHandler(func() []ClusterStatus {
+		return []ClusterStatus{
+			{Tag: "marathon", EventsPath: "/events", Rules: apps.ParseRules("/prod/**", "/tmp/**")},
+			{Name: "team-a", Tag: "team-a", EventsPath: "/events/team-a"},
+		}
+	})
+	req, _ := http.NewRequest("GET", "http://example.com/status", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler(recorder, req)
+
+	// then
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"clusters": [
+		{"tag": "marathon", "eventsPath": "/events", "rules": {"include": ["/prod/**"], "exclude": ["/tmp/**"]}},
+		{"name": "team-a", "tag": "team-a", "eventsPath": "/events/team-a", "rules": {"include": null, "exclude": null}}
+	]}`, recorder.Body.String())
+}
@@ -3,6 +3,7 @@ package web
 import (
 	"net/http"
 
+	"github.com/allegro/marathon-consul/apps"
 	"github.com/allegro/marathon-consul/marathon"
 	"github.com/allegro/marathon-consul/service"
 )
@@ -10,15 +11,28 @@ import (
 type Stop func()
 type Handler func(w http.ResponseWriter, r *http.Request)
 
-func NewHandler(config Config, marathon marathon.Marathoner, serviceOperations service.ServiceRegistry) (Handler, Stop) {
+// AppSyncer brings registrations of a whole app in line with Marathon
+type AppSyncer interface {
+	SyncApp(appID apps.AppID, trigger service.Trigger) error
+	DeregisterApp(appID apps.AppID, trigger service.Trigger) error
+}
+
+func NewHandler(config Config, marathon marathon.Marathoner, serviceOperations service.ServiceRegistry,
+	unhealthyTasks *service.UnhealthyTaskHandler, appSyncer AppSyncer) (Handler, Stop, error) {
 
 	stopChannels := make([]chan<- stopEvent, config.WorkersCount, config.WorkersCount)
 	eventQueue := make(chan event, config.QueueSize)
+	overflow, err := newOverflowQueue(config, eventQueue, appSyncer)
+	if err != nil {
+		return nil, nil, err
+	}
+	drainer := newDrainer(serviceOperations, config.DrainDelay.Duration, config.DrainDeployments, config.MetricsScope)
 	for i := 0; i < config.WorkersCount; i++ {
-		handler := newEventHandler(i, serviceOperations, marathon, eventQueue)
+		handler := newEventHandler(i, serviceOperations, unhealthyTasks, drainer, appSyncer, marathon, eventQueue, config.MetricsScope)
 		stopChannels[i] = handler.start()
 	}
-	return newWebHandler(eventQueue, config.MaxEventSize).Handle, stop(stopChannels)
+	filter := newEventFilter(config.MaxEventAge.Duration, config.DedupWindow)
+	return newWebHandler(overflow, config.MaxEventSize, filter, config.MetricsScope).Handle, stop(stopChannels), nil
 }
 
 func stop(channels []chan<- stopEvent) Stop {
@@ -1,7 +1,6 @@
 package web
 
 import (
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -13,11 +12,13 @@ import (
 )
 
 type EventHandler struct {
-	eventQueue   chan event
+	eventQueue   overflowQueue
 	maxEventSize int64
+	filter       *eventFilter
+	metrics      metrics.Scope
 }
 
-func newWebHandler(eventQueue chan event, maxEventSize int64) *EventHandler {
+func newWebHandler(eventQueue overflowQueue, maxEventSize int64, filter *eventFilter, scope metrics.Scope) *EventHandler {
 	if maxEventSize < 1000 {
 		log.WithField("maxEventSize", maxEventSize).Warning("Max event size is too small. Switching to 1000")
 		maxEventSize = 1000
@@ -25,12 +26,19 @@ func newWebHandler(eventQueue chan event, maxEventSize int64) *EventHandler {
 	return &EventHandler{
 		eventQueue:   eventQueue,
 		maxEventSize: maxEventSize,
+		filter:       filter,
+		metrics:      scope,
 	}
 }
 
 const (
 	statusUpdateEventType        = "status_update_event"
 	healthStatusChangedEventType = "health_status_changed_event"
+	// Deployment events are handled only when draining deployments is enabled
+	deploymentInfoEventType        = "deployment_info"
+	deploymentStepSuccessEventType = "deployment_step_success"
+	appTerminatedEventType         = "app_terminated_event"
+	apiPostEventType               = "api_post_event"
 )
 
 // Handle is responsible for accepting events and passing them to event queue
@@ -39,51 +47,67 @@ const (
 // Processed events must be smaller than maxEventSize and must contain
 // supported event type.
 func (h *EventHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	metrics.Time("events.response", func() {
+	h.metrics.Time("events.response", func() {
 		limitedBody := http.MaxBytesReader(w, r.Body, h.maxEventSize)
 		defer limitedBody.Close()
 		body, err := ioutil.ReadAll(limitedBody)
 		if err != nil {
-			drop(err, w)
+			h.drop(err, w)
 			return
 		}
 
 		e, err := events.ParseEvent(body)
 		if err != nil {
-			drop(err, w)
+			h.drop(err, w)
 			return
 		}
 
-		metrics.Mark("events.requests." + e.Type)
+		h.metrics.Mark("events.requests." + e.Type)
 		delay := time.Now().Unix() - e.Timestamp.Unix()
-		metrics.UpdateGauge("events.requests.delay.current", delay)
+		h.metrics.UpdateGauge("events.requests.delay.current", delay)
 		log.WithFields(log.Fields{"EventType": e.Type, "OriginalTimestamp": e.Timestamp.String()}).Debug("Received event")
 
-		if e.Type != statusUpdateEventType && e.Type != healthStatusChangedEventType {
-			drop(fmt.Errorf("%s is not supported", e.Type), w)
+		if !isSupported(e.Type) {
+			h.drop(fmt.Errorf("%s is not supported", e.Type), w)
 			return
 		}
 
-		select {
-		case h.eventQueue <- event{eventType: e.Type, body: body, timestamp: time.Now()}:
-			accept(w)
-		default:
-			metrics.Mark("events.queue.drop")
-			drop(errors.New("Event queue full"), w)
+		if reason := h.filter.dropReason(e, time.Now()); reason != "" {
+			h.metrics.Mark("events.drop." + reason)
+			h.drop(fmt.Errorf("Dropping %s %s event", reason, e.Type), w)
+			return
+		}
+
+		if err := h.eventQueue.put(event{eventType: e.Type, body: body, timestamp: time.Now()}); err != nil {
+			h.metrics.Mark("events.queue.drop")
+			h.drop(err, w)
+			return
 		}
+		h.filter.accepted(e)
+		h.accept(w)
 
 	})
 }
 
-func accept(w http.ResponseWriter) {
-	metrics.Mark("events.response.accept")
+func isSupported(eventType string) bool {
+	switch eventType {
+	case statusUpdateEventType, healthStatusChangedEventType, deploymentInfoEventType, deploymentStepSuccessEventType,
+		appTerminatedEventType, apiPostEventType:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *EventHandler) accept(w http.ResponseWriter) {
+	h.metrics.Mark("events.response.accept")
 	w.WriteHeader(http.StatusAccepted)
 	fmt.Fprintln(w, "OK")
 }
 
-func drop(err error, w http.ResponseWriter) {
+func (h *EventHandler) drop(err error, w http.ResponseWriter) {
 	log.WithError(err).Debug("Malformed request")
-	metrics.Mark("events.response.drop")
+	h.metrics.Mark("events.response.drop")
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, err.Error())
 }
@@ -21,7 +21,7 @@ func TestWebHandler_Send202AcceptedWhenEventsPassesValidation(t *testing.T) {
 		}`)
 
 	queue := make(chan event, 1)
-	handler := newWebHandler(queue, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(queue), maxEventSize, newEventFilter(0, 0), "")
 	req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer(body))
 	recorder := httptest.NewRecorder()
 
@@ -36,7 +36,7 @@ func TestWebHandler_HandleReaderError(t *testing.T) {
 	t.Parallel()
 
 	// given
-	handler := newWebHandler(nil, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(nil), maxEventSize, newEventFilter(0, 0), "")
 	req, _ := http.NewRequest("POST", "/events", BadReader{})
 
 	// when
@@ -52,7 +52,7 @@ func TestWebHandler_DropBigBody(t *testing.T) {
 	t.Parallel()
 
 	// given
-	handler := newWebHandler(nil, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(nil), maxEventSize, newEventFilter(0, 0), "")
 	req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer(make([]byte, 4097)))
 
 	// when
@@ -68,7 +68,7 @@ func TestWebHandler_DropEmptyBody(t *testing.T) {
 	t.Parallel()
 
 	// given
-	handler := newWebHandler(nil, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(nil), maxEventSize, newEventFilter(0, 0), "")
 	req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer([]byte{}))
 
 	// when
@@ -83,7 +83,7 @@ func TestWebHandler_DropAppInvalidBody(t *testing.T) {
 	t.Parallel()
 
 	// given
-	handler := newWebHandler(nil, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(nil), maxEventSize, newEventFilter(0, 0), "")
 	body := `{"type":  "app_terminated_event", "appID": 123}`
 	req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer([]byte(body)))
 
@@ -99,7 +99,7 @@ func TestWebHandler_DropMalformedEventType(t *testing.T) {
 	t.Parallel()
 
 	// given
-	handler := newWebHandler(nil, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(nil), maxEventSize, newEventFilter(0, 0), "")
 	req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer([]byte(`{eventType:"test_event"}`)))
 
 	// when
@@ -114,7 +114,7 @@ func TestWebHandler_DropInvalidEventType(t *testing.T) {
 	t.Parallel()
 
 	// given
-	handler := newWebHandler(nil, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(nil), maxEventSize, newEventFilter(0, 0), "")
 	req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer([]byte(`{"eventType":[1,2]}`)))
 
 	// when
@@ -129,7 +129,7 @@ func TestWebHandler_DropUnknownEventType(t *testing.T) {
 	t.Parallel()
 
 	// given
-	handler := newWebHandler(nil, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(nil), maxEventSize, newEventFilter(0, 0), "")
 	req, _ := http.NewRequest("POST", "/events", bytes.NewBuffer([]byte(`{"eventType":"test_event"}`)))
 
 	// when
@@ -150,7 +150,7 @@ func TestWebHandler_DropWhenEventQueueIsFull(t *testing.T) {
                 }`)
 
 	queue := make(chan event, 1)
-	handler := newWebHandler(queue, maxEventSize)
+	handler := newWebHandler(dropOnOverflow(queue), maxEventSize, newEventFilter(0, 0), "")
 	req1, _ := http.NewRequest("POST", "/events", bytes.NewBuffer(body))
 	req2, _ := http.NewRequest("POST", "/events", bytes.NewBuffer(body))
 	recorder1 := httptest.NewRecorder()
@@ -178,3 +178,55 @@ func assertQueueFull(t *testing.T, recorder *httptest.ResponseRecorder) {
 func assertDropped(t *testing.T, recorder *httptest.ResponseRecorder) {
 	assert.Equal(t, 200, recorder.Code)
 }
+
+func TestWebHandler_DropDuplicatedEvent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	body := `{
+		  "eventType":"status_update_event",
+		  "timestamp":"2015-12-07T09:33:40.898Z",
+		  "taskId":"app.1",
+		  "taskStatus":"TASK_RUNNING"
+		}`
+	queue := make(chan event, 2)
+	handler := newWebHandler(dropOnOverflow(queue), maxEventSize, newEventFilter(0, 10), "")
+
+	// when
+	first := httptest.NewRecorder()
+	handler.Handle(first, httptest.NewRequest("POST", "/events", bytes.NewBufferString(body)))
+	duplicate := httptest.NewRecorder()
+	handler.Handle(duplicate, httptest.NewRequest("POST", "/events", bytes.NewBufferString(body)))
+
+	// then
+	assertAccepted(t, first)
+	assertDropped(t, duplicate)
+	assert.Len(t, queue, 1)
+}
+
+func TestWebHandler_AcceptEventRedeliveredAfterQueueWasFull(t *testing.T) {
+	t.Parallel()
+
+	// given
+	body := `{
+		  "eventType":"status_update_event",
+		  "timestamp":"2015-12-07T09:33:40.898Z",
+		  "taskId":"app.1",
+		  "taskStatus":"TASK_RUNNING"
+		}`
+	queue := make(chan event, 1)
+	queue <- event{}
+	handler := newWebHandler(dropOnOverflow(queue), maxEventSize, newEventFilter(0, 10), "")
+	dropped := httptest.NewRecorder()
+	handler.Handle(dropped, httptest.NewRequest("POST", "/events", bytes.NewBufferString(body)))
+	<-queue
+
+	// when
+	redelivered := httptest.NewRecorder()
+	handler.Handle(redelivered, httptest.NewRequest("POST", "/events", bytes.NewBufferString(body)))
+
+	// then
+	assertQueueFull(t, dropped)
+	assertAccepted(t, redelivered)
+	assert.Len(t, queue, 1)
+}
@@ -0,0 +1,10 @@
+package zookeeper
+
+import "github.com/allegro/marathon-consul/time"
+
+type Config struct {
+	Servers        string
+	Prefix         string
+	SessionTimeout time.Interval
+	Timeout        time.Interval
+}
@@ -0,0 +1,452 @@
+package zookeeper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/utils"
+)
+
+// Operations of the ZooKeeper protocol used by the registry, see zookeeper.jute in the ZooKeeper sources
+const (
+	opCreate      int32 = 1
+	opDelete      int32 = 2
+	opGetData     int32 = 4
+	opGetChildren int32 = 8
+	opPing        int32 = 11
+)
+
+// Pings are sent with a reserved xid, notifications of watches are received with another one
+const (
+	pingXid         int32 = -2
+	notificationXid int32 = -1
+)
+
+const (
+	persistentNode int32 = 0
+	ephemeralNode  int32 = 1
+)
+
+// permAll grants all permissions (read, write, create, delete and admin) of created nodes
+const permAll int32 = 31
+
+// maxFrameSize limits responses read from servers, ZooKeeper limits nodes to 1MB by default
+const maxFrameSize = 16 * 1024 * 1024
+
+// Errors returned by ZooKeeper servers
+const (
+	errNoNode         = zkError(-101)
+	errNodeExists     = zkError(-110)
+	errNotEmpty       = zkError(-111)
+	errSessionExpired = zkError(-112)
+)
+
+type zkError int32
+
+func (e zkError) Error() string {
+	switch e {
+	case errNoNode:
+		return "ZooKeeper node does not exist"
+	case errNodeExists:
+		return "ZooKeeper node already exists"
+	case errNotEmpty:
+		return "ZooKeeper node has children"
+	case errSessionExpired:
+		return "ZooKeeper session expired"
+	default:
+		return fmt.Sprintf("ZooKeeper error %d", int32(e))
+	}
+}
+
+// session is a minimal client of the ZooKeeper protocol, connected to one of the servers at a time.
+// Ephemeral nodes created by the session are removed by ZooKeeper when the session expires, i.e. when
+// the server does not hear from the client for the session timeout. The session is kept alive with pings
+// and a broken connection is replaced with a new one resuming the session on the next request.
+// Requests are sent one at a time and no watches are set.
+type session struct {
+	lock           sync.Mutex
+	servers        []string
+	sessionTimeout time.Duration
+	timeout        time.Duration
+	conn           net.Conn
+	id             int64
+	password       []byte
+	negotiated     time.Duration
+	xid            int32
+	lastZxid       int64
+	keepingAlive   bool
+}
+
+func newSession(servers []string, sessionTimeout time.Duration, timeout time.Duration) *session {
+	return &session{
+		servers:        servers,
+		sessionTimeout: sessionTimeout,
+		timeout:        timeout,
+		password:       make([]byte, 16),
+	}
+}
+
+func (s *session) create(path string, data []byte, mode int32) error {
+	return s.request(opCreate, func(e *encoder) {
+		e.putString(path)
+		e.putBuffer(data)
+		// a single ACL granting all permissions to anyone
+		e.putInt32(1)
+		e.putInt32(permAll)
+		e.putString("world")
+		e.putString("anyone")
+		e.putInt32(mode)
+	}, nil)
+}
+
+func (s *session) delete(path string) error {
+	return s.request(opDelete, func(e *encoder) {
+		e.putString(path)
+		// any version
+		e.putInt32(-1)
+	}, nil)
+}
+
+func (s *session) getData(path string) ([]byte, error) {
+	var data []byte
+	err := s.request(opGetData, func(e *encoder) {
+		e.putString(path)
+		e.putBool(false)
+	}, func(d *decoder) {
+		data = d.readBuffer()
+	})
+	return data, err
+}
+
+func (s *session) getChildren(path string) ([]string, error) {
+	var children []string
+	err := s.request(opGetChildren, func(e *encoder) {
+		e.putString(path)
+		e.putBool(false)
+	}, func(d *decoder) {
+		count := d.readInt32()
+		for i := int32(0); i < count && d.err == nil; i++ {
+			children = append(children, d.readString())
+		}
+	})
+	return children, err
+}
+
+func (s *session) request(op int32, write func(e *encoder), read func(d *decoder)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reused := s.conn != nil
+	err := s.attempt(op, write, read)
+	// a connection broken while idle is noticed only when used, the request is sent again
+	// over a new one; all requests are safe to repeat as the registry handles existing and missing nodes
+	if _, ok := err.(zkError); reused && !ok && err != nil {
+		log.WithError(err).Debug("ZooKeeper connection lost, reconnecting")
+		err = s.attempt(op, write, read)
+	}
+	return err
+}
+
+func (s *session) attempt(op int32, write func(e *encoder), read func(d *decoder)) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	xid := pingXid
+	if op != opPing {
+		s.xid++
+		xid = s.xid
+	}
+	err := s.roundTrip(xid, op, write, read)
+	if err == errSessionExpired {
+		s.disconnect()
+		s.expire()
+	} else if _, ok := err.(zkError); !ok && err != nil {
+		s.disconnect()
+	}
+	return err
+}
+
+func (s *session) roundTrip(xid int32, op int32, write func(e *encoder), read func(d *decoder)) error {
+	e := &encoder{}
+	e.putInt32(xid)
+	e.putInt32(op)
+	if write != nil {
+		write(e)
+	}
+	if err := s.send(e.Bytes()); err != nil {
+		return err
+	}
+	for {
+		frame, err := s.receive()
+		if err != nil {
+			return err
+		}
+		d := &decoder{data: frame}
+		replyXid := d.readInt32()
+		zxid := d.readInt64()
+		code := d.readInt32()
+		if d.err != nil {
+			return d.err
+		}
+		if replyXid == notificationXid {
+			continue
+		}
+		if replyXid != xid {
+			return fmt.Errorf("Expected ZooKeeper reply %d but got %d", xid, replyXid)
+		}
+		if zxid > 0 {
+			s.lastZxid = zxid
+		}
+		if code != 0 {
+			return zkError(code)
+		}
+		if read != nil {
+			read(d)
+		}
+		return d.err
+	}
+}
+
+// connect connects to the first available server unless connected already, resuming the session
+// or establishing a new one when it has expired
+func (s *session) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	if len(s.servers) == 0 {
+		return errors.New("No ZooKeeper servers configured")
+	}
+	var errs []error
+	for _, server := range s.servers {
+		err := s.connectTo(server)
+		if err == errSessionExpired {
+			err = s.connectTo(server)
+		}
+		if err == nil {
+			if !s.keepingAlive {
+				s.keepingAlive = true
+				go s.keepAlive()
+			}
+			return nil
+		}
+		log.WithError(err).WithField("Server", server).Debug("ZooKeeper connection failed")
+		errs = append(errs, err)
+	}
+	metrics.Mark("zookeeper.connect.error")
+	return utils.MergeErrorsOrNil(errs, "connecting to ZooKeeper")
+}
+
+func (s *session) connectTo(server string) error {
+	conn, err := net.DialTimeout("tcp", server, s.timeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	e := &encoder{}
+	// protocol version
+	e.putInt32(0)
+	e.putInt64(s.lastZxid)
+	e.putInt32(int32(s.sessionTimeout / time.Millisecond))
+	e.putInt64(s.id)
+	e.putBuffer(s.password)
+	if err := s.send(e.Bytes()); err != nil {
+		s.disconnect()
+		return err
+	}
+	frame, err := s.receive()
+	if err != nil {
+		s.disconnect()
+		return err
+	}
+	d := &decoder{data: frame}
+	d.readInt32()
+	timeout := d.readInt32()
+	id := d.readInt64()
+	password := d.readBuffer()
+	if d.err != nil {
+		s.disconnect()
+		return d.err
+	}
+	// servers refuse to resume an expired session with zero timeout
+	if timeout <= 0 {
+		s.disconnect()
+		s.expire()
+		return errSessionExpired
+	}
+	if id != s.id {
+		metrics.Mark("zookeeper.session.new")
+		log.WithField("Server", server).WithField("Session", fmt.Sprintf("%#x", id)).Info("Established ZooKeeper session")
+	}
+	s.id = id
+	s.password = password
+	s.negotiated = time.Duration(timeout) * time.Millisecond
+	return nil
+}
+
+// expire forgets the expired session, so the next connection establishes a new one
+func (s *session) expire() {
+	if s.id != 0 {
+		log.WithField("Session", fmt.Sprintf("%#x", s.id)).
+			Warn("ZooKeeper session expired, registrations will be restored on the next sync")
+	}
+	s.id = 0
+	s.password = make([]byte, 16)
+}
+
+func (s *session) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// keepAlive pings servers a few times per session timeout, so the session does not expire between requests
+func (s *session) keepAlive() {
+	for {
+		s.lock.Lock()
+		interval := s.negotiated / 3
+		s.lock.Unlock()
+		if interval <= 0 {
+			interval = s.sessionTimeout / 3
+		}
+		time.Sleep(interval)
+		if err := s.request(opPing, nil, nil); err != nil {
+			metrics.Mark("zookeeper.ping.error")
+			log.WithError(err).Warn("Unable to ping ZooKeeper")
+		}
+	}
+}
+
+func (s *session) send(payload []byte) error {
+	s.setDeadline()
+	frame := &encoder{}
+	frame.putInt32(int32(len(payload)))
+	frame.Write(payload)
+	_, err := s.conn.Write(frame.Bytes())
+	return err
+}
+
+func (s *session) receive() ([]byte, error) {
+	s.setDeadline()
+	return readFrame(s.conn)
+}
+
+func (s *session) setDeadline() {
+	deadline := time.Time{}
+	if s.timeout > 0 {
+		deadline = time.Now().Add(s.timeout)
+	}
+	s.conn.SetDeadline(deadline)
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header))
+	if size < 0 || size > maxFrameSize {
+		return nil, fmt.Errorf("Invalid ZooKeeper frame size %d", size)
+	}
+	frame := make([]byte, size)
+	_, err := io.ReadFull(r, frame)
+	return frame, err
+}
+
+// encoder writes values the way ZooKeeper jute records are serialized: big endian numbers
+// and strings and buffers prefixed with their length
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) putInt32(value int32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(value))
+	e.Write(b)
+}
+
+func (e *encoder) putInt64(value int64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(value))
+	e.Write(b)
+}
+
+func (e *encoder) putBool(value bool) {
+	if value {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) putBuffer(value []byte) {
+	if value == nil {
+		e.putInt32(-1)
+		return
+	}
+	e.putInt32(int32(len(value)))
+	e.Write(value)
+}
+
+func (e *encoder) putString(value string) {
+	e.putInt32(int32(len(value)))
+	e.WriteString(value)
+}
+
+// decoder reads jute records, the first error is kept and makes further reads return zero values
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errors.New("Malformed ZooKeeper response")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) readInt32() int32 {
+	if b := d.next(4); d.err == nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) readInt64() int64 {
+	if b := d.next(8); d.err == nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) readBool() bool {
+	b := d.next(1)
+	return d.err == nil && b[0] != 0
+}
+
+func (d *decoder) readBuffer() []byte {
+	size := d.readInt32()
+	if size < 0 {
+		return nil
+	}
+	return d.next(int(size))
+}
+
+func (d *decoder) readString() string {
+	return string(d.readBuffer())
+}
@@ -0,0 +1,272 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-consul/apps"
+	"github.com/allegro/marathon-consul/metrics"
+	"github.com/allegro/marathon-consul/service"
+	"github.com/allegro/marathon-consul/utils"
+)
+
+// ZooKeeper is a service registry keeping service instances in ZooKeeper, or a KV store speaking its protocol,
+// under a configured path. Every instance is stored as a JSON document in an ephemeral node
+// <prefix>/<service name>/<service id>, so registrations of a dead marathon-consul are removed
+// when its session expires instead of lingering forever.
+type ZooKeeper struct {
+	config         Config
+	nameSeparator  string
+	tagLabelPrefix string
+	session        *session
+}
+
+type instance struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Port    int      `json:"port"`
+	Tags    []string `json:"tags"`
+}
+
+func New(config Config, nameSeparator string, tagLabelPrefix string) *ZooKeeper {
+	var servers []string
+	for _, server := range strings.Split(config.Servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return &ZooKeeper{
+		config:         config,
+		nameSeparator:  nameSeparator,
+		tagLabelPrefix: tagLabelPrefix,
+		session:        newSession(servers, config.SessionTimeout.Duration, config.Timeout.Duration),
+	}
+}
+
+func (z *ZooKeeper) GetAllServices() ([]*service.Service, error) {
+	names, err := z.session.getChildren(z.prefix())
+	if err == errNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var services []*service.Service
+	for _, name := range names {
+		named, err := z.GetServices(name)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, named...)
+	}
+	return services, nil
+}
+
+func (z *ZooKeeper) GetServices(name string) ([]*service.Service, error) {
+	servicePath := z.servicePath(name)
+	ids, err := z.session.getChildren(servicePath)
+	if err == errNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var services []*service.Service
+	for _, id := range ids {
+		data, err := z.session.getData(path.Join(servicePath, id))
+		if err == errNoNode {
+			// deregistered in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		i := instance{}
+		if err := json.Unmarshal(data, &i); err != nil {
+			log.WithError(err).WithField("Path", path.Join(servicePath, id)).Warn("Skipping malformed service instance")
+			continue
+		}
+		services = append(services, i.toService())
+	}
+	return services, nil
+}
+
+func (z *ZooKeeper) Register(task *apps.Task, app *apps.App) error {
+	var err error
+	metrics.Time("zookeeper.register", func() { err = z.register(task, app) })
+	if err != nil {
+		metrics.Mark("zookeeper.register.error")
+	} else {
+		metrics.Mark("zookeeper.register.success")
+	}
+	return err
+}
+
+func (z *ZooKeeper) register(task *apps.Task, app *apps.App) error {
+	instances, err := z.taskInstances(task, app)
+	if err != nil {
+		return err
+	}
+
+	var registerErrors []error
+	for _, i := range instances {
+		fields := log.Fields{
+			"Name":    i.Name,
+			"Id":      i.ID,
+			"Tags":    i.Tags,
+			"Address": i.Address,
+			"Port":    i.Port,
+		}
+		log.WithFields(fields).Info("Registering")
+		if err := z.put(i); err != nil {
+			log.WithError(err).WithFields(fields).Error("Unable to register")
+			registerErrors = append(registerErrors, err)
+		}
+	}
+	return utils.MergeErrorsOrNil(registerErrors, "registering services")
+}
+
+func (z *ZooKeeper) ExpectedServices(task *apps.Task, app *apps.App) ([]*service.Service, error) {
+	instances, err := z.taskInstances(task, app)
+	if err != nil {
+		return nil, err
+	}
+	var services []*service.Service
+	for _, i := range instances {
+		services = append(services, i.toService())
+	}
+	return services, nil
+}
+
+func (z *ZooKeeper) taskInstances(task *apps.Task, app *apps.App) ([]instance, error) {
+	IP, err := utils.HostToIPv4(task.Host)
+	if err != nil {
+		return nil, err
+	}
+	var instances []instance
+	for _, intent := range app.RegistrationIntents(task, z.nameSeparator, z.tagLabelPrefix) {
+		instances = append(instances, instance{
+			ID:      fmt.Sprintf("%s_%s_%d", task.ID, intent.Name, intent.Port),
+			Name:    intent.Name,
+			Address: IP.String(),
+			Port:    intent.Port,
+			Tags:    append(append([]string{}, intent.Tags...), service.MarathonTaskTag(task.ID)),
+		})
+	}
+	return instances, nil
+}
+
+func (i instance) toService() *service.Service {
+	return &service.Service{
+		ID:                      service.ServiceId(i.ID),
+		Name:                    i.Name,
+		Tags:                    i.Tags,
+		RegisteringAgentAddress: i.Address,
+		Address:                 i.Address,
+		Port:                    i.Port,
+	}
+}
+
+func (z *ZooKeeper) DeregisterByTask(taskID apps.TaskID) error {
+	services, err := z.GetAllServices()
+	if err != nil {
+		return err
+	}
+	var deregisterErrors []error
+	found := false
+	for _, s := range services {
+		if id, err := s.TaskId(); err != nil || id != taskID {
+			continue
+		}
+		found = true
+		if err := z.Deregister(s); err != nil {
+			deregisterErrors = append(deregisterErrors, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("Couldn't find any service matching task id %s", taskID)
+	}
+	return utils.MergeErrorsOrNil(deregisterErrors, fmt.Sprintf("deregistering by task %s", taskID))
+}
+
+func (z *ZooKeeper) Deregister(toDeregister *service.Service) error {
+	var err error
+	metrics.Time("zookeeper.deregister", func() { err = z.deregister(toDeregister) })
+	if err != nil {
+		metrics.Mark("zookeeper.deregister.error")
+	} else {
+		metrics.Mark("zookeeper.deregister.success")
+	}
+	return err
+}
+
+func (z *ZooKeeper) deregister(toDeregister *service.Service) error {
+	log.WithField("Id", toDeregister.ID).WithField("Address", toDeregister.RegisteringAgentAddress).Info("Deregistering")
+	err := z.session.delete(z.instancePath(toDeregister.Name, toDeregister.ID.String()))
+	if err == errNoNode {
+		return nil
+	}
+	if err != nil {
+		log.WithError(err).WithField("Id", toDeregister.ID).WithField("Address", toDeregister.RegisteringAgentAddress).Error("Unable to deregister")
+	}
+	return err
+}
+
+func (z *ZooKeeper) EnableMaintenance(toMaintain *service.Service, reason string) error {
+	return errors.New("Maintenance mode is not supported by zookeeper registry")
+}
+
+func (z *ZooKeeper) EnableMaintenanceByTask(taskID apps.TaskID, reason string) error {
+	return errors.New("Maintenance mode is not supported by zookeeper registry")
+}
+
+func (z *ZooKeeper) DisableMaintenance(toMaintain *service.Service) error {
+	return errors.New("Maintenance mode is not supported by zookeeper registry")
+}
+
+func (z *ZooKeeper) prefix() string {
+	return path.Join("/", z.config.Prefix)
+}
+
+func (z *ZooKeeper) servicePath(name string) string {
+	return path.Join(z.prefix(), name)
+}
+
+func (z *ZooKeeper) instancePath(name, id string) string {
+	return path.Join(z.servicePath(name), id)
+}
+
+// put stores the instance in an ephemeral node of the current session. A node left by an older session
+// is replaced, as it would be removed once that session expires.
+func (z *ZooKeeper) put(i instance) error {
+	value, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	if err := z.createParents(z.servicePath(i.Name)); err != nil {
+		return err
+	}
+	instancePath := z.instancePath(i.Name, i.ID)
+	err = z.session.create(instancePath, value, ephemeralNode)
+	if err != errNodeExists {
+		return err
+	}
+	if err := z.session.delete(instancePath); err != nil && err != errNoNode {
+		return err
+	}
+	return z.session.create(instancePath, value, ephemeralNode)
+}
+
+// createParents creates persistent nodes of the path and all its ancestors that do not exist yet
+func (z *ZooKeeper) createParents(nodePath string) error {
+	current := ""
+	for _, part := range strings.Split(strings.Trim(nodePath, "/"), "/") {
+		current += "/" + part
+		if err := z.session.create(current, []byte{}, persistentNode); err != nil && err != errNodeExists {
+			return err
+		}
+	}
+	return nil
+}
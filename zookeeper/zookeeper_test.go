@@ -0,0 +1,467 @@
+package zookeeper
+
+import (
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-consul/service"
+	timeutil "github.com/allegro/marathon-consul/time"
+	"github.com/allegro/marathon-consul/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: server.address(), Prefix: "/services"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 2)
+
+	// when
+	for _, task := range app.Tasks {
+		err := zk.Register(&task, app)
+		assert.NoError(t, err)
+	}
+
+	// then
+	assert.Equal(t, []string{
+		"/services",
+		"/services/test.app",
+		"/services/test.app/test_app.0_test.app_8080",
+		"/services/test.app/test_app.1_test.app_8081",
+	}, server.paths())
+	assert.True(t, server.ephemeral("/services/test.app/test_app.0_test.app_8080"))
+	assert.False(t, server.ephemeral("/services/test.app"))
+
+	// when
+	services, err := zk.GetServices("test.app")
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, services, 2)
+	assert.Equal(t, "test.app", services[0].Name)
+	assert.Equal(t, "127.0.0.1", services[0].RegisteringAgentAddress)
+	assert.Equal(t, []string{"marathon-task:test_app.0"}, services[0].Tags)
+}
+
+func TestRegister_ForInvalidHost(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	app.Tasks[0].Host = "invalid.host.local"
+
+	// when
+	err := zk.Register(&app.Tasks[0], app)
+
+	// then
+	assert.Error(t, err)
+	assert.Empty(t, server.paths())
+}
+
+func TestRegister_ShouldReplaceNodeOfOtherSession(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	app := utils.ConsulApp("/test/app", 1)
+	previous := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+	require.NoError(t, previous.Register(&app.Tasks[0], app))
+	zk := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+
+	// when
+	err := zk.Register(&app.Tasks[0], app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, zk.session.id, server.owner("/services/test.app/test_app.0_test.app_8080"))
+}
+
+func TestGetAllServices_ShouldFailoverToNextServer(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: "127.5.5.5:5555, " + server.address(), Prefix: "services",
+		Timeout: timeutil.Interval{Duration: time.Second}}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	zk.Register(&app.Tasks[0], app)
+
+	// when
+	services, err := zk.GetAllServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, services, 1)
+}
+
+func TestGetAllServices_WithoutServers(t *testing.T) {
+	t.Parallel()
+	// given
+	zk := New(Config{Prefix: "services"}, ".", "consul-tag-")
+
+	// when
+	services, err := zk.GetAllServices()
+
+	// then
+	assert.Error(t, err)
+	assert.Nil(t, services)
+}
+
+func TestGetAllServices_WithoutRegistrations(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+
+	// when
+	services, err := zk.GetAllServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+func TestDeregisterByTask(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+	app := utils.ConsulAppMultipleRegistrations("/test/app", 2, 2)
+	for _, task := range app.Tasks {
+		zk.Register(&task, app)
+	}
+
+	// when
+	err := zk.DeregisterByTask(app.Tasks[0].ID)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/services",
+		"/services/test.app",
+		"/services/test.app/test_app.1_test.app_8081",
+		"/services/test.app/test_app.1_test.app_8083",
+	}, server.paths())
+
+	// when
+	err = zk.DeregisterByTask(app.Tasks[0].ID)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestDeregister(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	zk.Register(&app.Tasks[0], app)
+
+	// when
+	err := zk.Deregister(&service.Service{ID: "test_app.0_test.app_8080", Name: "test.app"})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/services", "/services/test.app"}, server.paths())
+
+	// when
+	err = zk.Deregister(&service.Service{ID: "test_app.0_test.app_8080", Name: "test.app"})
+
+	// then
+	assert.NoError(t, err)
+}
+
+func TestSession_ShouldBeResumedAfterConnectionLoss(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	require.NoError(t, zk.Register(&app.Tasks[0], app))
+	session := zk.session.id
+
+	// when
+	server.dropConnections()
+	services, err := zk.GetAllServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, session, zk.session.id)
+}
+
+func TestSession_ShouldBeEstablishedAgainAfterExpiry(t *testing.T) {
+	t.Parallel()
+	// given
+	server := newZooKeeperServer(t)
+	defer server.Close()
+	zk := New(Config{Servers: server.address(), Prefix: "services"}, ".", "consul-tag-")
+	app := utils.ConsulApp("/test/app", 1)
+	require.NoError(t, zk.Register(&app.Tasks[0], app))
+	expired := zk.session.id
+
+	// when
+	server.expireSessions()
+	services, err := zk.GetAllServices()
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+	assert.NotEqual(t, expired, zk.session.id)
+
+	// when
+	err = zk.Register(&app.Tasks[0], app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, zk.session.id, server.owner("/services/test.app/test_app.0_test.app_8080"))
+}
+
+func TestMaintenance_ShouldNotBeSupported(t *testing.T) {
+	t.Parallel()
+	// given
+	zk := New(Config{Prefix: "services"}, ".", "consul-tag-")
+
+	// expect
+	assert.Error(t, zk.EnableMaintenance(&service.Service{}, "reason"))
+	assert.Error(t, zk.EnableMaintenanceByTask("task", "reason"))
+	assert.Error(t, zk.DisableMaintenance(&service.Service{}))
+}
+
+// zooKeeperServer is a minimal stand-in for a ZooKeeper server keeping nodes in memory
+type zooKeeperServer struct {
+	sync.Mutex
+	listener    net.Listener
+	nodes       map[string]zooKeeperNode
+	sessions    map[int64]bool
+	lastSession int64
+	conns       []net.Conn
+}
+
+type zooKeeperNode struct {
+	data  []byte
+	owner int64
+}
+
+func newZooKeeperServer(t *testing.T) *zooKeeperServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &zooKeeperServer{listener: listener, nodes: make(map[string]zooKeeperNode), sessions: make(map[int64]bool)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.Lock()
+			s.conns = append(s.conns, conn)
+			s.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *zooKeeperServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *zooKeeperServer) Close() {
+	s.listener.Close()
+	s.dropConnections()
+}
+
+func (s *zooKeeperServer) paths() []string {
+	s.Lock()
+	defer s.Unlock()
+	paths := []string{}
+	for p := range s.nodes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *zooKeeperServer) ephemeral(nodePath string) bool {
+	return s.owner(nodePath) != 0
+}
+
+func (s *zooKeeperServer) owner(nodePath string) int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.nodes[nodePath].owner
+}
+
+func (s *zooKeeperServer) dropConnections() {
+	s.Lock()
+	defer s.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *zooKeeperServer) expireSessions() {
+	s.Lock()
+	for p, node := range s.nodes {
+		if node.owner != 0 {
+			delete(s.nodes, p)
+		}
+	}
+	s.sessions = make(map[int64]bool)
+	s.Unlock()
+	s.dropConnections()
+}
+
+func (s *zooKeeperServer) serve(conn net.Conn) {
+	defer conn.Close()
+	frame, err := readFrame(conn)
+	if err != nil {
+		return
+	}
+	d := &decoder{data: frame}
+	d.readInt32()
+	d.readInt64()
+	timeout := d.readInt32()
+	session := d.readInt64()
+
+	// ZooKeeper negotiates at least twice its default tick
+	if timeout < 4000 {
+		timeout = 4000
+	}
+	s.Lock()
+	if session == 0 {
+		s.lastSession++
+		session = s.lastSession
+		s.sessions[session] = true
+	} else if !s.sessions[session] {
+		timeout = 0
+		session = 0
+	}
+	s.Unlock()
+	e := &encoder{}
+	e.putInt32(0)
+	e.putInt32(timeout)
+	e.putInt64(session)
+	e.putBuffer(make([]byte, 16))
+	if writeFrame(conn, e.Bytes()) != nil || session == 0 {
+		return
+	}
+
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		d := &decoder{data: frame}
+		xid := d.readInt32()
+		op := d.readInt32()
+		reply := &encoder{}
+		code := s.handle(session, op, d, reply)
+		e := &encoder{}
+		e.putInt32(xid)
+		e.putInt64(1)
+		e.putInt32(int32(code))
+		if code == 0 {
+			e.Write(reply.Bytes())
+		}
+		if writeFrame(conn, e.Bytes()) != nil {
+			return
+		}
+	}
+}
+
+func (s *zooKeeperServer) handle(session int64, op int32, d *decoder, reply *encoder) zkError {
+	s.Lock()
+	defer s.Unlock()
+	switch op {
+	case opCreate:
+		nodePath := d.readString()
+		data := d.readBuffer()
+		for i := d.readInt32(); i > 0; i-- {
+			d.readInt32()
+			d.readString()
+			d.readString()
+		}
+		mode := d.readInt32()
+		if _, ok := s.nodes[nodePath]; ok {
+			return errNodeExists
+		}
+		if parent := path.Dir(nodePath); parent != "/" {
+			if _, ok := s.nodes[parent]; !ok {
+				return errNoNode
+			}
+		}
+		node := zooKeeperNode{data: data}
+		if mode == ephemeralNode {
+			node.owner = session
+		}
+		s.nodes[nodePath] = node
+		reply.putString(nodePath)
+	case opDelete:
+		nodePath := d.readString()
+		if _, ok := s.nodes[nodePath]; !ok {
+			return errNoNode
+		}
+		if len(s.children(nodePath)) > 0 {
+			return errNotEmpty
+		}
+		delete(s.nodes, nodePath)
+	case opGetData:
+		nodePath := d.readString()
+		d.readBool()
+		node, ok := s.nodes[nodePath]
+		if !ok {
+			return errNoNode
+		}
+		reply.putBuffer(node.data)
+		// stat
+		reply.Write(make([]byte, 68))
+	case opGetChildren:
+		nodePath := d.readString()
+		d.readBool()
+		if _, ok := s.nodes[nodePath]; !ok && nodePath != "/" {
+			return errNoNode
+		}
+		children := s.children(nodePath)
+		reply.putInt32(int32(len(children)))
+		for _, child := range children {
+			reply.putString(child)
+		}
+	}
+	return 0
+}
+
+func (s *zooKeeperServer) children(parent string) []string {
+	var children []string
+	for p := range s.nodes {
+		if path.Dir(p) == parent && p != parent {
+			children = append(children, strings.TrimPrefix(p[len(parent):], "/"))
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+func writeFrame(conn net.Conn, payload []byte) error {
+	e := &encoder{}
+	e.putInt32(int32(len(payload)))
+	e.Write(payload)
+	_, err := conn.Write(e.Bytes())
+	return err
+}